@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"mux/route"
+)
+
+type APIKeyConfig struct {
+	Keys APIKeyStore
+	//读取key的头部，default:X-API-Key
+	Header string
+	//不为空时也从这个URL参数中读取，URL会出现在日志中，应当优先使用头部
+	Query string
+	//default:Restricted
+	Realm string
+	//认证失败时调用，default:返回401
+	ErrorHandler func(c *route.Context, err error)
+}
+
+//API key认证
+//
+//	keys := auth.NewAPIKeys()
+//	keys.Add(key, &route.Principal{ID: "partner-a", Roles: []string{"partner"}})
+//	api := m.Group("/partner", auth.APIKey(auth.APIKeyConfig{Keys: keys}))
+func APIKey(conf APIKeyConfig) route.HandlerFunc {
+	if conf.Header == "" {
+		conf.Header = "X-API-Key"
+	}
+	if conf.Realm == "" {
+		conf.Realm = "Restricted"
+	}
+	challenge := "APIKey realm=" + quote(conf.Realm)
+	if conf.ErrorHandler == nil {
+		conf.ErrorHandler = func(c *route.Context, err error) {
+			Unauthorized(c, challenge)
+		}
+	}
+	return func(c *route.Context) {
+		key := c.HeaderGet(conf.Header)
+		if key == "" && conf.Query != "" {
+			key = c.Query(conf.Query)
+		}
+		if key == "" {
+			conf.ErrorHandler(c, ErrNoCredentials)
+			c.Abort()
+			return
+		}
+		p, err := conf.Keys.LookupAPIKey(key)
+		if err != nil || p == nil {
+			if err == nil {
+				err = ErrBadCredentials
+			}
+			conf.ErrorHandler(c, err)
+			c.Abort()
+			return
+		}
+		//store中的principal是共享的，复制一份再写入认证方式
+		cp := *p
+		cp.Method = "apikey"
+		c.SetPrincipal(&cp)
+		c.Next()
+	}
+}
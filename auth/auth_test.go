@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"hash"
+	"mux/route"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAuthRoute(mw route.HandlerFunc) *route.Route {
+	r := route.New(&route.Config{}, nil)
+	r.GET("/private", mw, func(c *route.Context) {
+		p := c.Principal()
+		c.WriteString(http.StatusOK, p.Method+":"+p.ID+":"+strings.Join(p.Roles, ","))
+	})
+	return r
+}
+
+func serve(r *route.Route, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.Run(w, req)
+	return w
+}
+
+func TestBasic(t *testing.T) {
+	users := NewUsers(&User{Name: "admin", Password: "s3cret", Roles: []string{"admin"}})
+	r := newAuthRoute(Basic(BasicConfig{Users: users, Realm: "tools"}))
+	for _, c := range []struct {
+		user, password string
+		set            bool
+		code           int
+	}{
+		{"admin", "s3cret", true, http.StatusOK},
+		{"admin", "wrong", true, http.StatusUnauthorized},
+		{"nobody", "", true, http.StatusUnauthorized},
+		{"", "", false, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/private", nil)
+		if c.set {
+			req.SetBasicAuth(c.user, c.password)
+		}
+		w := serve(r, req)
+		if w.Code != c.code {
+			t.Errorf("%s/%s: status = %d, want %d", c.user, c.password, w.Code, c.code)
+		}
+		if c.code == http.StatusOK && w.Body.String() != "basic:admin:admin" {
+			t.Errorf("principal = %q", w.Body.String())
+		}
+		if c.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Basic realm="tools", charset="UTF-8"` {
+			t.Errorf("challenge = %q", w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	keys := NewAPIKeys()
+	keys.Add("k-123", &route.Principal{ID: "partner", Roles: []string{"partner"}})
+	r := newAuthRoute(APIKey(APIKeyConfig{Keys: keys, Query: "api_key"}))
+
+	req := httptest.NewRequest("GET", "/private", nil)
+	req.Header.Set("X-API-Key", "k-123")
+	if w := serve(r, req); w.Code != http.StatusOK || w.Body.String() != "apikey:partner:partner" {
+		t.Fatalf("header: %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(r, httptest.NewRequest("GET", "/private?api_key=k-123", nil)); w.Code != http.StatusOK {
+		t.Fatalf("query: %d", w.Code)
+	}
+	if w := serve(r, httptest.NewRequest("GET", "/private?api_key=k-124", nil)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid key: %d", w.Code)
+	}
+}
+
+//按照challenge计算客户端的Authorization
+func digestAuth(t *testing.T, challenge, user, password, uri, nc string) string {
+	params := parseParams(strings.TrimPrefix(challenge, "Digest "))
+	var h func() hash.Hash = md5.New
+	if params["algorithm"] == DigestSHA256 {
+		h = sha256.New
+	}
+	ha1 := hexHash(h, user+":"+params["realm"]+":"+password)
+	ha2 := hexHash(h, "GET:"+uri)
+	resp := hexHash(h, ha1+":"+params["nonce"]+":"+nc+":cn:auth:"+ha2)
+	return `Digest username="` + user + `", realm="` + params["realm"] + `", nonce="` + params["nonce"] +
+		`", uri="` + uri + `", qop=auth, nc=` + nc + `, cnonce="cn", algorithm=` + params["algorithm"] + `, response="` + resp + `"`
+}
+
+func TestDigest(t *testing.T) {
+	users := NewUsers(&User{Name: "ops", Password: "pw", Roles: []string{"ops"}})
+	d := newDigest(DigestConfig{Users: users, Realm: "internal"})
+	r := newAuthRoute(d.handle)
+
+	w := serve(r, httptest.NewRequest("GET", "/private", nil))
+	challenges := w.Header()["Www-Authenticate"]
+	if w.Code != http.StatusUnauthorized || len(challenges) != 2 {
+		t.Fatalf("challenge: %d %v", w.Code, challenges)
+	}
+	//两个challenge使用同一个nonce，nc需要递增
+	for i, ch := range challenges {
+		nc := []string{"00000001", "00000003"}[i]
+		req := httptest.NewRequest("GET", "/private", nil)
+		req.Header.Set("Authorization", digestAuth(t, ch, "ops", "pw", "/private", nc))
+		if w := serve(r, req); w.Code != http.StatusOK || w.Body.String() != "digest:ops:ops" {
+			t.Fatalf("%s: %d %q", ch, w.Code, w.Body.String())
+		}
+		//同一个nc重放
+		if w := serve(r, req); w.Code != http.StatusUnauthorized {
+			t.Fatalf("replay accepted: %d", w.Code)
+		}
+		req.Header.Set("Authorization", digestAuth(t, ch, "ops", "wrong", "/private", "0000000a"))
+		if w := serve(r, req); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password: %d", w.Code)
+		}
+	}
+
+	stale := `Digest realm="internal", qop="auth", algorithm=MD5, nonce="` + d.nonce(time.Now().Add(-time.Hour)) + `"`
+	req := httptest.NewRequest("GET", "/private", nil)
+	req.Header.Set("Authorization", digestAuth(t, stale, "ops", "pw", "/private", "00000001"))
+	w = serve(r, req)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "stale=true") {
+		t.Fatalf("stale nonce: %d %v", w.Code, w.Header()["Www-Authenticate"])
+	}
+}
+
+func TestDigestTwoUsers(t *testing.T) {
+	users := NewUsers(&User{Name: "alice", Password: "a"}, &User{Name: "bob", Password: "b"})
+	d := newDigest(DigestConfig{Users: users, Algorithms: []string{DigestSHA256}})
+	r := newAuthRoute(d.handle)
+
+	//同一秒内的两次challenge使用不同的nonce
+	first := serve(r, httptest.NewRequest("GET", "/private", nil)).Header().Get("WWW-Authenticate")
+	second := serve(r, httptest.NewRequest("GET", "/private", nil)).Header().Get("WWW-Authenticate")
+	if parseParams(first)["nonce"] == parseParams(second)["nonce"] {
+		t.Fatal("two challenges got the same nonce")
+	}
+	//即使两个用户使用了同一个nonce，各自的nc=1都不是重放
+	for _, u := range []struct{ name, password string }{{"alice", "a"}, {"bob", "b"}} {
+		req := httptest.NewRequest("GET", "/private", nil)
+		req.Header.Set("Authorization", digestAuth(t, first, u.name, u.password, "/private", "00000001"))
+		if w := serve(r, req); w.Code != http.StatusOK {
+			t.Fatalf("%s: %d", u.name, w.Code)
+		}
+		if w := serve(r, req); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s replay accepted: %d", u.name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"mux/route"
+)
+
+type BasicConfig struct {
+	Users UserStore
+	//default:Restricted
+	Realm string
+	//认证失败时调用，default:返回401与WWW-Authenticate
+	ErrorHandler func(c *route.Context, err error)
+}
+
+//HTTP basic认证，只应当在https中使用
+//
+//	admin := m.Group("/admin", auth.Basic(auth.BasicConfig{Users: auth.NewUsers(&auth.User{Name: "admin", Password: pw})}))
+func Basic(conf BasicConfig) route.HandlerFunc {
+	if conf.Realm == "" {
+		conf.Realm = "Restricted"
+	}
+	challenge := "Basic realm=" + quote(conf.Realm) + `, charset="UTF-8"`
+	if conf.ErrorHandler == nil {
+		conf.ErrorHandler = func(c *route.Context, err error) {
+			Unauthorized(c, challenge)
+		}
+	}
+	return func(c *route.Context) {
+		name, password, ok := c.Request().BasicAuth()
+		if !ok {
+			conf.ErrorHandler(c, ErrNoCredentials)
+			c.Abort()
+			return
+		}
+		u, err := conf.Users.LookupUser(name)
+		if err != nil && err != ErrUnknownUser {
+			conf.ErrorHandler(c, err)
+			c.Abort()
+			return
+		}
+		//用户不存在时也比较一次，响应时间不会暴露用户是否存在
+		want := ""
+		if u != nil {
+			want = u.Password
+		}
+		if !Equal(password, want) || u == nil || u.Password == "" {
+			conf.ErrorHandler(c, ErrBadCredentials)
+			c.Abort()
+			return
+		}
+		c.SetPrincipal(u.principal("basic"))
+		c.Next()
+	}
+}
@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+	"mux/route"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//digest支持的算法
+const (
+	DigestMD5    = "MD5"
+	DigestSHA256 = "SHA-256"
+)
+
+type DigestConfig struct {
+	Users UserStore
+	//default:Restricted
+	Realm string
+	//按照顺序发送challenge，default:SHA-256、MD5
+	Algorithms []string
+	//nonce的有效期，过期后客户端会收到stale=true并自动重试，default:5m
+	NonceTTL time.Duration
+	//签名nonce的密钥，多个实例之间共享nonce时需要设置为相同的值，default:随机生成
+	Secret []byte
+	//认证失败时调用，default:返回401与WWW-Authenticate
+	ErrorHandler func(c *route.Context, err error)
+}
+
+//HTTP digest认证（RFC 7616），只支持qop=auth
+//nonce是无状态的，同一个nonce的nc必须递增，重放的请求会被拒绝
+func Digest(conf DigestConfig) route.HandlerFunc {
+	d := newDigest(conf)
+	return d.handle
+}
+
+type digest struct {
+	conf DigestConfig
+
+	mu sync.Mutex
+	//每个客户端最后使用的nc，nonce过期后删除
+	counts map[countKey]uint64
+}
+
+//同一个nonce可能被多个用户或者同一个用户的多个cnonce使用，nc分别递增
+type countKey struct {
+	nonce, username, cnonce string
+}
+
+func newDigest(conf DigestConfig) *digest {
+	if conf.Realm == "" {
+		conf.Realm = "Restricted"
+	}
+	if len(conf.Algorithms) == 0 {
+		conf.Algorithms = []string{DigestSHA256, DigestMD5}
+	}
+	if conf.NonceTTL <= 0 {
+		conf.NonceTTL = 5 * time.Minute
+	}
+	if conf.Secret == nil {
+		conf.Secret = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, conf.Secret); err != nil {
+			panic("auth: failed to generate digest secret: " + err.Error())
+		}
+	}
+	d := &digest{conf: conf, counts: make(map[countKey]uint64)}
+	if d.conf.ErrorHandler == nil {
+		d.conf.ErrorHandler = func(c *route.Context, err error) {
+			Unauthorized(c, d.challenges(err == ErrStaleNonce)...)
+		}
+	}
+	return d
+}
+
+func (d *digest) challenges(stale bool) []string {
+	nonce := d.nonce(time.Now())
+	out := make([]string, 0, len(d.conf.Algorithms))
+	for _, alg := range d.conf.Algorithms {
+		ch := "Digest realm=" + quote(d.conf.Realm) + `, qop="auth", algorithm=` + alg + ", nonce=" + quote(nonce)
+		if stale {
+			ch += ", stale=true"
+		}
+		out = append(out, ch)
+	}
+	return out
+}
+
+//nonce中签发时间与签名之间随机部分的长度
+const nonceRandom = 16
+
+//nonce为签发时间、随机数与签名，同一秒内签发的nonce也各不相同
+func (d *digest) nonce(now time.Time) string {
+	b := make([]byte, 8+nonceRandom, 8+nonceRandom+sha256.Size)
+	binary.BigEndian.PutUint64(b, uint64(now.Unix()))
+	if _, err := io.ReadFull(rand.Reader, b[8:]); err != nil {
+		panic("auth: failed to generate digest nonce: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(append(b, d.signNonce(b)...))
+}
+
+func (d *digest) signNonce(data []byte) []byte {
+	mac := hmac.New(sha256.New, d.conf.Secret)
+	mac.Write(data)
+	mac.Write([]byte(d.conf.Realm))
+	return mac.Sum(nil)
+}
+
+//校验nonce的签名与有效期，返回签发时间
+func (d *digest) checkNonce(nonce string, now time.Time) (time.Time, error) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 8+nonceRandom+sha256.Size || !hmac.Equal(b[8+nonceRandom:], d.signNonce(b[:8+nonceRandom])) {
+		return time.Time{}, ErrBadCredentials
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(b)), 0)
+	if now.Sub(issued) > d.conf.NonceTTL {
+		return issued, ErrStaleNonce
+	}
+	return issued, nil
+}
+
+//nc必须比同一个客户端在这个nonce上之前使用的大
+func (d *digest) checkCount(key countKey, nc uint64, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if last, ok := d.counts[key]; ok && nc <= last {
+		return false
+	}
+	if len(d.counts) > 1024 {
+		for k := range d.counts {
+			if _, err := d.checkNonce(k.nonce, now); err != nil {
+				delete(d.counts, k)
+			}
+		}
+	}
+	d.counts[key] = nc
+	return true
+}
+
+func (d *digest) handle(c *route.Context) {
+	auth := c.HeaderGet("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Digest ") {
+		d.conf.ErrorHandler(c, ErrNoCredentials)
+		c.Abort()
+		return
+	}
+	p, err := d.authenticate(c, parseParams(auth[7:]))
+	if err != nil {
+		d.conf.ErrorHandler(c, err)
+		c.Abort()
+		return
+	}
+	c.SetPrincipal(p)
+	c.Next()
+}
+
+func (d *digest) authenticate(c *route.Context, params map[string]string) (*route.Principal, error) {
+	alg := params["algorithm"]
+	if alg == "" {
+		alg = DigestMD5
+	}
+	var h func() hash.Hash
+	switch {
+	case strings.EqualFold(alg, DigestMD5):
+		alg, h = DigestMD5, md5.New
+	case strings.EqualFold(alg, DigestSHA256):
+		alg, h = DigestSHA256, sha256.New
+	default:
+		return nil, ErrBadCredentials
+	}
+	if !contains(d.conf.Algorithms, alg) || params["realm"] != d.conf.Realm || params["qop"] != "auth" {
+		return nil, ErrBadCredentials
+	}
+	//uri必须是这次请求的地址，防止把其他地址的响应挪过来使用
+	if params["uri"] != c.Request().RequestURI {
+		return nil, ErrBadCredentials
+	}
+	now := time.Now()
+	if _, err := d.checkNonce(params["nonce"], now); err != nil {
+		return nil, err
+	}
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil || params["cnonce"] == "" {
+		return nil, ErrBadCredentials
+	}
+
+	u, err := d.conf.Users.LookupUser(params["username"])
+	if err != nil && err != ErrUnknownUser {
+		return nil, err
+	}
+	ha1 := ""
+	if u != nil {
+		if alg == DigestMD5 && u.HA1 != "" {
+			ha1 = strings.ToLower(u.HA1)
+		} else if u.Password != "" {
+			ha1 = hexHash(h, u.Name+":"+d.conf.Realm+":"+u.Password)
+		}
+	}
+	ha2 := hexHash(h, c.Method()+":"+params["uri"])
+	want := hexHash(h, ha1+":"+params["nonce"]+":"+params["nc"]+":"+params["cnonce"]+":auth:"+ha2)
+	//用户不存在时也计算并比较一次
+	if !Equal(strings.ToLower(params["response"]), want) || u == nil || ha1 == "" {
+		return nil, ErrBadCredentials
+	}
+	if !d.checkCount(countKey{params["nonce"], u.Name, params["cnonce"]}, nc, now) {
+		return nil, ErrBadCredentials
+	}
+	return u.principal("digest"), nil
+}
+
+func hexHash(h func() hash.Hash, s string) string {
+	x := h()
+	x.Write([]byte(s))
+	return hex.EncodeToString(x.Sum(nil))
+}
+
+//解析key=value, key="quoted value"形式的参数
+func parseParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			val = b.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			val = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[key] = val
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
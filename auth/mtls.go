@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"mux/route"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//没有经过校验的客户端证书
+var ErrNoClientCert = errors.New("auth: no verified client certificate")
+
+type ClientCertConfig struct {
+	//不为空时由中间件校验客户端证书，用于服务端的ClientAuth为request或者require的情况
+	//为空时只接受服务端已经校验过的证书（verify_if_given或者require_and_verify）
+	Roots *x509.CertPool
+	//把证书转换为principal，default:DefaultCertPrincipal
+	Map func(cert *x509.Certificate) (*route.Principal, error)
+	//认证失败时调用，default:返回401
+	ErrorHandler func(c *route.Context, err error)
+}
+
+//客户端证书认证，把证书的身份写入Context.Principal，Method为mtls
+//
+//	m.ClientCAs = pool
+//	internal := m.Group("/internal", auth.ClientCert(auth.ClientCertConfig{})).
+//		Authorize(auth.RequireSPIFFE("spiffe://example.org/ns/prod/sa/*"))
+func ClientCert(conf ClientCertConfig) route.HandlerFunc {
+	if conf.Map == nil {
+		conf.Map = DefaultCertPrincipal
+	}
+	if conf.ErrorHandler == nil {
+		conf.ErrorHandler = func(c *route.Context, err error) {
+			http.Error(c.Writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+	}
+	return func(c *route.Context) {
+		cert, err := peerCert(c.Request(), conf.Roots)
+		if err == nil {
+			var p *route.Principal
+			if p, err = conf.Map(cert); err == nil {
+				c.SetPrincipal(p)
+				c.Next()
+				return
+			}
+		}
+		conf.ErrorHandler(c, err)
+		c.Abort()
+	}
+}
+
+func peerCert(r *http.Request, roots *x509.CertPool) (*x509.Certificate, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, ErrNoClientCert
+	}
+	if roots == nil {
+		if len(r.TLS.VerifiedChains) == 0 {
+			return nil, ErrNoClientCert
+		}
+		return r.TLS.VerifiedChains[0][0], nil
+	}
+	certs := r.TLS.PeerCertificates
+	inter := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		inter.AddCert(c)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, ErrNoClientCert
+	}
+	return certs[0], nil
+}
+
+//principal的ID依次使用SPIFFE ID、第一个URI SAN、第一个DNS SAN与subject的CN
+//Attributes中保存了uris、dns、subject与证书本身（cert）
+func DefaultCertPrincipal(cert *x509.Certificate) (*route.Principal, error) {
+	uris := make([]string, len(cert.URIs))
+	for i, u := range cert.URIs {
+		uris[i] = u.String()
+	}
+	id := ""
+	for _, u := range uris {
+		if strings.HasPrefix(u, "spiffe://") {
+			id = u
+			break
+		}
+	}
+	switch {
+	case id != "":
+	case len(uris) > 0:
+		id = uris[0]
+	case len(cert.DNSNames) > 0:
+		id = cert.DNSNames[0]
+	default:
+		id = cert.Subject.CommonName
+	}
+	if id == "" {
+		return nil, ErrNoClientCert
+	}
+	return &route.Principal{
+		ID:     id,
+		Method: "mtls",
+		Attributes: map[string]interface{}{
+			"uris":    uris,
+			"dns":     cert.DNSNames,
+			"subject": cert.Subject.String(),
+			"cert":    cert,
+		},
+	}, nil
+}
+
+//要求客户端证书的SPIFFE ID匹配其中一个模式
+//模式使用path.Match的语法，*不会匹配/；以/**结尾时匹配前缀下的所有路径
+//	auth.RequireSPIFFE("spiffe://example.org/ns/prod/sa/billing", "spiffe://example.org/ns/ops/**")
+func RequireSPIFFE(patterns ...string) *route.Policy {
+	return route.PolicyFunc("spiffe:"+strings.Join(patterns, "|"), func(c *route.Context) bool {
+		p := c.Principal()
+		if p.Method != "mtls" || !strings.HasPrefix(p.ID, "spiffe://") {
+			return false
+		}
+		for _, pattern := range patterns {
+			if matchSPIFFE(pattern, p.ID) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func matchSPIFFE(pattern, id string) bool {
+	if strings.HasSuffix(pattern, "/**") {
+		prefix := strings.TrimSuffix(pattern, "**")
+		return strings.HasPrefix(id, prefix) && len(id) > len(prefix)
+	}
+	ok, err := path.Match(pattern, id)
+	return err == nil && ok
+}
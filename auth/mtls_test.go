@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"mux/route"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var serial int64
+
+func newCA(t *testing.T, name string) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+//签发叶子证书，uri为空时只有CN
+func (ca *testCA) issue(t *testing.T, cn, uri string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if uri != "" {
+		u, _ := url.Parse(uri)
+		tmpl.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCert(t *testing.T) {
+	ca, other := newCA(t, "ca"), newCA(t, "other")
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	r := route.New(&route.Config{}, nil)
+	internal := r.Group("/internal", ClientCert(ClientCertConfig{})).
+		Authorize(RequireSPIFFE("spiffe://example.org/ns/prod/sa/*"))
+	internal.GET("/", func(c *route.Context) {
+		c.WriteString(http.StatusOK, c.Principal().ID)
+	})
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(r.Run))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", "", x509.ExtKeyUsageServerAuth)},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(cert *tls.Certificate) (int, string) {
+		conf := &tls.Config{RootCAs: pool}
+		if cert != nil {
+			conf.Certificates = []tls.Certificate{*cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: conf}}
+		resp, err := client.Get(srv.URL + "/internal")
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		buf := make([]byte, 128)
+		n, _ := resp.Body.Read(buf)
+		return resp.StatusCode, string(buf[:n])
+	}
+
+	billing := ca.issue(t, "billing", "spiffe://example.org/ns/prod/sa/billing", x509.ExtKeyUsageClientAuth)
+	if code, body := get(&billing); code != http.StatusOK || body != "spiffe://example.org/ns/prod/sa/billing" {
+		t.Fatalf("allowed identity: %d %q", code, body)
+	}
+	dev := ca.issue(t, "dev", "spiffe://example.org/ns/dev/sa/billing", x509.ExtKeyUsageClientAuth)
+	if code, _ := get(&dev); code != http.StatusForbidden {
+		t.Fatalf("other identity: %d", code)
+	}
+	if code, _ := get(nil); code != http.StatusUnauthorized {
+		t.Fatalf("no certificate: %d", code)
+	}
+	//其他CA签发的证书不在服务端接受的CA中，客户端不会发送，请求没有证书
+	untrusted := other.issue(t, "x", "spiffe://example.org/ns/prod/sa/billing", x509.ExtKeyUsageClientAuth)
+	if code, _ := get(&untrusted); code != http.StatusUnauthorized {
+		t.Fatalf("untrusted CA: %d", code)
+	}
+}
+
+func TestDefaultCertPrincipal(t *testing.T) {
+	ca := newCA(t, "ca")
+	for _, c := range []struct {
+		cn, uri, want string
+	}{
+		{"svc", "spiffe://example.org/svc", "spiffe://example.org/svc"},
+		{"svc", "", "svc"},
+	} {
+		tc := ca.issue(t, c.cn, c.uri, x509.ExtKeyUsageClientAuth)
+		cert, _ := x509.ParseCertificate(tc.Certificate[0])
+		p, err := DefaultCertPrincipal(cert)
+		if err != nil || p.ID != c.want || p.Method != "mtls" {
+			t.Errorf("principal = %+v, %v, want %s", p, err, c.want)
+		}
+	}
+	if !matchSPIFFE("spiffe://example.org/ns/ops/**", "spiffe://example.org/ns/ops/sa/x") ||
+		matchSPIFFE("spiffe://example.org/ns/ops/**", "spiffe://example.org/ns/ops/") {
+		t.Error("/** pattern")
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"mux/route"
+	"net/http"
+	"sync"
+)
+
+var (
+	//请求中没有凭据
+	ErrNoCredentials = errors.New("auth: no credentials")
+	//用户不存在、密码错误或者API key无效，不区分具体的原因
+	ErrBadCredentials = errors.New("auth: invalid credentials")
+	//digest的nonce过期，客户端可以使用新的nonce重试
+	ErrStaleNonce = errors.New("auth: stale nonce")
+	//UserStore中没有这个用户
+	ErrUnknownUser = errors.New("auth: unknown user")
+)
+
+//basic与digest使用的用户
+type User struct {
+	Name string
+	//明文密码，basic与digest都可以使用
+	Password string
+	//可选，hex(md5(name:realm:password))，digest的MD5算法优先使用它，这样可以不保存明文密码
+	HA1         string
+	Roles       []string
+	Permissions []string
+}
+
+func (u *User) principal(method string) *route.Principal {
+	return &route.Principal{
+		ID:          u.Name,
+		Method:      method,
+		Roles:       u.Roles,
+		Permissions: u.Permissions,
+	}
+}
+
+//查找用户，不存在时返回ErrUnknownUser
+type UserStore interface {
+	LookupUser(name string) (*User, error)
+}
+
+//保存在内存中的用户
+type Users struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+func NewUsers(users ...*User) *Users {
+	s := &Users{users: make(map[string]*User)}
+	for _, u := range users {
+		s.Add(u)
+	}
+	return s
+}
+
+func (s *Users) Add(u *User) {
+	s.mu.Lock()
+	s.users[u.Name] = u
+	s.mu.Unlock()
+}
+
+func (s *Users) LookupUser(name string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if u, ok := s.users[name]; ok {
+		return u, nil
+	}
+	return nil, ErrUnknownUser
+}
+
+//按照API key查找调用方，无效时返回ErrBadCredentials
+type APIKeyStore interface {
+	LookupAPIKey(key string) (*route.Principal, error)
+}
+
+//保存在内存中的API key，只保存key的sha256，查找时比较的是hash，不会泄露key的内容
+type APIKeys struct {
+	mu   sync.RWMutex
+	keys map[[sha256.Size]byte]*route.Principal
+}
+
+func NewAPIKeys() *APIKeys {
+	return &APIKeys{keys: make(map[[sha256.Size]byte]*route.Principal)}
+}
+
+func (s *APIKeys) Add(key string, p *route.Principal) {
+	s.mu.Lock()
+	s.keys[sha256.Sum256([]byte(key))] = p
+	s.mu.Unlock()
+}
+
+func (s *APIKeys) Remove(key string) {
+	s.mu.Lock()
+	delete(s.keys, sha256.Sum256([]byte(key)))
+	s.mu.Unlock()
+}
+
+func (s *APIKeys) LookupAPIKey(key string) (*route.Principal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if p, ok := s.keys[sha256.Sum256([]byte(key))]; ok {
+		return p, nil
+	}
+	return nil, ErrBadCredentials
+}
+
+//比较两个字符串，耗时与内容无关，长度不同时也不会提前返回
+func Equal(a, b string) bool {
+	x, y := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(x[:], y[:]) == 1
+}
+
+//返回401，challenges写入WWW-Authenticate头部
+func Unauthorized(c *route.Context, challenges ...string) {
+	for _, ch := range challenges {
+		c.Writer.Header().Add("WWW-Authenticate", ch)
+	}
+	http.Error(c.Writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+//realm写入quoted-string时需要转义
+func quote(s string) string {
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return string(append(b, '"'))
+}
@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"mux/route"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSignature      = errors.New("auth: missing webhook signature")
+	ErrInvalidSignature = errors.New("auth: invalid webhook signature")
+	//时间戳缺失或者与当前时间相差超过Tolerance
+	ErrTimestamp = errors.New("auth: webhook timestamp outside tolerance")
+	//同一个请求已经处理过
+	ErrReplay = errors.New("auth: webhook replayed")
+)
+
+//从请求中解析出的签名信息
+type WebhookSignature struct {
+	//请求中的签名，可以有多个，例如发送方轮换密钥期间
+	Signatures [][]byte
+	//签名时间，没有时间戳的方案为零值
+	Timestamp time.Time
+	//用于防止重放的唯一标识，必须被签名覆盖，例如Standard Webhooks的webhook-id
+	//为空时使用签名内容的hash，没有被签名的头部不能作为nonce，否则修改它就可以重放
+	Nonce string
+	//需要签名的内容
+	Payload []byte
+}
+
+//签名方案，通常使用预置的GitHubScheme、StripeScheme、SlackScheme与StandardWebhooksScheme
+type WebhookScheme struct {
+	Name string
+	//default:sha256.New
+	Hash func() hash.Hash
+	//解析签名，没有签名时返回ErrNoSignature
+	Parse func(r *http.Request, body []byte) (*WebhookSignature, error)
+}
+
+//防重放的nonce缓存，Seen在nonce第一次出现时记录并返回false
+type NonceCache interface {
+	Seen(nonce string, ttl time.Duration) (bool, error)
+}
+
+type WebhookConfig struct {
+	Scheme *WebhookScheme
+	//签名密钥，任意一个匹配即可，轮换时同时配置新旧密钥
+	Secrets [][]byte
+	//允许的时间误差，default:5m
+	Tolerance time.Duration
+	//default:进程内的缓存，多个实例时应当使用共享的缓存
+	Nonces NonceCache
+	//nonce的保存时间，default:Tolerance的两倍，没有时间戳的方案为24h
+	NonceTTL time.Duration
+	//请求体的最大字节数，default:1MB
+	MaxBody int64
+	//default:time.Now
+	Now func() time.Time
+	//校验失败时调用，default:签名问题返回401，请求体太大返回413
+	ErrorHandler func(c *route.Context, err error)
+}
+
+//校验webhook的HMAC签名，通过后Principal为{ID:方案名称, Method:"webhook"}
+//请求体会被缓存，之后的handler仍然可以调用BindJSON
+//
+//	hooks := m.Group("/hooks")
+//	hooks.POST("/github", auth.Webhook(auth.WebhookConfig{Scheme: auth.GitHubScheme, Secrets: [][]byte{secret}}), handle)
+func Webhook(conf WebhookConfig) route.HandlerFunc {
+	if conf.Scheme == nil {
+		panic("auth: webhook scheme is required")
+	}
+	if conf.Tolerance <= 0 {
+		conf.Tolerance = 5 * time.Minute
+	}
+	if conf.Nonces == nil {
+		conf.Nonces = NewMemoryNonces()
+	}
+	if conf.MaxBody <= 0 {
+		conf.MaxBody = 1 << 20
+	}
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
+	hashFn := conf.Scheme.Hash
+	if hashFn == nil {
+		hashFn = sha256.New
+	}
+	if conf.ErrorHandler == nil {
+		conf.ErrorHandler = func(c *route.Context, err error) {
+			code := http.StatusUnauthorized
+			if err == route.ErrBodyTooLarge {
+				code = http.StatusRequestEntityTooLarge
+			}
+			http.Error(c.Writer, http.StatusText(code), code)
+		}
+	}
+	return func(c *route.Context) {
+		if err := verifyWebhook(c, &conf, hashFn); err != nil {
+			conf.ErrorHandler(c, err)
+			c.Abort()
+			return
+		}
+		c.SetPrincipal(&route.Principal{ID: conf.Scheme.Name, Method: "webhook"})
+		c.Next()
+	}
+}
+
+func verifyWebhook(c *route.Context, conf *WebhookConfig, hashFn func() hash.Hash) error {
+	body, err := c.ReadBody(conf.MaxBody)
+	if err != nil {
+		return err
+	}
+	sig, err := conf.Scheme.Parse(c.Request(), body)
+	if err != nil {
+		return err
+	}
+	if len(sig.Signatures) == 0 {
+		return ErrNoSignature
+	}
+	matched := false
+	for _, secret := range conf.Secrets {
+		mac := hmac.New(hashFn, secret)
+		mac.Write(sig.Payload)
+		sum := mac.Sum(nil)
+		for _, s := range sig.Signatures {
+			if hmac.Equal(s, sum) {
+				matched = true
+			}
+		}
+	}
+	if !matched {
+		return ErrInvalidSignature
+	}
+	ttl := conf.NonceTTL
+	if !sig.Timestamp.IsZero() {
+		d := conf.Now().Sub(sig.Timestamp)
+		if d > conf.Tolerance || d < -conf.Tolerance {
+			return ErrTimestamp
+		}
+		if ttl <= 0 {
+			ttl = 2 * conf.Tolerance
+		}
+	} else if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	//签名通过之后才记录nonce，伪造的请求不能占用nonce
+	//nonce只来自签名覆盖的内容，请求中其他的部分，例如多余的签名与没有签名的头部，都不会影响它
+	nonce := sig.Nonce
+	if nonce == "" {
+		sum := sha256.Sum256(sig.Payload)
+		nonce = hex.EncodeToString(sum[:])
+	}
+	seen, err := conf.Nonces.Seen(conf.Scheme.Name+":"+nonce, ttl)
+	if err != nil {
+		return err
+	}
+	if seen {
+		return ErrReplay
+	}
+	return nil
+}
+
+//进程内的nonce缓存
+type MemoryNonces struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	next   time.Time
+}
+
+func NewMemoryNonces() *MemoryNonces {
+	return &MemoryNonces{nonces: make(map[string]time.Time)}
+}
+
+func (m *MemoryNonces) Seen(nonce string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	//最多每分钟清理一次过期的nonce
+	if now.After(m.next) {
+		for n, exp := range m.nonces {
+			if now.After(exp) {
+				delete(m.nonces, n)
+			}
+		}
+		m.next = now.Add(time.Minute)
+	}
+	if exp, ok := m.nonces[nonce]; ok && now.Before(exp) {
+		return true, nil
+	}
+	m.nonces[nonce] = now.Add(ttl)
+	return false, nil
+}
+
+//由签名头部、前缀与可选的时间戳头部组成的通用方案，签名为hex
+//有时间戳时签名的内容为"时间戳.请求体"，否则为请求体，重放按照签名的内容判断
+//	auth.HeaderScheme("acme", "X-Acme-Signature", "sha256=", "X-Acme-Timestamp")
+func HeaderScheme(name, header, prefix, timestampHeader string) *WebhookScheme {
+	return &WebhookScheme{
+		Name: name,
+		Parse: func(r *http.Request, body []byte) (*WebhookSignature, error) {
+			v := r.Header.Get(header)
+			if v == "" || !strings.HasPrefix(v, prefix) {
+				return nil, ErrNoSignature
+			}
+			s, err := hex.DecodeString(strings.TrimPrefix(v, prefix))
+			if err != nil {
+				return nil, ErrInvalidSignature
+			}
+			sig := &WebhookSignature{Signatures: [][]byte{s}, Payload: body}
+			if timestampHeader != "" {
+				ts := r.Header.Get(timestampHeader)
+				if sig.Timestamp, err = parseUnix(ts); err != nil {
+					return nil, err
+				}
+				sig.Payload = concat(ts+".", body)
+			}
+			return sig, nil
+		},
+	}
+}
+
+//GitHub：X-Hub-Signature-256: sha256=<hex>，没有时间戳
+//X-GitHub-Delivery没有被签名，不能用于防止重放，相同的请求体在NonceTTL内只接受一次
+var GitHubScheme = HeaderScheme("github", "X-Hub-Signature-256", "sha256=", "")
+
+//Stripe：Stripe-Signature: t=<unix>,v1=<hex>[,v1=<hex>]，签名内容为"t.请求体"
+var StripeScheme = &WebhookScheme{
+	Name: "stripe",
+	Parse: func(r *http.Request, body []byte) (*WebhookSignature, error) {
+		v := r.Header.Get("Stripe-Signature")
+		if v == "" {
+			return nil, ErrNoSignature
+		}
+		sig := &WebhookSignature{}
+		ts := ""
+		for _, part := range strings.Split(v, ",") {
+			kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "t":
+				ts = kv[1]
+			case "v1":
+				if s, err := hex.DecodeString(kv[1]); err == nil {
+					sig.Signatures = append(sig.Signatures, s)
+				}
+			}
+		}
+		var err error
+		if sig.Timestamp, err = parseUnix(ts); err != nil {
+			return nil, err
+		}
+		sig.Payload = concat(ts+".", body)
+		return sig, nil
+	},
+}
+
+//Slack：X-Slack-Signature: v0=<hex>，X-Slack-Request-Timestamp，签名内容为"v0:时间戳:请求体"
+var SlackScheme = &WebhookScheme{
+	Name: "slack",
+	Parse: func(r *http.Request, body []byte) (*WebhookSignature, error) {
+		v := r.Header.Get("X-Slack-Signature")
+		if !strings.HasPrefix(v, "v0=") {
+			return nil, ErrNoSignature
+		}
+		s, err := hex.DecodeString(v[3:])
+		if err != nil {
+			return nil, ErrInvalidSignature
+		}
+		ts := r.Header.Get("X-Slack-Request-Timestamp")
+		sig := &WebhookSignature{Signatures: [][]byte{s}}
+		if sig.Timestamp, err = parseUnix(ts); err != nil {
+			return nil, err
+		}
+		sig.Payload = concat("v0:"+ts+":", body)
+		return sig, nil
+	},
+}
+
+//Standard Webhooks（Svix）：webhook-id、webhook-timestamp、webhook-signature: v1,<base64> [v1,<base64>]
+//签名内容为"id.时间戳.请求体"，whsec_开头的密钥需要先去掉前缀并base64解码
+var StandardWebhooksScheme = &WebhookScheme{
+	Name: "standard-webhooks",
+	Parse: func(r *http.Request, body []byte) (*WebhookSignature, error) {
+		v := r.Header.Get("webhook-signature")
+		if v == "" {
+			return nil, ErrNoSignature
+		}
+		id, ts := r.Header.Get("webhook-id"), r.Header.Get("webhook-timestamp")
+		sig := &WebhookSignature{Nonce: id}
+		for _, part := range strings.Fields(v) {
+			if !strings.HasPrefix(part, "v1,") {
+				continue
+			}
+			if s, err := base64.StdEncoding.DecodeString(part[3:]); err == nil {
+				sig.Signatures = append(sig.Signatures, s)
+			}
+		}
+		var err error
+		if sig.Timestamp, err = parseUnix(ts); err != nil {
+			return nil, err
+		}
+		sig.Payload = concat(id+"."+ts+".", body)
+		return sig, nil
+	},
+}
+
+func parseUnix(ts string) (time.Time, error) {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrTimestamp
+	}
+	return time.Unix(sec, 0), nil
+}
+
+func concat(prefix string, body []byte) []byte {
+	b := make([]byte, 0, len(prefix)+len(body))
+	return append(append(b, prefix...), body...)
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"mux/route"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hmacSHA256(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func TestWebhook(t *testing.T) {
+	secret := []byte("whsec-test")
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := `{"event":"paid","amount":42}`
+
+	r := route.New(&route.Config{}, nil)
+	for _, s := range []*WebhookScheme{GitHubScheme, StripeScheme, SlackScheme, StandardWebhooksScheme} {
+		r.POST("/"+s.Name, Webhook(WebhookConfig{
+			Scheme:  s,
+			Secrets: [][]byte{[]byte("old-secret"), secret},
+			Now:     func() time.Time { return now },
+			MaxBody: 64,
+		}), func(c *route.Context) {
+			var v struct {
+				Event  string
+				Amount int
+			}
+			//签名校验读取过请求体，BindJSON仍然可以使用
+			if err := c.BindJSON(&v); err != nil {
+				c.WriteString(http.StatusBadRequest, err.Error())
+				return
+			}
+			c.WriteString(http.StatusOK, c.Principal().ID+":"+v.Event+":"+strconv.Itoa(v.Amount))
+		})
+	}
+	send := func(path, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		return serve(r, req)
+	}
+
+	signed := map[string]map[string]string{
+		"github": {
+			"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(hmacSHA256(secret, body)),
+			"X-GitHub-Delivery":   "d1",
+		},
+		"stripe": {
+			"Stripe-Signature": "t=" + ts + ",v1=00ff,v1=" + hex.EncodeToString(hmacSHA256(secret, ts+"."+body)),
+		},
+		"slack": {
+			"X-Slack-Signature":         "v0=" + hex.EncodeToString(hmacSHA256(secret, "v0:"+ts+":"+body)),
+			"X-Slack-Request-Timestamp": ts,
+		},
+		"standard-webhooks": {
+			"webhook-id":        "msg_1",
+			"webhook-timestamp": ts,
+			"webhook-signature": "v1,bad v1," + base64.StdEncoding.EncodeToString(hmacSHA256(secret, "msg_1."+ts+"."+body)),
+		},
+	}
+	for name, header := range signed {
+		if w := send("/"+name, body, header); w.Code != http.StatusOK || w.Body.String() != name+":paid:42" {
+			t.Fatalf("%s: %d %q", name, w.Code, w.Body.String())
+		}
+		//同一个请求再次发送被认为是重放
+		if w := send("/"+name, body, header); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s replay: %d", name, w.Code)
+		}
+		if w := send("/"+name, `{"event":"refund","amount":42}`, header); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s tampered body: %d", name, w.Code)
+		}
+	}
+
+	//修改没有被签名的头部或者在前面加上伪造的签名，仍然是重放
+	for name, header := range map[string]map[string]string{
+		"github": {
+			"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(hmacSHA256(secret, body)),
+			"X-GitHub-Delivery":   "d2",
+		},
+		"stripe": {
+			"Stripe-Signature": "t=" + ts + ",v1=0123,v1=" + hex.EncodeToString(hmacSHA256(secret, ts+"."+body)),
+		},
+		"standard-webhooks": {
+			"webhook-id":        "msg_1",
+			"webhook-timestamp": ts,
+			"webhook-signature": "v1,AAAA v1," + base64.StdEncoding.EncodeToString(hmacSHA256(secret, "msg_1."+ts+"."+body)),
+		},
+	} {
+		if w := send("/"+name, body, header); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s replay with modified headers: %d", name, w.Code)
+		}
+	}
+
+	if w := send("/github", body, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("no signature: %d", w.Code)
+	}
+	stale := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+	if w := send("/slack", body, map[string]string{
+		"X-Slack-Signature":         "v0=" + hex.EncodeToString(hmacSHA256(secret, "v0:"+stale+":"+body)),
+		"X-Slack-Request-Timestamp": stale,
+	}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("stale timestamp: %d", w.Code)
+	}
+	large := `{"event":"` + strings.Repeat("x", 64) + `"}`
+	if w := send("/github", large, map[string]string{
+		"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(hmacSHA256(secret, large)),
+	}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large body: %d", w.Code)
+	}
+}
+
+func TestMemoryNonces(t *testing.T) {
+	n := NewMemoryNonces()
+	if seen, _ := n.Seen("a", time.Millisecond); seen {
+		t.Fatal("first use")
+	}
+	if seen, _ := n.Seen("a", time.Millisecond); !seen {
+		t.Fatal("second use")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if seen, _ := n.Seen("a", time.Minute); seen {
+		t.Fatal("expired nonce")
+	}
+}
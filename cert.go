@@ -0,0 +1,314 @@
+package mux
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//管理https使用的证书，支持多个证书按SNI选择，以及证书文件更新后自动重新加载
+//第一个添加的证书作为默认证书，客户端没有发送SNI或者没有匹配的证书时使用
+type CertManager struct {
+	//重新加载证书失败时调用，失败时会继续使用旧的证书
+	OnError func(error)
+
+	reloadMu sync.Mutex
+	mu       sync.RWMutex
+	entries  []*certEntry
+	names    map[string][]*tls.Certificate
+	stop     chan struct{}
+}
+
+type certEntry struct {
+	certFile string
+	keyFile  string
+	certStat fileStat
+	keyStat  fileStat
+	cert     *tls.Certificate
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(name string) (fileStat, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return fileStat{}, err
+	}
+	return fileStat{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+func NewCertManager() *CertManager {
+	return &CertManager{names: make(map[string][]*tls.Certificate)}
+}
+
+//添加一对证书文件，文件更新后可以通过Reload或者Watch重新加载
+func (m *CertManager) Add(certFile, keyFile string) error {
+	e := &certEntry{certFile: certFile, keyFile: keyFile}
+	if _, err := e.load(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.entries = append(m.entries, e)
+	m.index()
+	m.mu.Unlock()
+	return nil
+}
+
+//添加一个内存中的证书，它不会被重新加载
+func (m *CertManager) AddCertificate(cert tls.Certificate) error {
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		cert.Leaf = leaf
+	}
+	m.mu.Lock()
+	m.entries = append(m.entries, &certEntry{cert: &cert})
+	m.index()
+	m.mu.Unlock()
+	return nil
+}
+
+//文件有变化时重新加载证书，返回第一个错误
+func (m *CertManager) Reload() error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	m.mu.RLock()
+	entries := make([]*certEntry, len(m.entries))
+	copy(entries, m.entries)
+	m.mu.RUnlock()
+
+	var first error
+	changed := false
+	for _, e := range entries {
+		if e.certFile == "" {
+			continue
+		}
+		cert, err := e.reload()
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			if m.OnError != nil {
+				m.OnError(err)
+			}
+			continue
+		}
+		if cert != nil {
+			m.mu.Lock()
+			e.cert = cert
+			m.mu.Unlock()
+			changed = true
+		}
+	}
+	if changed {
+		m.mu.Lock()
+		m.index()
+		m.mu.Unlock()
+	}
+	return first
+}
+
+//每隔interval检查一次证书文件，重复调用会停止之前的检查
+func (m *CertManager) Watch(interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	m.mu.Lock()
+	if m.stop != nil {
+		close(m.stop)
+	}
+	stop := make(chan struct{})
+	m.stop = stop
+	m.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Reload()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+//停止检查证书文件
+func (m *CertManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+}
+
+//可以直接赋值给tls.Config.GetCertificate
+func (m *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.entries) == 0 {
+		return nil, errors.New("mux: no certificate")
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if certs, ok := m.names[name]; ok {
+		return pickCert(hello, certs), nil
+	}
+	//通配符证书只匹配一级子域名
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if certs, ok := m.names["*"+name[i:]]; ok {
+			return pickCert(hello, certs), nil
+		}
+	}
+	return m.entries[0].cert, nil
+}
+
+//同一个域名可以同时配置RSA与ECDSA的证书，选择客户端支持的那个
+func pickCert(hello *tls.ClientHelloInfo, certs []*tls.Certificate) *tls.Certificate {
+	for _, c := range certs {
+		if hello.SupportsCertificate(c) == nil {
+			return c
+		}
+	}
+	return certs[0]
+}
+
+//根据证书中的域名建立索引，调用者需要持有写锁
+func (m *CertManager) index() {
+	names := make(map[string][]*tls.Certificate)
+	for _, e := range m.entries {
+		leaf := e.cert.Leaf
+		if leaf == nil {
+			continue
+		}
+		seen := make(map[string]bool)
+		add := func(name string) {
+			name = strings.ToLower(name)
+			if name == "" || seen[name] {
+				return
+			}
+			seen[name] = true
+			names[name] = append(names[name], e.cert)
+		}
+		for _, name := range leaf.DNSNames {
+			add(name)
+		}
+		for _, ip := range leaf.IPAddresses {
+			add(ip.String())
+		}
+		if len(leaf.DNSNames) == 0 {
+			add(leaf.Subject.CommonName)
+		}
+	}
+	m.names = names
+}
+
+func (e *certEntry) load() (*tls.Certificate, error) {
+	certStat, err := statFile(e.certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyStat, err := statFile(e.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	e.certStat, e.keyStat, e.cert = certStat, keyStat, &cert
+	return &cert, nil
+}
+
+//文件没有变化时返回nil
+func (e *certEntry) reload() (*tls.Certificate, error) {
+	certStat, err := statFile(e.certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyStat, err := statFile(e.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if certStat == e.certStat && keyStat == e.keyStat {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+	if err != nil {
+		//证书和私钥可能还没有都写完，下次检查时再试
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	e.certStat, e.keyStat = certStat, keyStat
+	return &cert, nil
+}
+
+//生成内存中的自签名证书，只应当在开发环境使用
+//hosts为空时使用localhost、127.0.0.1与::1
+func SelfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"mux development"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
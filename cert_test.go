@@ -0,0 +1,133 @@
+package mux
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mustSelfSigned(t *testing.T, hosts ...string) tls.Certificate {
+	cert, err := SelfSignedCertificate(hosts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+//把证书写入文件，修改时间设置为at，保证Reload能发现变化
+func writeCertFiles(t *testing.T, certFile, keyFile string, cert tls.Certificate, at time.Time) {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	for name, bs := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if err := ioutil.WriteFile(name, bs, 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(name, at, at)
+	}
+}
+
+func servedName(t *testing.T, m *CertManager, serverName string) string {
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertManagerSNI(t *testing.T) {
+	m := NewCertManager()
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Fatal("empty manager returned a certificate")
+	}
+	for _, hosts := range [][]string{
+		{"default.test"},
+		{"a.example.com", "b.example.com"},
+		{"*.wild.test"},
+		{"192.0.2.1"},
+	} {
+		if err := m.AddCertificate(mustSelfSigned(t, hosts...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for serverName, want := range map[string]string{
+		"":                  "default.test",
+		"unknown.test":      "default.test",
+		"a.example.com":     "a.example.com",
+		"B.Example.COM.":    "a.example.com",
+		"x.wild.test":       "*.wild.test",
+		"wild.test":         "default.test",
+		"x.y.wild.test":     "default.test",
+		"192.0.2.1":         "192.0.2.1",
+		"sub.a.example.com": "default.test",
+	} {
+		if got := servedName(t, m, serverName); got != want {
+			t.Errorf("SNI %q served %q, want %q", serverName, got, want)
+		}
+	}
+}
+
+func TestCertManagerReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	base := time.Now().Add(-time.Hour)
+	writeCertFiles(t, certFile, keyFile, mustSelfSigned(t, "old.test"), base)
+
+	m := NewCertManager()
+	var errs []error
+	m.OnError = func(err error) { errs = append(errs, err) }
+	if err := m.Add(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "old.test"})
+	//文件没有变化时不重新加载
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "old.test"}); after != before {
+		t.Fatal("unchanged files were reloaded")
+	}
+
+	writeCertFiles(t, certFile, keyFile, mustSelfSigned(t, "new.test"), base.Add(time.Minute))
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedName(t, m, "new.test"); got != "new.test" {
+		t.Fatalf("after reload served %q", got)
+	}
+	//旧的域名不再有单独的索引，使用默认证书，也就是新的证书
+	if got := servedName(t, m, "old.test"); got != "new.test" {
+		t.Fatalf("old name served %q", got)
+	}
+
+	//写坏的文件返回错误，继续使用之前的证书
+	ioutil.WriteFile(certFile, []byte("not a certificate"), 0600)
+	at := base.Add(2 * time.Minute)
+	os.Chtimes(certFile, at, at)
+	if err := m.Reload(); err == nil || len(errs) != 1 {
+		t.Fatalf("Reload = %v, OnError called %d times", err, len(errs))
+	}
+	if got := servedName(t, m, "new.test"); got != "new.test" {
+		t.Fatalf("after failed reload served %q", got)
+	}
+
+	//Watch定期检查文件
+	m.Watch(10 * time.Millisecond)
+	defer m.Close()
+	writeCertFiles(t, certFile, keyFile, mustSelfSigned(t, "watched.test"), base.Add(3*time.Minute))
+	deadline := time.Now().Add(5 * time.Second)
+	for servedName(t, m, "watched.test") != "watched.test" {
+		if time.Now().After(deadline) {
+			t.Fatal("Watch did not reload the certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,367 @@
+package mux
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mux/route"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+//mux的配置文件，可以从yaml、json或者环境变量中读取
+type Config struct {
+	Route  route.Config `json:"route" yaml:"route" env:"ROUTE"`
+	Server ServerConfig `json:"server" yaml:"server" env:"SERVER"`
+}
+
+//http服务的配置，默认值可以防止slowloris之类的慢速攻击
+type ServerConfig struct {
+	//读取整个请求的超时时间，default:30s
+	ReadTimeout Duration `json:"readTimeout" yaml:"readTimeout" env:"READ_TIMEOUT"`
+	//读取请求头的超时时间，default:10s
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout" yaml:"readHeaderTimeout" env:"READ_HEADER_TIMEOUT"`
+	//写入响应的超时时间，default:30s
+	WriteTimeout Duration `json:"writeTimeout" yaml:"writeTimeout" env:"WRITE_TIMEOUT"`
+	//keep-alive连接的空闲时间，default:120s
+	IdleTimeout Duration `json:"idleTimeout" yaml:"idleTimeout" env:"IDLE_TIMEOUT"`
+	//请求头的最大字节数，default:1MB
+	MaxHeaderBytes int `json:"maxHeaderBytes" yaml:"maxHeaderBytes" env:"MAX_HEADER_BYTES"`
+	TLS TLSConfig `json:"tls" yaml:"tls" env:"TLS"`
+	//RunListeners使用的监听地址，可以同时监听tcp、unix socket与继承的文件描述符
+	Listeners []ListenConfig `json:"listeners" yaml:"listeners"`
+}
+
+type TLSConfig struct {
+	//最低的TLS版本，"1.2"或者"1.3"，default:"1.2"
+	MinVersion string `json:"minVersion" yaml:"minVersion" env:"MIN_VERSION"`
+	//允许的加密套件名称，例如TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256，为空时使用go的默认值
+	//TLS1.3的套件不能配置
+	CipherSuites []string `json:"cipherSuites" yaml:"cipherSuites" env:"CIPHER_SUITES"`
+	//客户端证书的校验方式，none、request、require、verify_if_given、require_and_verify
+	//default:none，配置了CA时default:verify_if_given
+	ClientAuth string `json:"clientAuth" yaml:"clientAuth" env:"CLIENT_AUTH"`
+	//校验客户端证书使用的CA文件，pem格式
+	ClientCAFile string `json:"clientCAFile" yaml:"clientCAFile" env:"CLIENT_CA_FILE"`
+	//更多的CA文件，例如轮换CA时新旧两个CA同时有效，与ClientCAFile合并为一个证书池
+	ClientCAFiles []string `json:"clientCAFiles" yaml:"clientCAFiles" env:"CLIENT_CA_FILES"`
+	//按照SNI选择的多个证书，RunTSL传入的证书是默认证书，否则第一个是默认证书
+	Certificates []CertFile `json:"certificates" yaml:"certificates"`
+	//检查证书文件是否更新的间隔，0表示不检查，default:10s
+	ReloadInterval Duration `json:"reloadInterval" yaml:"reloadInterval" env:"RELOAD_INTERVAL"`
+	//开发模式，没有任何证书时生成localhost的自签名证书，default:false
+	Dev bool `json:"dev" yaml:"dev" env:"DEV"`
+}
+
+type CertFile struct {
+	CertFile string `json:"certFile" yaml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+}
+
+//yaml与json中可以写成"5s"这样的字符串，数字则表示秒
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) parse(s string) error {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		*d = Duration(time.Duration(n) * time.Second)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("mux: invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		s = string(b)
+	}
+	return d.parse(s)
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Route: route.Config{
+			PathUnescape:       true,
+			MaxMultipartMemory: 4 << 20,
+			OpenSession:        true,
+		},
+		Server: DefaultServerConfig(),
+	}
+}
+
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		ReadTimeout:       Duration(30 * time.Second),
+		ReadHeaderTimeout: Duration(10 * time.Second),
+		WriteTimeout:      Duration(30 * time.Second),
+		IdleTimeout:       Duration(120 * time.Second),
+		MaxHeaderBytes:    1 << 20,
+		TLS: TLSConfig{
+			MinVersion:     "1.2",
+			ClientAuth:     "none",
+			ReloadInterval: Duration(10 * time.Second),
+		},
+	}
+}
+
+//从文件中读取配置，根据后缀判断格式，没有配置的项使用默认值
+func LoadConfig(file string) (*Config, error) {
+	conf := DefaultConfig()
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bs, conf)
+	case ".json":
+		err = json.Unmarshal(bs, conf)
+	default:
+		return nil, fmt.Errorf("mux: unknown config format %q", file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+//用环境变量覆盖配置，变量名由前缀与env标签组成
+//例如prefix为MUX时，MUX_SERVER_READ_TIMEOUT=5s，MUX_SERVER_TLS_CIPHER_SUITES=a,b
+func (c *Config) LoadEnv(prefix string) error {
+	return loadEnv(reflect.ValueOf(c).Elem(), prefix)
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+func loadEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("env")
+		if name == "" || name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			if err := loadEnv(fv, name); err != nil {
+				return err
+			}
+			continue
+		}
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(fv, val); err != nil {
+			return fmt.Errorf("mux: env %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(fv reflect.Value, val string) error {
+	if fv.Type() == durationType {
+		return fv.Addr().Interface().(*Duration).parse(val)
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return errors.New("unsupported slice type")
+		}
+		var arr []string
+		for _, s := range strings.Split(val, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				arr = append(arr, s)
+			}
+		}
+		fv.Set(reflect.ValueOf(arr))
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+//根据配置创建http.Server
+func (c *ServerConfig) NewServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       c.ReadTimeout.Duration(),
+		ReadHeaderTimeout: c.ReadHeaderTimeout.Duration(),
+		WriteTimeout:      c.WriteTimeout.Duration(),
+		IdleTimeout:       c.IdleTimeout.Duration(),
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+//根据配置生成tls.Config，不安全的加密套件与低于1.2的版本都会返回错误
+func (c *TLSConfig) Build() (*tls.Config, error) {
+	return c.build(nil)
+}
+
+//clientCAs是代码中设置的CA，与配置文件中的CA合并
+func (c *TLSConfig) build(clientCAs *x509.CertPool) (*tls.Config, error) {
+	version, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("mux: unsupported tls version %q", c.MinVersion)
+	}
+	auth, ok := clientAuthTypes[strings.ToLower(c.ClientAuth)]
+	if !ok {
+		return nil, fmt.Errorf("mux: unknown client auth %q", c.ClientAuth)
+	}
+	conf := &tls.Config{
+		MinVersion: version,
+		ClientAuth: auth,
+	}
+
+	if len(c.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, s := range tls.CipherSuites() {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range c.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("mux: unknown or insecure cipher suite %q", name)
+			}
+			conf.CipherSuites = append(conf.CipherSuites, id)
+		}
+	}
+
+	files := c.ClientCAFiles
+	if c.ClientCAFile != "" {
+		files = append([]string{c.ClientCAFile}, files...)
+	}
+	if clientCAs != nil || len(files) > 0 {
+		pool := x509.NewCertPool()
+		if clientCAs != nil {
+			pool = clientCAs.Clone()
+		}
+		for _, file := range files {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("mux: no certificate found in %q", file)
+			}
+		}
+		conf.ClientCAs = pool
+		//配置了CA却没有配置校验方式时，校验客户端发送的证书，是否必须提供由中间件决定
+		if auth == tls.NoClientCert {
+			conf.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	if auth >= tls.VerifyClientCertIfGiven && conf.ClientCAs == nil {
+		return nil, errors.New("mux: client certificate verification requires clientCAFile")
+	}
+	return conf, nil
+}
+
+//根据配置创建证书管理器，certFile与keyFile可以为空
+func (c *TLSConfig) NewCertManager(certFile, keyFile string) (*CertManager, error) {
+	certs := NewCertManager()
+	if certFile != "" || keyFile != "" {
+		if err := certs.Add(certFile, keyFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range c.Certificates {
+		if err := certs.Add(f.CertFile, f.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(certs.entries) == 0 {
+		if !c.Dev {
+			return nil, errors.New("mux: no certificate configured")
+		}
+		cert, err := SelfSignedCertificate()
+		if err != nil {
+			return nil, err
+		}
+		if err := certs.AddCertificate(cert); err != nil {
+			return nil, err
+		}
+	}
+	if c.ReloadInterval > 0 {
+		certs.Watch(c.ReloadInterval.Duration())
+	}
+	return certs, nil
+}
@@ -0,0 +1,88 @@
+package mux
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadConfig(t *testing.T) {
+	files := map[string]string{
+		"mux.yaml": "server:\n  readTimeout: 5s\n  writeTimeout: 7\n  tls:\n    minVersion: \"1.3\"\n    cipherSuites: [TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256]\nroute:\n  pathUnescape: false\n",
+		"mux.json": `{"server":{"readTimeout":"5s","writeTimeout":7,"tls":{"minVersion":"1.3","cipherSuites":["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"]}},"route":{"pathUnescape":false}}`,
+	}
+	for name, content := range files {
+		conf, err := LoadConfig(writeConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		s := conf.Server
+		//文件中的值覆盖默认值，数字表示秒
+		if s.ReadTimeout.Duration() != 5*time.Second || s.WriteTimeout.Duration() != 7*time.Second ||
+			s.TLS.MinVersion != "1.3" || len(s.TLS.CipherSuites) != 1 || conf.Route.PathUnescape {
+			t.Fatalf("%s: %+v %+v", name, s, conf.Route)
+		}
+		//没有配置的项保留默认值
+		def := DefaultConfig()
+		if s.IdleTimeout != def.Server.IdleTimeout || s.MaxHeaderBytes != def.Server.MaxHeaderBytes ||
+			s.TLS.ClientAuth != "none" || conf.Route.MaxMultipartMemory != def.Route.MaxMultipartMemory {
+			t.Fatalf("%s: defaults lost: %+v", name, s)
+		}
+
+		//环境变量覆盖文件
+		t.Setenv("MUX_SERVER_READ_TIMEOUT", "9s")
+		t.Setenv("MUX_SERVER_TLS_CIPHER_SUITES", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+		t.Setenv("MUX_ROUTE_PATH_UNESCAPE", "true")
+		if err := conf.LoadEnv("MUX"); err != nil {
+			t.Fatal(err)
+		}
+		s = conf.Server
+		if s.ReadTimeout.Duration() != 9*time.Second || s.WriteTimeout.Duration() != 7*time.Second ||
+			len(s.TLS.CipherSuites) != 2 || !conf.Route.PathUnescape {
+			t.Fatalf("%s after env: %+v", name, s)
+		}
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "mux.toml", "")); err == nil {
+		t.Fatal("unknown format was accepted")
+	}
+	t.Setenv("MUX_SERVER_MAX_HEADER_BYTES", "lots")
+	if err := DefaultConfig().LoadEnv("MUX"); err == nil {
+		t.Fatal("invalid env value was accepted")
+	}
+}
+
+func TestTLSConfigBuild(t *testing.T) {
+	conf, err := (&TLSConfig{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MinVersion != tls.VersionTLS12 || len(conf.CipherSuites) != 1 || conf.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("built %v %v", conf.MinVersion, conf.CipherSuites)
+	}
+	for _, c := range []TLSConfig{
+		{MinVersion: "1.0"},
+		{MinVersion: "1.1"},
+		{MinVersion: "tls1.3"},
+		//不安全的套件不在tls.CipherSuites中
+		{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA"}},
+		{CipherSuites: []string{"NO_SUCH_SUITE"}},
+		{ClientAuth: "always"},
+		{ClientAuth: "require_and_verify"},
+	} {
+		if _, err := c.Build(); err == nil {
+			t.Errorf("%+v was accepted", c)
+		}
+	}
+}
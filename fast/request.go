@@ -0,0 +1,481 @@
+package fast
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+)
+
+var (
+	errMalformed     = errors.New("fast: malformed request")
+	errHeaderTooBig  = errors.New("fast: request header too large")
+	errBodyTooBig    = errors.New("fast: request body too large")
+	errUnsupportedTE = errors.New("fast: unsupported transfer encoding")
+)
+
+//fast引擎解析出的请求，所有内存在连接内复用
+//Request只在handler执行期间有效，不要在handler返回后继续持有它或者它构造的http.Request
+type Request struct {
+	method     string
+	uri        string
+	proto      string
+	protoMajor int
+	protoMinor int
+	path       string
+	rawPath    string
+	rawQuery   string
+	remoteAddr string
+	host       string
+	close      bool
+	expect     bool
+
+	//按照读取的顺序保存header，header的key已经规范化
+	keys   []string
+	values []string
+
+	body       []byte
+	bodyReader bytes.Reader
+	bodyCloser body
+
+	//兼容net/http的请求，只在第一次访问HTTPRequest时构造
+	std      http.Request
+	stdURL   url.URL
+	stdHead  http.Header
+	stdReady bool
+}
+
+type body struct {
+	*bytes.Reader
+}
+
+func (body) Close() error {
+	return nil
+}
+
+func (r *Request) reset() {
+	r.method = ""
+	r.uri = ""
+	r.proto = ""
+	r.path = ""
+	r.rawPath = ""
+	r.rawQuery = ""
+	r.host = ""
+	r.close = false
+	r.expect = false
+	r.keys = r.keys[:0]
+	r.values = r.values[:0]
+	r.body = r.body[:0]
+	r.stdReady = false
+	r.std = http.Request{}
+	for k := range r.stdHead {
+		delete(r.stdHead, k)
+	}
+}
+
+func (r *Request) Method() string {
+	return r.method
+}
+
+func (r *Request) Path() string {
+	return r.path
+}
+
+func (r *Request) RawPath() string {
+	return r.rawPath
+}
+
+func (r *Request) RequestURI() string {
+	return r.uri
+}
+
+func (r *Request) RawQuery() string {
+	return r.rawQuery
+}
+
+func (r *Request) Proto() string {
+	return r.proto
+}
+
+func (r *Request) Host() string {
+	return r.host
+}
+
+func (r *Request) RemoteAddr() string {
+	return r.remoteAddr
+}
+
+func (r *Request) Header(key string) string {
+	for i := range r.keys {
+		if equalFold(r.keys[i], key) {
+			return r.values[i]
+		}
+	}
+	return ""
+}
+
+func (r *Request) HeaderValues(key string) []string {
+	var vals []string
+	for i := range r.keys {
+		if equalFold(r.keys[i], key) {
+			vals = append(vals, r.values[i])
+		}
+	}
+	return vals
+}
+
+func (r *Request) Body() io.Reader {
+	r.bodyReader.Reset(r.body)
+	return &r.bodyReader
+}
+
+func (r *Request) BodyBytes() []byte {
+	return r.body
+}
+
+//构造一个兼容net/http的请求，结构体与header的内存都会被复用
+func (r *Request) HTTPRequest() *http.Request {
+	if r.stdReady {
+		return &r.std
+	}
+	r.stdReady = true
+	if r.stdHead == nil {
+		r.stdHead = make(http.Header)
+	}
+	for i := range r.keys {
+		r.stdHead[r.keys[i]] = append(r.stdHead[r.keys[i]], r.values[i])
+	}
+	r.stdURL = url.URL{Path: r.path, RawQuery: r.rawQuery}
+	if r.rawPath != r.path {
+		r.stdURL.RawPath = r.rawPath
+	}
+	r.bodyReader.Reset(r.body)
+	r.bodyCloser.Reader = &r.bodyReader
+
+	r.std.Method = r.method
+	r.std.URL = &r.stdURL
+	r.std.Proto = r.proto
+	r.std.ProtoMajor = r.protoMajor
+	r.std.ProtoMinor = r.protoMinor
+	r.std.Header = r.stdHead
+	r.std.Body = r.bodyCloser
+	r.std.ContentLength = int64(len(r.body))
+	r.std.Close = r.close
+	r.std.Host = r.host
+	r.std.RemoteAddr = r.remoteAddr
+	r.std.RequestURI = r.uri
+	return &r.std
+}
+
+//从连接中读取一个请求
+func (r *Request) read(br *bufio.Reader, bw *bufio.Writer, maxHeader, maxBody int) error {
+	line, err := readLine(br, maxHeader)
+	if err != nil {
+		return err
+	}
+	//兼容一些客户端在keep-alive请求之间多发的空行
+	for len(line) == 0 {
+		if line, err = readLine(br, maxHeader); err != nil {
+			return err
+		}
+	}
+	size := len(line)
+	if err := r.parseRequestLine(line); err != nil {
+		return err
+	}
+
+	var contentLength int64 = -1
+	chunked, hasHost := false, false
+	for {
+		line, err := readLine(br, maxHeader)
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			break
+		}
+		if size += len(line); size > maxHeader {
+			return errHeaderTooBig
+		}
+		//名称必须是token，"Content-Length : 5"这类冒号前带空白的也会被拒绝
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 || !validHeaderNameBytes(line[:i]) {
+			return errMalformed
+		}
+		key := internKey(line[:i])
+		value := string(bytes.TrimSpace(line[i+1:]))
+		r.keys = append(r.keys, key)
+		r.values = append(r.values, value)
+
+		switch key {
+		case "Host":
+			if hasHost {
+				return errMalformed
+			}
+			hasHost = true
+			r.host = value
+		case "Content-Length":
+			n, ok := parseContentLength(value)
+			//重复的Content-Length只允许值完全相同
+			if !ok || contentLength >= 0 && n != contentLength {
+				return errMalformed
+			}
+			contentLength = n
+		case "Transfer-Encoding":
+			if chunked {
+				return errMalformed
+			}
+			if !equalFold(value, "chunked") {
+				return errUnsupportedTE
+			}
+			chunked = true
+		case "Connection":
+			if equalFold(value, "close") {
+				r.close = true
+			} else if equalFold(value, "keep-alive") && r.protoMinor == 0 {
+				r.close = false
+			}
+		case "Expect":
+			r.expect = equalFold(value, "100-continue")
+		}
+	}
+
+	//同时带有Content-Length和chunked是典型的请求走私手法，直接拒绝
+	if chunked && contentLength >= 0 {
+		return errMalformed
+	}
+	if !chunked && contentLength <= 0 {
+		return nil
+	}
+	if contentLength > int64(maxBody) {
+		return errBodyTooBig
+	}
+	if r.expect {
+		bw.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+	if chunked {
+		if err := r.readBody(httputil.NewChunkedReader(br), maxBody); err != nil {
+			return err
+		}
+		//chunked reader不会读取trailer，这里把它丢掉
+		for {
+			line, err := readLine(br, maxHeader)
+			if err != nil {
+				return err
+			}
+			if len(line) == 0 {
+				return nil
+			}
+		}
+	}
+	if cap(r.body) < int(contentLength) {
+		r.body = make([]byte, contentLength)
+	}
+	r.body = r.body[:contentLength]
+	_, err = io.ReadFull(br, r.body)
+	return err
+}
+
+func (r *Request) readBody(rd io.Reader, maxBody int) error {
+	buf := bytes.NewBuffer(r.body[:0])
+	n, err := buf.ReadFrom(io.LimitReader(rd, int64(maxBody)+1))
+	if err != nil {
+		return err
+	}
+	if n > int64(maxBody) {
+		return errBodyTooBig
+	}
+	r.body = buf.Bytes()
+	return nil
+}
+
+func (r *Request) parseRequestLine(line []byte) error {
+	i := bytes.IndexByte(line, ' ')
+	j := bytes.LastIndexByte(line, ' ')
+	if i <= 0 || j <= i {
+		return errMalformed
+	}
+	r.method = internMethod(line[:i])
+	r.uri = string(line[i+1 : j])
+	proto := line[j+1:]
+	switch string(proto) {
+	case "HTTP/1.1":
+		r.proto, r.protoMajor, r.protoMinor = "HTTP/1.1", 1, 1
+	case "HTTP/1.0":
+		r.proto, r.protoMajor, r.protoMinor = "HTTP/1.0", 1, 0
+		r.close = true
+	default:
+		return errMalformed
+	}
+
+	uri := r.uri
+	if q := indexByte(uri, '?'); q >= 0 {
+		r.rawQuery = uri[q+1:]
+		uri = uri[:q]
+	}
+	if uri == "" || uri[0] != '/' {
+		if uri == "*" && r.method == http.MethodOptions {
+			r.path, r.rawPath = uri, uri
+			return nil
+		}
+		//绝对路径形式的请求，例如代理请求
+		u, err := url.ParseRequestURI(r.uri)
+		if err != nil {
+			return errMalformed
+		}
+		r.path, r.rawPath, r.rawQuery = u.Path, u.EscapedPath(), u.RawQuery
+		return nil
+	}
+	r.rawPath = uri
+	r.path = uri
+	if indexByte(uri, '%') >= 0 {
+		p, err := url.PathUnescape(uri)
+		if err != nil {
+			return errMalformed
+		}
+		r.path = p
+	}
+	return nil
+}
+
+func readLine(br *bufio.Reader, max int) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errHeaderTooBig
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) > max {
+		return nil, errHeaderTooBig
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
+var methods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete,
+	http.MethodHead, http.MethodPatch, http.MethodOptions, http.MethodConnect, http.MethodTrace,
+}
+
+//常见的method直接返回常量，避免分配内存
+func internMethod(b []byte) string {
+	for _, m := range methods {
+		if string(b) == m {
+			return m
+		}
+	}
+	return string(b)
+}
+
+var commonKeys = []string{
+	"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cache-Control",
+	"Connection", "Content-Length", "Content-Type", "Cookie", "Expect", "Host",
+	"If-Modified-Since", "If-None-Match", "Origin", "Referer", "Transfer-Encoding",
+	"Upgrade", "User-Agent", "X-Forwarded-For", "X-Forwarded-Proto", "X-Real-Ip",
+	"X-Requested-With",
+}
+
+//常见的header直接返回规范化后的常量，其他的交给textproto处理
+func internKey(b []byte) string {
+	for _, k := range commonKeys {
+		if len(k) == len(b) && equalFoldBytes(b, k) {
+			return k
+		}
+	}
+	return textproto.CanonicalMIMEHeaderKey(string(b))
+}
+
+//header名称必须是RFC 7230中的token
+func validHeaderName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func validHeaderNameBytes(b []byte) bool {
+	for _, c := range b {
+		if !isTokenChar(c) {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+//只接受纯数字，strconv.ParseInt会接受"+5"这样的写法
+func parseContentLength(s string) (int64, bool) {
+	if s == "" || len(s) > 18 {
+		return 0, false
+	}
+	var n int64
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(s[i]-'0')
+	}
+	return n, true
+}
+
+func isTokenChar(c byte) bool {
+	if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+		return true
+	}
+	return indexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+func indexByte(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func equalFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if lower(a[i]) != lower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalFoldBytes(a []byte, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if lower(a[i]) != lower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package fast
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//fast引擎的响应，实现了http.ResponseWriter
+//body会先写入缓冲区，handler返回后再一次性写入连接，这样就可以设置Content-Length
+type Response struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        []byte
+	scratch     []byte
+}
+
+func (w *Response) reset() {
+	for k := range w.header {
+		delete(w.header, k)
+	}
+	w.status = 0
+	w.wroteHeader = false
+	w.body = w.body[:0]
+}
+
+func (w *Response) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *Response) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+}
+
+func (w *Response) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body = append(w.body, b...)
+	return len(b), nil
+}
+
+func (w *Response) WriteString(s string) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body = append(w.body, s...)
+	return len(s), nil
+}
+
+func (w *Response) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+//把响应写入连接
+func (w *Response) write(bw *bufio.Writer, head, close bool) error {
+	status := w.Status()
+	b := w.scratch[:0]
+	b = append(b, "HTTP/1.1 "...)
+	b = strconv.AppendInt(b, int64(status), 10)
+	b = append(b, ' ')
+	b = append(b, http.StatusText(status)...)
+	b = append(b, "\r\nDate: "...)
+	b = time.Now().UTC().AppendFormat(b, http.TimeFormat)
+	b = append(b, "\r\n"...)
+
+	bodyAllowed := status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+	for k, vs := range w.header {
+		if k == "Content-Length" || k == "Connection" || k == "Date" {
+			continue
+		}
+		//与net/http相同，丢弃不合法的header名称，值中的换行替换为空格，防止响应拆分
+		if !validHeaderName(k) {
+			continue
+		}
+		for _, v := range vs {
+			b = append(b, k...)
+			b = append(b, ": "...)
+			b = appendHeaderValue(b, v)
+			b = append(b, "\r\n"...)
+		}
+	}
+	if bodyAllowed {
+		if _, ok := w.header["Content-Type"]; !ok && len(w.body) > 0 {
+			b = append(b, "Content-Type: "...)
+			b = append(b, http.DetectContentType(w.body)...)
+			b = append(b, "\r\n"...)
+		}
+		b = append(b, "Content-Length: "...)
+		b = strconv.AppendInt(b, int64(len(w.body)), 10)
+		b = append(b, "\r\n"...)
+	}
+	if close {
+		b = append(b, "Connection: close\r\n"...)
+	}
+	b = append(b, "\r\n"...)
+	w.scratch = b
+
+	if _, err := bw.Write(b); err != nil {
+		return err
+	}
+	if bodyAllowed && !head {
+		if _, err := bw.Write(w.body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendHeaderValue(b []byte, v string) []byte {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c == '\r' || c == '\n' {
+			c = ' '
+		}
+		b = append(b, c)
+	}
+	return b
+}
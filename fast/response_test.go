@@ -0,0 +1,31 @@
+package fast
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestResponseHeaderInjection(t *testing.T) {
+	var w Response
+	w.Header().Set("Location", "/next\r\nSet-Cookie: evil=1")
+	w.Header()["Bad\r\nName"] = []string{"x"}
+	w.Header()["X-Split: a\r\nEvil"] = []string{"x"}
+	w.WriteString("ok")
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	if err := w.write(bw, false, false); err != nil {
+		t.Fatal(err)
+	}
+	bw.Flush()
+	head := buf.String()[:strings.Index(buf.String(), "\r\n\r\n")]
+	for _, line := range strings.Split(head, "\r\n") {
+		if strings.HasPrefix(line, "Set-Cookie") || strings.Contains(line, "Evil") || strings.Contains(line, "Bad") {
+			t.Fatalf("injected header line %q in\n%s", line, head)
+		}
+	}
+	if !strings.Contains(head, "Location: /next  Set-Cookie: evil=1") {
+		t.Fatalf("Location was not sanitized:\n%s", head)
+	}
+}
@@ -0,0 +1,369 @@
+//fast是一个参考fasthttp实现的HTTP/1.1引擎
+//连接、缓冲区、请求和响应对象全部复用，handler中尽量少地分配内存
+//它只支持HTTP/1.1与HTTP/1.0，需要HTTP/2或者Hijack的场景请使用net/http
+package fast
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxHeaderBytes     = 1 << 20
+	DefaultMaxRequestBodySize = 4 << 20
+	defaultBufferSize         = 4096
+	shutdownPollInterval      = 10 * time.Millisecond
+)
+
+//处理请求的接口，Request与Response都会被复用，不要在ServeFast返回后继续持有
+type Handler interface {
+	ServeFast(w *Response, r *Request)
+}
+
+type HandlerFunc func(w *Response, r *Request)
+
+func (f HandlerFunc) ServeFast(w *Response, r *Request) {
+	f(w, r)
+}
+
+type Server struct {
+	Handler Handler
+
+	//读取一个完整请求的超时时间
+	ReadTimeout time.Duration
+	//写入响应的超时时间
+	WriteTimeout time.Duration
+	//keep-alive连接等待下一个请求的超时时间，0时使用ReadTimeout
+	IdleTimeout time.Duration
+	//请求行与header的最大字节数
+	MaxHeaderBytes int
+	//请求body的最大字节数
+	MaxRequestBodySize int
+	//读写缓冲区的大小，请求行与单个header不能超过读缓冲区
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	//连接是否正在处理请求，Shutdown只关闭空闲的连接
+	conns  map[net.Conn]bool
+	closed bool
+
+	ctxPool    sync.Pool
+	readerPool sync.Pool
+	writerPool sync.Pool
+}
+
+var ErrServerClosed = errors.New("fast: Server closed")
+
+type serverCtx struct {
+	req  Request
+	resp Response
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+//在listener上处理请求，直到listener被关闭
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.track(ln, true) {
+		return ErrServerClosed
+	}
+	defer s.track(ln, false)
+
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go s.serveConn(conn)
+	}
+}
+
+//关闭所有的listener与连接
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	for ln := range s.listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+//优雅地关闭：停止接受新连接，关闭空闲的连接，等待处理中的请求结束
+//ctx结束时返回ctx的错误，剩下的连接仍然会在请求结束后关闭
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for ln := range s.listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+//关闭空闲的连接，返回是否已经没有连接了
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c, active := range s.conns {
+		if !active {
+			c.Close()
+			delete(s.conns, c)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) track(ln net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.closed {
+			return false
+		}
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[ln] = struct{}{}
+		return true
+	}
+	delete(s.listeners, ln)
+	return true
+}
+
+func (s *Server) trackConn(c net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]bool)
+		}
+		s.conns[c] = false
+		return
+	}
+	delete(s.conns, c)
+}
+
+//标记连接是否正在处理请求，已经被Shutdown关闭的连接返回false
+func (s *Server) setActive(c net.Conn, active bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[c]; !ok {
+		return false
+	}
+	s.conns[c] = active
+	return true
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	s.trackConn(conn, true)
+	defer func() {
+		conn.Close()
+		s.trackConn(conn, false)
+	}()
+
+	br := s.acquireReader(conn)
+	bw := s.acquireWriter(conn)
+	ctx := s.acquireCtx()
+	defer func() {
+		s.readerPool.Put(br)
+		s.writerPool.Put(bw)
+		s.ctxPool.Put(ctx)
+	}()
+
+	remoteAddr := conn.RemoteAddr().String()
+	maxHeader := s.MaxHeaderBytes
+	if maxHeader <= 0 {
+		maxHeader = DefaultMaxHeaderBytes
+	}
+	maxBody := s.MaxRequestBodySize
+	if maxBody <= 0 {
+		maxBody = DefaultMaxRequestBodySize
+	}
+
+	first := true
+	for {
+		if br.Buffered() == 0 {
+			//等待下一个请求，这段时间连接是空闲的
+			if !s.setActive(conn, false) {
+				return
+			}
+			if d := s.idleTimeout(); d > 0 && !first {
+				conn.SetReadDeadline(time.Now().Add(d))
+			} else if s.ReadTimeout > 0 {
+				conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+			}
+			if _, err := br.Peek(1); err != nil {
+				return
+			}
+		}
+		if !s.setActive(conn, true) {
+			return
+		}
+		first = false
+		if s.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		}
+
+		ctx.req.reset()
+		ctx.resp.reset()
+		ctx.req.remoteAddr = remoteAddr
+		if err := ctx.req.read(br, bw, maxHeader, maxBody); err != nil {
+			if err != io.EOF {
+				writeError(bw, err)
+			}
+			return
+		}
+
+		s.serve(ctx)
+
+		if s.WriteTimeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+		}
+		close := ctx.req.close || s.isClosed()
+		if err := ctx.resp.write(bw, ctx.req.method == http.MethodHead, close); err != nil {
+			return
+		}
+		//管线化的请求攒在一起写
+		if close || br.Buffered() == 0 {
+			if err := bw.Flush(); err != nil {
+				return
+			}
+		}
+		if close {
+			return
+		}
+	}
+}
+
+//handler中的panic不应该让整个进程崩溃
+func (s *Server) serve(ctx *serverCtx) {
+	defer func() {
+		if err := recover(); err != nil {
+			ctx.resp.reset()
+			ctx.resp.WriteHeader(http.StatusInternalServerError)
+			ctx.req.close = true
+		}
+	}()
+	s.Handler.ServeFast(&ctx.resp, &ctx.req)
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	return s.ReadTimeout
+}
+
+func writeError(bw *bufio.Writer, err error) {
+	status := http.StatusBadRequest
+	switch err {
+	case errHeaderTooBig:
+		status = http.StatusRequestHeaderFieldsTooLarge
+	case errBodyTooBig:
+		status = http.StatusRequestEntityTooLarge
+	case errUnsupportedTE:
+		status = http.StatusNotImplemented
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return
+	}
+	text := http.StatusText(status)
+	bw.WriteString("HTTP/1.1 ")
+	bw.WriteString(strconv.Itoa(status))
+	bw.WriteString(" ")
+	bw.WriteString(text)
+	bw.WriteString("\r\nContent-Type: text/plain; charset=utf-8\r\nConnection: close\r\nContent-Length: ")
+	bw.WriteString(strconv.Itoa(len(text)))
+	bw.WriteString("\r\n\r\n")
+	bw.WriteString(text)
+	bw.Flush()
+}
+
+func (s *Server) acquireCtx() *serverCtx {
+	if v := s.ctxPool.Get(); v != nil {
+		return v.(*serverCtx)
+	}
+	return &serverCtx{}
+}
+
+func (s *Server) acquireReader(conn net.Conn) *bufio.Reader {
+	if v := s.readerPool.Get(); v != nil {
+		br := v.(*bufio.Reader)
+		br.Reset(conn)
+		return br
+	}
+	size := s.ReadBufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	return bufio.NewReaderSize(conn, size)
+}
+
+func (s *Server) acquireWriter(conn net.Conn) *bufio.Writer {
+	if v := s.writerPool.Get(); v != nil {
+		bw := v.(*bufio.Writer)
+		bw.Reset(conn)
+		return bw
+	}
+	size := s.WriteBufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	return bufio.NewWriterSize(conn, size)
+}
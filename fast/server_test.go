@@ -0,0 +1,190 @@
+package fast
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+//启动一个回显请求方法、路径和body的服务器
+func startServer(t *testing.T, s *Server) string {
+	if s.Handler == nil {
+		s.Handler = HandlerFunc(func(w *Response, r *Request) {
+			w.WriteString(r.Method() + " " + r.Path() + " " + string(r.BodyBytes()))
+		})
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String()
+}
+
+//发送原始请求，读取直到服务器关闭连接
+func roundTrip(t *testing.T, addr, raw string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(raw)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestServerChunked(t *testing.T) {
+	addr := startServer(t, &Server{})
+	resp := roundTrip(t, addr, "POST /c HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n"+
+		"5\r\nhello\r\n6\r\n world\r\n0\r\nTrailer: x\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 200") || !strings.HasSuffix(resp, "POST /c hello world") {
+		t.Fatalf("unexpected response:\n%s", resp)
+	}
+}
+
+func TestServerContinue(t *testing.T) {
+	addr := startServer(t, &Server{})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("POST /e HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\nExpect: 100-continue\r\nConnection: close\r\n\r\n"))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "HTTP/1.1 100 Continue\r\n\r\n" {
+		t.Fatalf("expected 100 Continue before the body, got %q", got)
+	}
+	conn.Write([]byte("data"))
+	b, _ := ioutil.ReadAll(conn)
+	if resp := string(b); !strings.HasPrefix(resp, "HTTP/1.1 200") || !strings.HasSuffix(resp, "POST /e data") {
+		t.Fatalf("unexpected response:\n%s", resp)
+	}
+}
+
+func TestServerPipelining(t *testing.T) {
+	addr := startServer(t, &Server{})
+	resp := roundTrip(t, addr, "GET /1 HTTP/1.1\r\nHost: a\r\n\r\n"+
+		"POST /2 HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\n\r\nabc"+
+		"GET /3 HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+	if n := strings.Count(resp, "HTTP/1.1 200"); n != 3 {
+		t.Fatalf("expected 3 responses, got %d:\n%s", n, resp)
+	}
+	i1, i2, i3 := strings.Index(resp, "GET /1 "), strings.Index(resp, "POST /2 abc"), strings.Index(resp, "GET /3 ")
+	if i1 < 0 || i2 < i1 || i3 < i2 {
+		t.Fatalf("responses out of order:\n%s", resp)
+	}
+}
+
+func TestServerMalformed(t *testing.T) {
+	addr := startServer(t, &Server{})
+	cases := map[string]string{
+		"cl and te":        "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		"te and cl":        "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n0\r\n\r\n",
+		"conflicting cl":   "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\nabcd",
+		"space before :":   "POST / HTTP/1.1\r\nHost: a\r\nContent-Length : 3\r\n\r\nabc",
+		"signed cl":        "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +3\r\n\r\nabc",
+		"duplicate te":     "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		"duplicate host":   "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n",
+		"folded header":    "GET / HTTP/1.1\r\nHost: a\r\n X-Folded: 1\r\n\r\n",
+		"bad request line": "GET /\r\n\r\n",
+		"bad chunk size":   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n\r\n",
+	}
+	for name, raw := range cases {
+		//400之后连接必须关闭，后面附带的请求不能被执行
+		resp := roundTrip(t, addr, raw+"GET /smuggled HTTP/1.1\r\nHost: a\r\n\r\n")
+		if !strings.HasPrefix(resp, "HTTP/1.1 400") {
+			t.Errorf("%s: expected 400, got:\n%s", name, resp)
+		}
+		if strings.Contains(resp, "smuggled") || strings.Count(resp, "HTTP/1.1") != 1 {
+			t.Errorf("%s: connection was not closed after the error:\n%s", name, resp)
+		}
+	}
+	//相同的重复Content-Length与net/http一样是允许的
+	resp := roundTrip(t, addr, "POST /d HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nContent-Length: 3\r\nConnection: close\r\n\r\nabc")
+	if !strings.HasSuffix(resp, "POST /d abc") {
+		t.Fatalf("identical Content-Length rejected:\n%s", resp)
+	}
+}
+
+func TestServerOversize(t *testing.T) {
+	addr := startServer(t, &Server{MaxHeaderBytes: 256, MaxRequestBodySize: 8})
+	resp := roundTrip(t, addr, "GET / HTTP/1.1\r\nHost: a\r\nX-Big: "+strings.Repeat("a", 512)+"\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 431") {
+		t.Fatalf("expected 431, got:\n%s", resp)
+	}
+	resp = roundTrip(t, addr, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 9\r\n\r\n123456789")
+	if !strings.HasPrefix(resp, "HTTP/1.1 413") {
+		t.Fatalf("expected 413, got:\n%s", resp)
+	}
+	resp = roundTrip(t, addr, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n9\r\n123456789\r\n0\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 413") {
+		t.Fatalf("expected 413 for chunked body, got:\n%s", resp)
+	}
+	resp = roundTrip(t, addr, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 501") {
+		t.Fatalf("expected 501, got:\n%s", resp)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	s := &Server{Handler: HandlerFunc(func(w *Response, r *Request) {
+		if r.Path() == "/slow" {
+			close(entered)
+			<-release
+		}
+		w.WriteString("ok")
+	})}
+	addr := startServer(t, s)
+
+	//空闲的keep-alive连接会被立即关闭
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	idle.SetDeadline(time.Now().Add(5 * time.Second))
+	idle.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	buf := make([]byte, 256)
+	if _, err := idle.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := make(chan string, 1)
+	go func() { resp <- roundTrip(t, addr, "GET /slow HTTP/1.1\r\nHost: a\r\n\r\n") }()
+	<-entered
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	if _, err := idle.Read(buf); err == nil {
+		t.Fatal("idle connection was not closed")
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the request finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	//处理中的请求正常完成，并带上Connection: close
+	if got := <-resp; !strings.HasPrefix(got, "HTTP/1.1 200") || !strings.Contains(got, "Connection: close") {
+		t.Fatalf("unexpected response:\n%s", got)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,266 @@
+package mux
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+//net/http只实现了prior knowledge的h2c，这里补上RFC 7540 3.2节的Upgrade: h2c
+//升级请求hijack之后回复101，把请求编码成stream 1的HEADERS帧插到客户端的连接前言之后，
+//再把连接交给同一个http.Server按照prior knowledge处理，响应由它在stream 1上返回
+
+const (
+	http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	//客户端收到服务端的SETTINGS之前，帧不能超过这个大小
+	http2MaxFrameSize = 16384
+
+	frameHeaders      = 0x1
+	frameSettings     = 0x4
+	frameContinuation = 0x9
+
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+)
+
+var errUpgraderClosed = errors.New("mux: h2c upgrader closed")
+
+//包装http.Server的Handler，同时作为listener把升级后的连接交给http.Server
+type h2cUpgrader struct {
+	handler http.Handler
+	addr    net.Addr
+	conns   chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newH2CUpgrader(handler http.Handler, addr net.Addr) *h2cUpgrader {
+	return &h2cUpgrader{handler: handler, addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (u *h2cUpgrader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	settings, ok := h2cUpgradeSettings(r)
+	hj, canHijack := w.(http.Hijacker)
+	if !ok || !canHijack {
+		u.handler.ServeHTTP(w, r)
+		return
+	}
+	headers := appendHeaderFrames(nil, 1, true, encodeRequestHeaders(r))
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+	c := &h2cConn{Conn: conn, r: rw.Reader, settings: settings, headers: headers}
+	select {
+	case u.conns <- c:
+	case <-u.done:
+		conn.Close()
+	}
+}
+
+func (u *h2cUpgrader) Accept() (net.Conn, error) {
+	select {
+	case c := <-u.conns:
+		return c, nil
+	case <-u.done:
+		return nil, errUpgraderClosed
+	}
+}
+
+func (u *h2cUpgrader) Close() error {
+	u.once.Do(func() { close(u.done) })
+	return nil
+}
+
+func (u *h2cUpgrader) Addr() net.Addr {
+	return u.addr
+}
+
+//可以升级时返回HTTP2-Settings解码后的内容
+//带有请求体的请求需要先在HTTP/1.1上读完，这里不升级，仍然按照HTTP/1.1处理，RFC允许服务端忽略Upgrade
+func h2cUpgradeSettings(r *http.Request) ([]byte, bool) {
+	if r.ProtoMajor != 1 || r.ProtoMinor != 1 || r.Method == http.MethodConnect {
+		return nil, false
+	}
+	if r.ContentLength != 0 || len(r.TransferEncoding) > 0 {
+		return nil, false
+	}
+	if !headerHasToken(r.Header["Upgrade"], "h2c") ||
+		!headerHasToken(r.Header["Connection"], "upgrade") ||
+		!headerHasToken(r.Header["Connection"], "http2-settings") {
+		return nil, false
+	}
+	values := r.Header["Http2-Settings"]
+	if len(values) != 1 {
+		return nil, false
+	}
+	settings, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(values[0], "="))
+	if err != nil || len(settings)%6 != 0 || len(settings) > http2MaxFrameSize {
+		return nil, false
+	}
+	return settings, true
+}
+
+func headerHasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//HTTP/2中不能出现的连接相关的header
+var connectionHeaders = map[string]bool{
+	"Connection": true, "Upgrade": true, "Http2-Settings": true, "Keep-Alive": true,
+	"Proxy-Connection": true, "Transfer-Encoding": true, "Te": true, "Host": true,
+}
+
+//把升级请求编码成HPACK的header块，全部使用不加入索引的字面量，不需要维护动态表
+func encodeRequestHeaders(r *http.Request) []byte {
+	b := appendHpackField(nil, ":method", r.Method)
+	b = appendHpackField(b, ":scheme", "http")
+	b = appendHpackField(b, ":authority", r.Host)
+	b = appendHpackField(b, ":path", r.URL.RequestURI())
+	for k, vs := range r.Header {
+		if connectionHeaders[k] || headerHasToken(r.Header["Connection"], k) {
+			continue
+		}
+		name := strings.ToLower(k)
+		for _, v := range vs {
+			b = appendHpackField(b, name, v)
+		}
+	}
+	return b
+}
+
+func appendHpackField(b []byte, name, value string) []byte {
+	b = append(b, 0)
+	b = appendHpackString(b, name)
+	return appendHpackString(b, value)
+}
+
+//长度使用7位前缀的整数编码，不使用Huffman编码
+func appendHpackString(b []byte, s string) []byte {
+	n := len(s)
+	if n < 127 {
+		b = append(b, byte(n))
+	} else {
+		b = append(b, 127)
+		for n -= 127; n >= 128; n >>= 7 {
+			b = append(b, byte(n&127|128))
+		}
+		b = append(b, byte(n))
+	}
+	return append(b, s...)
+}
+
+//header块超过帧的大小时拆分成HEADERS与CONTINUATION
+func appendHeaderFrames(b []byte, stream uint32, endStream bool, block []byte) []byte {
+	typ, flags := byte(frameHeaders), byte(0)
+	if endStream {
+		flags = flagEndStream
+	}
+	for {
+		n := len(block)
+		if n > http2MaxFrameSize {
+			n = http2MaxFrameSize
+		} else {
+			flags |= flagEndHeaders
+		}
+		b = appendFrameHeader(b, n, typ, flags, stream)
+		b = append(b, block[:n]...)
+		block = block[n:]
+		if len(block) == 0 {
+			return b
+		}
+		typ, flags = frameContinuation, 0
+	}
+}
+
+func appendFrameHeader(b []byte, length int, typ, flags byte, stream uint32) []byte {
+	return append(b, byte(length>>16), byte(length>>8), byte(length), typ, flags,
+		byte(stream>>24&0x7f), byte(stream>>16), byte(stream>>8), byte(stream))
+}
+
+//升级后的连接，第一次读取时改写客户端的连接前言：
+//HTTP2-Settings合并到客户端的第一个SETTINGS帧中，由http.Server一起确认，然后插入stream 1的请求
+type h2cConn struct {
+	net.Conn
+	r        *bufio.Reader
+	settings []byte
+	headers  []byte
+	buf      []byte
+	started  bool
+}
+
+func (c *h2cConn) Read(p []byte) (int, error) {
+	if !c.started {
+		c.started = true
+		buf, err := c.preface()
+		if err != nil {
+			return 0, err
+		}
+		c.buf = buf
+	}
+	if len(c.buf) > 0 {
+		n := copy(p, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.r.Read(p)
+}
+
+func (c *h2cConn) preface() ([]byte, error) {
+	b := make([]byte, len(http2Preface)+9)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return nil, err
+	}
+	head := b[len(http2Preface):]
+	length := int(head[0])<<16 | int(head[1])<<8 | int(head[2])
+	//不是合法的连接前言时原样交给http.Server，由它关闭连接
+	if string(b[:len(http2Preface)]) != http2Preface || head[3] != frameSettings || head[4]&flagAck != 0 ||
+		length%6 != 0 || length > http2MaxFrameSize-len(c.settings) {
+		return b, nil
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return nil, err
+	}
+	settings := mergeSettings(c.settings, payload)
+	out := appendFrameHeader(b[:len(http2Preface)], len(settings), frameSettings, 0, 0)
+	out = append(out, settings...)
+	return append(out, c.headers...), nil
+}
+
+//net/http拒绝带有重复参数的SETTINGS帧，HTTP2-Settings中的参数只保留前言中没有的，前言中的值优先
+func mergeSettings(header, frame []byte) []byte {
+	seen := make(map[uint16]bool)
+	for i := 0; i+6 <= len(frame); i += 6 {
+		seen[binary.BigEndian.Uint16(frame[i:])] = true
+	}
+	var out []byte
+	//HTTP2-Settings中重复的参数后面的覆盖前面的
+	for i := len(header) - 6; i >= 0; i -= 6 {
+		id := binary.BigEndian.Uint16(header[i:])
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, header[i:i+6]...)
+		}
+	}
+	return append(out, frame...)
+}
@@ -0,0 +1,161 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrExpired = errors.New("jwt: token is expired")
+	//exp是必须的，没有过期时间的token无法撤销
+	ErrMissingExpiry = errors.New("jwt: token has no expiry")
+	ErrNotValidYet   = errors.New("jwt: token is not valid yet")
+	ErrIssuedAt      = errors.New("jwt: token is issued in the future")
+	ErrAudience      = errors.New("jwt: invalid audience")
+	ErrIssuer        = errors.New("jwt: invalid issuer")
+)
+
+//token中的payload，解析后的数字是float64
+type Claims map[string]interface{}
+
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+func (c Claims) Subject() string { return c.String("sub") }
+func (c Claims) Issuer() string  { return c.String("iss") }
+func (c Claims) ID() string      { return c.String("jti") }
+
+//aud可以是字符串也可以是数组
+func (c Claims) Audience() []string {
+	return c.Strings("aud")
+}
+
+//读取字符串或者字符串数组类型的claim
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+//读取时间类型的claim，例如exp、nbf、iat
+func (c Claims) Time(name string) (time.Time, bool) {
+	var sec float64
+	switch v := c[name].(type) {
+	case float64:
+		sec = v
+	case int64:
+		sec = float64(v)
+	case int:
+		sec = float64(v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		sec = f
+	default:
+		return time.Time{}, false
+	}
+	return time.Unix(int64(sec), 0), true
+}
+
+func (c Claims) ExpiresAt() (time.Time, bool) { return c.Time("exp") }
+
+//校验时间与签发方，leeway为允许的时钟误差
+func (c Claims) validate(now time.Time, leeway time.Duration, issuer, audience string) error {
+	exp, ok := c.Time("exp")
+	if !ok {
+		return ErrMissingExpiry
+	}
+	if !now.Before(exp.Add(leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := c.Time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return ErrNotValidYet
+	}
+	if iat, ok := c.Time("iat"); ok && now.Add(leeway).Before(iat) {
+		return ErrIssuedAt
+	}
+	if issuer != "" && c.Issuer() != issuer {
+		return ErrIssuer
+	}
+	if audience != "" {
+		found := false
+		for _, a := range c.Audience() {
+			if a == audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrAudience
+		}
+	}
+	return nil
+}
+
+//签发token，自动写入iss、aud、iat、nbf、exp与jti
+//
+//	signer := &jwt.Signer{Key: jwt.Key{ID: "k1", Key: priv}, Issuer: "https://api.example.com", TTL: time.Hour}
+//	token, err := signer.Issue("user-1", jwt.Claims{"role": "admin"})
+type Signer struct {
+	Key      Key
+	Issuer   string
+	Audience []string
+	//default:1h
+	TTL time.Duration
+	//default:time.Now
+	Now func() time.Time
+}
+
+//extra中的字段会覆盖自动写入的字段
+func (s *Signer) Issue(subject string, extra Claims) (string, error) {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	jti, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	claims := Claims{
+		"sub": subject,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+		"jti": jti,
+	}
+	if s.Issuer != "" {
+		claims["iss"] = s.Issuer
+	}
+	switch len(s.Audience) {
+	case 0:
+	case 1:
+		claims["aud"] = s.Audience[0]
+	default:
+		claims["aud"] = s.Audience
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return Sign(claims, s.Key)
+}
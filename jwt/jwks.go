@@ -0,0 +1,231 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//校验时查找密钥，kid为空时返回所有的密钥
+type KeySet interface {
+	Keys(kid string) ([]Key, error)
+}
+
+//固定的密钥，例如HS256的共享密钥
+type StaticKeys []Key
+
+func (s StaticKeys) Keys(kid string) ([]Key, error) {
+	if kid == "" {
+		return s, nil
+	}
+	var keys []Key
+	for _, k := range s {
+		if k.ID == kid || k.ID == "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+//RFC 7517中的一个密钥，只包含这里支持的字段
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	K   string `json:"k,omitempty"`
+}
+
+//把公钥编码为JWKS，用于发布签发方的密钥，私钥会被转换为公钥
+//HS256的密钥会以oct类型原样输出，只应当在内部使用
+func MarshalJWKS(keys ...Key) ([]byte, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: []jwk{}}
+	for _, k := range keys {
+		k = k.Public()
+		j := jwk{Kid: k.ID, Alg: k.Alg(), Use: "sig"}
+		switch key := k.Key.(type) {
+		case *rsa.PublicKey:
+			j.Kty = "RSA"
+			j.N = b64.EncodeToString(key.N.Bytes())
+			j.E = b64.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+		case *ecdsa.PublicKey:
+			if key.Curve != elliptic.P256() {
+				return nil, fmt.Errorf("jwt: unsupported curve for key %q", k.ID)
+			}
+			j.Kty, j.Crv = "EC", "P-256"
+			x, y := make([]byte, 32), make([]byte, 32)
+			key.X.FillBytes(x)
+			key.Y.FillBytes(y)
+			j.X, j.Y = b64.EncodeToString(x), b64.EncodeToString(y)
+		case ed25519.PublicKey:
+			j.Kty, j.Crv = "OKP", "Ed25519"
+			j.X = b64.EncodeToString(key)
+		case []byte:
+			j.Kty = "oct"
+			j.K = b64.EncodeToString(key)
+		default:
+			return nil, fmt.Errorf("jwt: unsupported key type %T", k.Key)
+		}
+		set.Keys = append(set.Keys, j)
+	}
+	return json.Marshal(set)
+}
+
+//解析JWKS，不支持的密钥会被跳过
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		key, err := j.key()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, Key{ID: j.Kid, Algorithm: j.Alg, Key: key})
+	}
+	return keys, nil
+}
+
+func (j jwk) key() (interface{}, error) {
+	dec := func(s string) *big.Int {
+		b, err := b64.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	switch j.Kty {
+	case "RSA":
+		n, e := dec(j.N), dec(j.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			return nil, errors.New("jwt: invalid RSA key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		x, y := dec(j.X), dec(j.Y)
+		if j.Crv != "P-256" || x == nil || y == nil || !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("jwt: invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		x, err := b64.DecodeString(j.X)
+		if j.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("jwt: invalid OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		k, err := b64.DecodeString(j.K)
+		if err != nil || len(k) == 0 {
+			return nil, errors.New("jwt: invalid oct key")
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("jwt: unsupported key type %q", j.Kty)
+}
+
+//从文件或者URL读取的JWKS，超过Refresh之后在下一次校验时重新读取
+//遇到未知的kid时也会重新读取，签发方轮换密钥后不需要重启，但两次读取至少间隔MinInterval
+type JWKS struct {
+	//default:1h
+	Refresh time.Duration
+	//default:1m
+	MinInterval time.Duration
+
+	load    func() ([]byte, error)
+	mu      sync.Mutex
+	keys    []Key
+	fetched time.Time
+	err     error
+}
+
+func NewJWKSFile(path string) *JWKS {
+	return &JWKS{load: func() ([]byte, error) {
+		return ioutil.ReadFile(path)
+	}}
+}
+
+//client为nil时使用http.DefaultClient
+func NewJWKSURL(url string, client *http.Client) *JWKS {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &JWKS{load: func() ([]byte, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("jwt: fetching %s: %s", url, resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}}
+}
+
+func (j *JWKS) Keys(kid string) ([]Key, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	refresh, min := j.Refresh, j.MinInterval
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	if min <= 0 {
+		min = time.Minute
+	}
+	since := time.Since(j.fetched)
+	if j.fetched.IsZero() || since > refresh || (!j.has(kid) && since > min) {
+		j.fetch()
+	}
+	if j.keys == nil && j.err != nil {
+		return nil, j.err
+	}
+	return StaticKeys(j.keys).Keys(kid)
+}
+
+//读取失败时保留之前的密钥
+func (j *JWKS) fetch() {
+	j.fetched = time.Now()
+	data, err := j.load()
+	if err == nil {
+		var keys []Key
+		if keys, err = ParseJWKS(data); err == nil {
+			j.keys = keys
+		}
+	}
+	j.err = err
+}
+
+func (j *JWKS) has(kid string) bool {
+	if kid == "" {
+		return len(j.keys) > 0
+	}
+	for _, k := range j.keys {
+		if k.ID == kid {
+			return true
+		}
+	}
+	return false
+}
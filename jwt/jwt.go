@@ -0,0 +1,230 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+//支持的签名算法
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrMalformed = errors.New("jwt: malformed token")
+	//header中的alg不在允许的列表中，或者与密钥的类型不匹配
+	ErrAlgorithm = errors.New("jwt: unexpected signing algorithm")
+	ErrSignature = errors.New("jwt: invalid signature")
+	//没有找到header中kid对应的密钥
+	ErrKeyNotFound = errors.New("jwt: signing key not found")
+)
+
+//签名或者校验使用的密钥
+//HS256使用[]byte，RS256使用*rsa.PrivateKey/*rsa.PublicKey，ES256使用P-256的*ecdsa.PrivateKey/*ecdsa.PublicKey，
+//EdDSA使用ed25519.PrivateKey/ed25519.PublicKey
+type Key struct {
+	//写入header的kid，校验时按照它查找密钥
+	ID string
+	//为空时按照Key的类型推断
+	Algorithm string
+	Key       interface{}
+}
+
+//密钥对应的算法，类型不支持时返回空字符串
+func (k Key) Alg() string {
+	if k.Algorithm != "" {
+		return k.Algorithm
+	}
+	switch key := k.Key.(type) {
+	case []byte:
+		return HS256
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return RS256
+	case *ecdsa.PrivateKey:
+		if key.Curve == elliptic.P256() {
+			return ES256
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			return ES256
+		}
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return EdDSA
+	}
+	return ""
+}
+
+//私钥对应的公钥，用于发布JWKS，HS256的密钥原样返回
+func (k Key) Public() Key {
+	switch key := k.Key.(type) {
+	case *rsa.PrivateKey:
+		k.Key = &key.PublicKey
+	case *ecdsa.PrivateKey:
+		k.Key = &key.PublicKey
+	case ed25519.PrivateKey:
+		k.Key = key.Public().(ed25519.PublicKey)
+	}
+	return k
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+var b64 = base64.RawURLEncoding
+
+//签名生成token，claims原样写入payload，不会补充任何字段，通常应当使用Signer
+func Sign(claims Claims, key Key) (string, error) {
+	alg := key.Alg()
+	if alg == "" {
+		return "", ErrAlgorithm
+	}
+	h, err := json.Marshal(header{Alg: alg, Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signing := b64.EncodeToString(h) + "." + b64.EncodeToString(p)
+	sig, err := sign(alg, key.Key, []byte(signing))
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + b64.EncodeToString(sig), nil
+}
+
+func sign(alg string, key interface{}, data []byte) ([]byte, error) {
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return nil, ErrAlgorithm
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case RS256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, ErrAlgorithm
+		}
+		sum := sha256.Sum256(data)
+		return rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, sum[:])
+	case ES256:
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok || priv.Curve != elliptic.P256() {
+			return nil, ErrAlgorithm
+		}
+		sum := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, priv, sum[:])
+		if err != nil {
+			return nil, err
+		}
+		//JWS使用固定长度的r||s，而不是ASN.1
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	case EdDSA:
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, ErrAlgorithm
+		}
+		return ed25519.Sign(priv, data), nil
+	}
+	return nil, ErrAlgorithm
+}
+
+//校验签名，密钥的类型必须与alg一致，避免用RSA公钥当作HMAC密钥之类的算法混淆
+func verify(alg string, key interface{}, data, sig []byte) bool {
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case RS256:
+		var pub *rsa.PublicKey
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			pub = k
+		case *rsa.PrivateKey:
+			pub = &k.PublicKey
+		default:
+			return false
+		}
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case ES256:
+		var pub *ecdsa.PublicKey
+		switch k := key.(type) {
+		case *ecdsa.PublicKey:
+			pub = k
+		case *ecdsa.PrivateKey:
+			pub = &k.PublicKey
+		default:
+			return false
+		}
+		if pub.Curve != elliptic.P256() || len(sig) != 64 {
+			return false
+		}
+		sum := sha256.Sum256(data)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	case EdDSA:
+		var pub ed25519.PublicKey
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			pub = k
+		case ed25519.PrivateKey:
+			pub = k.Public().(ed25519.PublicKey)
+		default:
+			return false
+		}
+		return len(pub) == ed25519.PublicKeySize && ed25519.Verify(pub, data, sig)
+	}
+	return false
+}
+
+//拆分并解析token，不校验签名
+func split(token string) (h header, claims Claims, signing string, sig []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return h, nil, "", nil, ErrMalformed
+	}
+	hb, err1 := b64.DecodeString(parts[0])
+	pb, err2 := b64.DecodeString(parts[1])
+	sig, err3 := b64.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return h, nil, "", nil, ErrMalformed
+	}
+	if json.Unmarshal(hb, &h) != nil || h.Alg == "" {
+		return h, nil, "", nil, ErrMalformed
+	}
+	if json.Unmarshal(pb, &claims) != nil || claims == nil {
+		return h, nil, "", nil, ErrMalformed
+	}
+	return h, claims, parts[0] + "." + parts[1], sig, nil
+}
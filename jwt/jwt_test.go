@@ -0,0 +1,204 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"mux/route"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testKeys(t *testing.T) []Key {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []Key{
+		{ID: "hs", Key: []byte("0123456789abcdef0123456789abcdef")},
+		{ID: "rs", Key: rsaKey},
+		{ID: "es", Key: ecKey},
+		{ID: "ed", Key: edKey},
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	keys := testKeys(t)
+	var public StaticKeys
+	for _, k := range keys {
+		public = append(public, k.Public())
+	}
+	v := New(Config{Keys: public})
+	for _, k := range keys {
+		token, err := (&Signer{Key: k}).Issue("alice", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", k.ID, err)
+		}
+		claims, err := v.Parse(token)
+		if err != nil {
+			t.Fatalf("%s: %v", k.ID, err)
+		}
+		if claims.Subject() != "alice" {
+			t.Fatalf("%s: sub = %q", k.ID, claims.Subject())
+		}
+		parts := strings.Split(token, ".")
+		parts[1] = b64.EncodeToString([]byte(`{"sub":"mallory","exp":9999999999}`))
+		if _, err := v.Parse(strings.Join(parts, ".")); err != ErrSignature {
+			t.Fatalf("%s: tampered token: %v", k.ID, err)
+		}
+	}
+
+	//用RSA公钥的字节作为HMAC密钥伪造的token不能通过
+	forged, _ := Sign(Claims{"exp": time.Now().Add(time.Hour).Unix()}, Key{ID: "rs", Algorithm: HS256, Key: []byte("anything")})
+	if _, err := v.Parse(forged); err != ErrKeyNotFound {
+		t.Fatalf("algorithm confusion: %v", err)
+	}
+	if _, err := New(Config{Keys: public, Algorithms: []string{RS256}}).Parse(forged); err != ErrAlgorithm {
+		t.Fatalf("disallowed algorithm: %v", err)
+	}
+}
+
+func TestClaims(t *testing.T) {
+	key := Key{Key: []byte("0123456789abcdef0123456789abcdef")}
+	now := time.Unix(1700000000, 0)
+	v := New(Config{
+		Keys:     StaticKeys{key},
+		Issuer:   "https://issuer",
+		Audience: "api",
+		Leeway:   30 * time.Second,
+		Now:      func() time.Time { return now },
+	})
+	base := func(extra Claims) Claims {
+		c := Claims{"iss": "https://issuer", "aud": []string{"web", "api"}, "exp": now.Add(time.Minute).Unix()}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	for _, c := range []struct {
+		name   string
+		claims Claims
+		err    error
+	}{
+		{"valid", base(nil), nil},
+		{"expired within leeway", base(Claims{"exp": now.Add(-10 * time.Second).Unix()}), nil},
+		{"expired", base(Claims{"exp": now.Add(-time.Minute).Unix()}), ErrExpired},
+		{"no exp", Claims{"iss": "https://issuer", "aud": "api"}, ErrMissingExpiry},
+		{"nbf", base(Claims{"nbf": now.Add(time.Minute).Unix()}), ErrNotValidYet},
+		{"iat", base(Claims{"iat": now.Add(time.Minute).Unix()}), ErrIssuedAt},
+		{"issuer", base(Claims{"iss": "https://other"}), ErrIssuer},
+		{"audience", base(Claims{"aud": "web"}), ErrAudience},
+	} {
+		token, err := Sign(c.claims, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Parse(token); err != c.err {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.err)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	keys := testKeys(t)
+	data, err := MarshalJWKS(keys[1:]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "jwks.json")
+	ioutil.WriteFile(file, data, 0600)
+
+	for name, set := range map[string]KeySet{"url": NewJWKSURL(srv.URL, srv.Client()), "file": NewJWKSFile(file)} {
+		v := New(Config{Keys: set})
+		for _, k := range keys[1:] {
+			token, _ := (&Signer{Key: k}).Issue("bob", nil)
+			if _, err := v.Parse(token); err != nil {
+				t.Errorf("%s/%s: %v", name, k.ID, err)
+			}
+		}
+		//未知的kid在MinInterval之内不会重新读取
+		token, _ := (&Signer{Key: Key{ID: "unknown", Key: keys[3].Key}}).Issue("bob", nil)
+		if _, err := v.Parse(token); err != ErrKeyNotFound {
+			t.Errorf("%s: unknown kid: %v", name, err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	key := Key{ID: "k", Key: []byte("0123456789abcdef0123456789abcdef")}
+	deny := NewMemoryDenyList()
+	v := New(Config{Keys: StaticKeys{key}, DenyList: deny})
+	r := route.New(&route.Config{}, nil)
+	r.GET("/me", v.Handler(), func(c *route.Context) {
+		c.WriteString(http.StatusOK, FromContext(c).Subject())
+	})
+	admin := r.Group("/admin", v.Handler()).Authorize(route.RequireRoles("admin"), route.RequirePermissions("write"))
+	admin.GET("/", func(c *route.Context) {})
+	do := func(auth string, path ...string) *httptest.ResponseRecorder {
+		p := "/me"
+		if len(path) > 0 {
+			p = path[0]
+		}
+		req := httptest.NewRequest("GET", p, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.Run(w, req)
+		return w
+	}
+
+	token, _ := (&Signer{Key: key}).Issue("carol", nil)
+	if w := do("Bearer " + token); w.Code != http.StatusOK || w.Body.String() != "carol" {
+		t.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+	}
+	if w := do(""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("missing token: %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	//roles与scope映射为principal的角色与权限
+	adminToken, _ := (&Signer{Key: key}).Issue("dave", Claims{"roles": []string{"admin"}, "scope": "read write"})
+	if w := do("Bearer "+adminToken, "/admin"); w.Code != http.StatusOK {
+		t.Fatalf("admin: %d", w.Code)
+	}
+	if w := do("Bearer "+token, "/admin"); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin: %d", w.Code)
+	}
+
+	claims, _ := v.Parse(token)
+	deny.RevokeClaims(claims)
+	if w := do("Bearer " + token); w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "revoked") {
+		t.Fatalf("revoked token: %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
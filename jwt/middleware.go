@@ -0,0 +1,244 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mux/route"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Context中保存解析结果的key
+const (
+	//Claims
+	ClaimsKey = "jwt.claims"
+	//原始的token字符串
+	TokenKey = "jwt.token"
+)
+
+var (
+	ErrNoToken = errors.New("jwt: no bearer token")
+	ErrRevoked = errors.New("jwt: token has been revoked")
+)
+
+//撤销token的名单，例如退出登录或者密钥泄露
+type DenyList interface {
+	Revoked(claims Claims) (bool, error)
+}
+
+type Config struct {
+	Keys KeySet
+	//允许的算法，为空时允许所有支持的算法
+	Algorithms []string
+	//不为空时校验iss
+	Issuer string
+	//不为空时aud必须包含它
+	Audience string
+	//允许的时钟误差
+	Leeway   time.Duration
+	DenyList DenyList
+	//除了Authorization头部，还可以从cookie中读取token
+	Cookie string
+	//default:time.Now
+	Now func() time.Time
+	//校验失败时调用，default:返回401与WWW-Authenticate
+	ErrorHandler func(c *route.Context, err error)
+}
+
+//校验bearer token
+//
+//	v := jwt.New(jwt.Config{Keys: jwt.NewJWKSURL("https://idp/.well-known/jwks.json", nil), Audience: "api"})
+//	api := m.Group("/api", v.Handler())
+//	api.GET("/me", func(c *route.Context) {
+//		claims := jwt.FromContext(c)
+//	})
+type Verifier struct {
+	conf Config
+}
+
+func New(conf Config) *Verifier {
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
+	if conf.ErrorHandler == nil {
+		conf.ErrorHandler = func(c *route.Context, err error) {
+			desc := strings.TrimPrefix(err.Error(), "jwt: ")
+			if err == ErrNoToken {
+				c.Writer.Header().Set("WWW-Authenticate", `Bearer`)
+			} else {
+				c.Writer.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+desc+`"`)
+			}
+			http.Error(c.Writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+	}
+	return &Verifier{conf: conf}
+}
+
+//校验签名与claims，返回解析后的claims
+func (v *Verifier) Parse(token string) (Claims, error) {
+	h, claims, signing, sig, err := split(token)
+	if err != nil {
+		return nil, err
+	}
+	if !v.allowed(h.Alg) {
+		return nil, ErrAlgorithm
+	}
+	if v.conf.Keys == nil {
+		return nil, ErrKeyNotFound
+	}
+	keys, err := v.conf.Keys.Keys(h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	matched, verified := false, false
+	for _, k := range keys {
+		if k.Alg() != h.Alg {
+			continue
+		}
+		matched = true
+		if verify(h.Alg, k.Key, []byte(signing), sig) {
+			verified = true
+			break
+		}
+	}
+	if !matched {
+		return nil, ErrKeyNotFound
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+	if err := claims.validate(v.conf.Now(), v.conf.Leeway, v.conf.Issuer, v.conf.Audience); err != nil {
+		return nil, err
+	}
+	if v.conf.DenyList != nil {
+		revoked, err := v.conf.DenyList.Revoked(claims)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrRevoked
+		}
+	}
+	return claims, nil
+}
+
+func (v *Verifier) allowed(alg string) bool {
+	switch alg {
+	case HS256, RS256, ES256, EdDSA:
+	default:
+		return false
+	}
+	if len(v.conf.Algorithms) == 0 {
+		return true
+	}
+	for _, a := range v.conf.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) token(c *route.Context) string {
+	auth := c.HeaderGet("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if v.conf.Cookie != "" {
+		if ck, err := c.CookieGet(v.conf.Cookie); err == nil {
+			return ck.Value
+		}
+	}
+	return ""
+}
+
+//作为中间件使用，校验通过后把claims保存在ClaimsKey中，并写入Context.Principal
+//principal的角色来自roles，权限来自permissions与scope
+func (v *Verifier) Handler() route.HandlerFunc {
+	return func(c *route.Context) {
+		token := v.token(c)
+		if token == "" {
+			v.conf.ErrorHandler(c, ErrNoToken)
+			c.Abort()
+			return
+		}
+		claims, err := v.Parse(token)
+		if err != nil {
+			v.conf.ErrorHandler(c, err)
+			c.Abort()
+			return
+		}
+		c.Set(ClaimsKey, claims)
+		c.Set(TokenKey, token)
+		c.SetPrincipal(&route.Principal{
+			ID:          claims.Subject(),
+			Method:      "jwt",
+			Roles:       claims.Strings("roles"),
+			Permissions: append(claims.Strings("permissions"), strings.Fields(claims.String("scope"))...),
+			Attributes:  map[string]interface{}(claims),
+		})
+		c.Next()
+	}
+}
+
+//读取中间件保存的claims，没有通过校验时返回nil
+func FromContext(c *route.Context) Claims {
+	v, _ := c.Get(ClaimsKey)
+	claims, _ := v.(Claims)
+	return claims
+}
+
+//保存在内存中的撤销名单，按照jti撤销，过期后自动清理
+type MemoryDenyList struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+func NewMemoryDenyList() *MemoryDenyList {
+	return &MemoryDenyList{ids: make(map[string]time.Time)}
+}
+
+//撤销token，until通常是token的exp，之后token本身已经过期，不需要再记录
+func (d *MemoryDenyList) Revoke(jti string, until time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for id, t := range d.ids {
+		if now.After(t) {
+			delete(d.ids, id)
+		}
+	}
+	d.ids[jti] = until
+}
+
+//撤销claims对应的token
+func (d *MemoryDenyList) RevokeClaims(claims Claims) {
+	until, ok := claims.ExpiresAt()
+	if !ok {
+		until = time.Now().Add(24 * time.Hour)
+	}
+	d.Revoke(claims.ID(), until)
+}
+
+func (d *MemoryDenyList) Revoked(claims Claims) (bool, error) {
+	jti := claims.ID()
+	if jti == "" {
+		return false, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.ids[jti]
+	return ok, nil
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
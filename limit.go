@@ -0,0 +1,109 @@
+package mux
+
+import (
+	"mux/route"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//连接数与并发请求数的限制
+type LimitConfig struct {
+	//同时保持的最大连接数，超出的连接会被直接关闭，0不限制
+	MaxConns int
+	//同时处理的最大请求数，0不限制
+	MaxRequests int
+	//请求排队等待的最长时间，超时返回503
+	QueueTimeout time.Duration
+	//503响应中的Retry-After
+	RetryAfter time.Duration
+}
+
+//被拒绝的连接数与请求数
+type LimitStats struct {
+	RejectedConns    uint64
+	RejectedRequests uint64
+	OpenConns        int64
+	InFlightRequests int
+}
+
+//设置全局的连接与并发限制，应当在Run之前调用
+func (m *Mux) Limit(conf LimitConfig) {
+	m.limitConf = conf
+	m.limiter = nil
+	if conf.MaxRequests > 0 {
+		m.limiter = route.NewLimiter(conf.MaxRequests, conf.QueueTimeout, conf.RetryAfter)
+	}
+}
+
+func (m *Mux) LimitStats() LimitStats {
+	var stats LimitStats
+	stats.RejectedConns = atomic.LoadUint64(&m.rejectedConns)
+	stats.OpenConns = atomic.LoadInt64(&m.openConns)
+	if m.limiter != nil {
+		stats.RejectedRequests = m.limiter.Rejected()
+		stats.InFlightRequests = m.limiter.InFlight()
+	}
+	return stats
+}
+
+//按照配置包装listener
+func (m *Mux) limitListener(l net.Listener) net.Listener {
+	if m.limitConf.MaxConns <= 0 {
+		return l
+	}
+	return &limitListener{
+		Listener: l,
+		max:      int64(m.limitConf.MaxConns),
+		open:     &m.openConns,
+		rejected: &m.rejectedConns,
+	}
+}
+
+//限制最大连接数的listener，超出限制的连接会被立即关闭
+func LimitListener(l net.Listener, n int) net.Listener {
+	return &limitListener{
+		Listener: l,
+		max:      int64(n),
+		open:     new(int64),
+		rejected: new(uint64),
+	}
+}
+
+type limitListener struct {
+	net.Listener
+	max      int64
+	open     *int64
+	rejected *uint64
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if atomic.AddInt64(l.open, 1) > l.max {
+			atomic.AddInt64(l.open, -1)
+			atomic.AddUint64(l.rejected, 1)
+			conn.Close()
+			continue
+		}
+		return &limitConn{Conn: conn, open: l.open}, nil
+	}
+}
+
+type limitConn struct {
+	net.Conn
+	open *int64
+	once sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		atomic.AddInt64(c.open, -1)
+	})
+	return err
+}
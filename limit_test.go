@@ -0,0 +1,101 @@
+package mux
+
+import (
+	"io"
+	"mux/route"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitRequests(t *testing.T) {
+	m := NewMux(&route.Config{})
+	m.Limit(LimitConfig{MaxRequests: 1, RetryAfter: time.Second})
+	entered, release := make(chan struct{}), make(chan struct{})
+	m.GET("/slow", func(c *route.Context) {
+		entered <- struct{}{}
+		<-release
+	})
+	m.GET("/", func(c *route.Context) {})
+
+	done := make(chan struct{})
+	go func() {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+		close(done)
+	}()
+	<-entered
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("limited: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if stats := m.LimitStats(); stats.RejectedRequests != 1 || stats.InFlightRequests != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	close(release)
+	<-done
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("after release: %d", w.Code)
+	}
+}
+
+//连接被服务端关闭时Read返回EOF，仍然打开时读取超时
+func closedByServer(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	return err == io.EOF
+}
+
+func TestLimitListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMux(&route.Config{})
+	m.Limit(LimitConfig{MaxConns: 1})
+	l := m.limitListener(ln)
+	defer l.Close()
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	first := dial()
+	defer first.Close()
+	server := <-accepted
+	//超出限制的连接被立即关闭
+	second := dial()
+	defer second.Close()
+	if !closedByServer(second) {
+		t.Fatal("second connection was not closed")
+	}
+	if stats := m.LimitStats(); stats.OpenConns != 1 || stats.RejectedConns != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	//关闭之后名额被释放，重复关闭不会多释放
+	server.Close()
+	server.Close()
+	third := dial()
+	defer third.Close()
+	<-accepted
+	if stats := m.LimitStats(); stats.OpenConns != 1 || stats.RejectedConns != 1 {
+		t.Fatalf("stats after close = %+v", stats)
+	}
+}
@@ -0,0 +1,256 @@
+package mux
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+//一个监听地址的配置
+type ListenConfig struct {
+	//tcp、unix或者fd，default:tcp
+	Network string `json:"network" yaml:"network"`
+	//tcp为host:port，unix为socket文件的路径，fd为继承的文件描述符，例如3
+	Addr string `json:"addr" yaml:"addr"`
+	//unix socket文件的权限，default:0660
+	Mode uint32 `json:"mode" yaml:"mode"`
+	//是否使用https，证书来自Mux.Certs或者Server.TLS的配置
+	TLS bool `json:"tls" yaml:"tls"`
+	//非https的listener上是否支持明文的HTTP/2(h2c)
+	//支持prior knowledge（例如curl --http2-prior-knowledge）与Upgrade: h2c（例如curl --http2）
+	//带有请求体的升级请求仍然按照HTTP/1.1处理
+	H2C bool `json:"h2c" yaml:"h2c"`
+}
+
+//按照配置打开listener
+func (c *ListenConfig) Listen() (net.Listener, error) {
+	switch c.Network {
+	case "", "tcp", "tcp4", "tcp6":
+		network := c.Network
+		if network == "" {
+			network = "tcp"
+		}
+		return net.Listen(network, c.Addr)
+	case "unix":
+		mode := os.FileMode(c.Mode)
+		if mode == 0 {
+			mode = 0660
+		}
+		return ListenUnix(c.Addr, mode)
+	case "fd":
+		fd, err := strconv.ParseUint(c.Addr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("mux: invalid fd %q", c.Addr)
+		}
+		return ListenFD(uintptr(fd))
+	}
+	return nil, fmt.Errorf("mux: unknown network %q", c.Network)
+}
+
+//监听unix socket，会删除上次运行残留的socket文件，并设置文件权限
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("mux: %s exists and is not a socket", path)
+		}
+		//还能连上说明有其他进程正在使用
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("mux: %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+//使用继承的文件描述符，用于socket activation或者不停机重启
+func ListenFD(fd uintptr) (net.Listener, error) {
+	f := os.NewFile(fd, "listener-"+strconv.FormatUint(uint64(fd), 10))
+	if f == nil {
+		return nil, fmt.Errorf("mux: invalid fd %d", fd)
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+//systemd socket activation传入的listener，没有时返回nil
+//systemd从3开始传递文件描述符，数量在LISTEN_FDS中
+func SystemdListeners() ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	ls := make([]net.Listener, 0, n)
+	for fd := 3; fd < 3+n; fd++ {
+		ln, err := ListenFD(uintptr(fd))
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, err
+		}
+		ls = append(ls, ln)
+	}
+	return ls, nil
+}
+
+//获取listener的文件描述符，可以通过exec.Cmd.ExtraFiles传给新的进程实现不停机重启
+func ListenerFile(ln net.Listener) (*os.File, error) {
+	f, ok := ln.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, errors.New("mux: listener does not support File")
+	}
+	return f.File()
+}
+
+//已经打开的listener，以及它使用的协议
+type Listener struct {
+	net.Listener
+	TLS bool
+	H2C bool
+}
+
+//按照配置同时监听多个地址，没有传入配置时使用ServerConf.Listeners
+//任意一个listener出错时会关闭其他所有的listener并返回这个错误
+func (m *Mux) RunListeners(confs ...ListenConfig) error {
+	if len(confs) == 0 {
+		confs = m.ServerConf.Listeners
+	}
+	if len(confs) == 0 {
+		return errors.New("mux: no listener configured")
+	}
+	ls := make([]Listener, 0, len(confs))
+	for _, c := range confs {
+		ln, err := c.Listen()
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return err
+		}
+		ls = append(ls, Listener{Listener: ln, TLS: c.TLS, H2C: c.H2C})
+	}
+	return m.ServeListeners(ls...)
+}
+
+//在多个已经打开的listener上提供服务
+func (m *Mux) ServeListeners(ls ...Listener) error {
+	var tlsConf *tls.Config
+	for _, l := range ls {
+		if !l.TLS {
+			continue
+		}
+		conf, closer, err := m.tlsConfig("", "")
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return err
+		}
+		defer closer()
+		tlsConf = conf
+		break
+	}
+
+	errs := make(chan error, len(ls))
+	servers := make([]*http.Server, 0, len(ls))
+	for _, l := range ls {
+		srv := m.ServerConf.NewServer(l.Addr().String(), m)
+		//net/http只实现了prior knowledge的h2c，Upgrade: h2c由h2cUpgrader处理后交回srv
+		if l.H2C && !l.TLS {
+			srv.Protocols = new(http.Protocols)
+			srv.Protocols.SetHTTP1(true)
+			srv.Protocols.SetUnencryptedHTTP2(true)
+			up := newH2CUpgrader(srv.Handler, l.Addr())
+			srv.Handler = up
+			go srv.Serve(up)
+		}
+		if l.TLS {
+			srv.TLSConfig = tlsConf.Clone()
+		}
+		m.trackServer(srv, true)
+		servers = append(servers, srv)
+		go func(srv *http.Server, ln net.Listener, useTLS bool) {
+			if useTLS {
+				errs <- srv.ServeTLS(ln, "", "")
+				return
+			}
+			errs <- srv.Serve(ln)
+		}(srv, m.limitListener(l.Listener), l.TLS)
+	}
+
+	//Shutdown时所有的服务都会自己结束，否则关闭其他的服务
+	err := <-errs
+	if err != http.ErrServerClosed {
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}
+	for i := 1; i < len(servers); i++ {
+		<-errs
+	}
+	for _, srv := range servers {
+		m.trackServer(srv, false)
+	}
+	return err
+}
+
+//http.Server与fast.Server都实现了优雅关闭
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+//优雅地关闭Run、RunTSL、RunFast、RunListeners与ServeListeners启动的服务，等待处理中的请求结束
+func (m *Mux) Shutdown(ctx context.Context) error {
+	m.serversMu.Lock()
+	servers := make([]shutdowner, 0, len(m.servers))
+	for srv := range m.servers {
+		servers = append(servers, srv)
+	}
+	m.serversMu.Unlock()
+
+	var first error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m *Mux) trackServer(srv shutdowner, add bool) {
+	m.serversMu.Lock()
+	defer m.serversMu.Unlock()
+	if add {
+		if m.servers == nil {
+			m.servers = make(map[shutdowner]struct{})
+		}
+		m.servers[srv] = struct{}{}
+		return
+	}
+	delete(m.servers, srv)
+}
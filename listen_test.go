@@ -0,0 +1,389 @@
+package mux
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"mux/fast"
+	"mux/route"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newListenMux() *Mux {
+	m := NewMux(&route.Config{})
+	m.GET("/", func(c *route.Context) {
+		c.WriteString(http.StatusOK, c.Request().Proto)
+	})
+	return m
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	bs, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %d", url, resp.StatusCode)
+	}
+	return string(bs)
+}
+
+//在后台提供服务，返回ServeListeners的结果
+func serveBackground(m *Mux, ls ...Listener) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- m.ServeListeners(ls...) }()
+	return done
+}
+
+func TestListenUnix(t *testing.T) {
+	//unix socket的路径长度有限制，不使用t.TempDir
+	dir, err := ioutil.TempDir("", "mux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mux.sock")
+
+	//上次运行残留的socket文件会被删除
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	conf := ListenConfig{Network: "unix", Addr: path, Mode: 0600}
+	ln, err := conf.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode %v, %v", info.Mode().Perm(), err)
+	}
+	//正在使用的socket不能被抢占
+	if _, err := conf.Listen(); err == nil {
+		t.Fatal("listened on a socket in use")
+	}
+
+	m := newListenMux()
+	done := serveBackground(m, Listener{Listener: ln})
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	if got := get(t, client, "http://unix/"); got != "HTTP/1.1" {
+		t.Fatalf("served %q", got)
+	}
+	m.Shutdown(context.Background())
+	if err := <-done; err != http.ErrServerClosed {
+		t.Fatalf("ServeListeners = %v", err)
+	}
+
+	//不是socket的文件不会被删除
+	file := filepath.Join(dir, "regular")
+	ioutil.WriteFile(file, nil, 0600)
+	if _, err := ListenUnix(file, 0600); err == nil {
+		t.Fatal("listened on a regular file")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatal("regular file was removed")
+	}
+}
+
+func TestListenFD(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	//模拟从父进程继承的文件描述符
+	f, err := ListenerFile(tcp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	//ListenFD会关闭传入的fd，传入一个复制的fd，f关闭时不会关闭其他测试中复用了这个编号的连接
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := ListenConfig{Network: "fd", Addr: strconv.Itoa(fd)}
+	ln, err := conf.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp.Close()
+
+	m := newListenMux()
+	done := serveBackground(m, Listener{Listener: ln})
+	if got := get(t, http.DefaultClient, "http://"+ln.Addr().String()+"/"); got != "HTTP/1.1" {
+		t.Fatalf("served %q", got)
+	}
+	m.Shutdown(context.Background())
+	if err := <-done; err != http.ErrServerClosed {
+		t.Fatalf("ServeListeners = %v", err)
+	}
+
+	for _, addr := range []string{"", "abc", "-1"} {
+		if _, err := (&ListenConfig{Network: "fd", Addr: addr}).Listen(); err == nil {
+			t.Errorf("fd %q was accepted", addr)
+		}
+	}
+	if _, err := (&ListenConfig{Network: "udp", Addr: ":0"}).Listen(); err == nil {
+		t.Error("unknown network was accepted")
+	}
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newListenMux()
+	done := serveBackground(m, Listener{Listener: ln, H2C: true})
+	defer func() {
+		m.Shutdown(context.Background())
+		<-done
+	}()
+	url := "http://" + ln.Addr().String() + "/"
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	h2c := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	if got := get(t, h2c, url); got != "HTTP/2.0" {
+		t.Fatalf("prior knowledge served %q", got)
+	}
+	//带有请求体的Upgrade: h2c不会升级
+	req, _ := http.NewRequest("GET", url, strings.NewReader("body"))
+	req.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+	req.Header.Set("Upgrade", "h2c")
+	req.Header.Set("HTTP2-Settings", "AAMAAABkAARAAAAAAAIAAAAA")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	bs, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(bs) != "HTTP/1.1" {
+		t.Fatalf("upgrade request with a body: %d %q", resp.StatusCode, bs)
+	}
+}
+
+//读取stream上的响应体，直到END_STREAM
+func readH2Body(t *testing.T, br *bufio.Reader, stream uint32) string {
+	var body []byte
+	for {
+		head := make([]byte, 9)
+		if _, err := io.ReadFull(br, head); err != nil {
+			t.Fatal(err)
+		}
+		payload := make([]byte, int(head[0])<<16|int(head[1])<<8|int(head[2]))
+		if _, err := io.ReadFull(br, payload); err != nil {
+			t.Fatal(err)
+		}
+		id := binary.BigEndian.Uint32(head[5:]) & 0x7fffffff
+		switch {
+		case head[3] == 0x7:
+			t.Fatalf("GOAWAY: %x", payload)
+		case head[3] == 0x3 && id == stream:
+			t.Fatalf("RST_STREAM on stream %d: %x", stream, payload)
+		case head[3] == 0x0 && id == stream:
+			body = append(body, payload...)
+			if head[4]&flagEndStream != 0 {
+				return string(body)
+			}
+		case head[3] == frameHeaders && id == stream && head[4]&flagEndStream != 0:
+			return string(body)
+		}
+	}
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newListenMux()
+	m.GET("/query", func(c *route.Context) {
+		c.WriteString(http.StatusOK, c.Request().Proto+" "+c.Query("q")+" "+c.HeaderGet("X-Test"))
+	})
+	done := serveBackground(m, Listener{Listener: ln, H2C: true})
+	defer func() {
+		m.Shutdown(context.Background())
+		<-done
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET /query?q=1 HTTP/1.1\r\nHost: a\r\nX-Test: up\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAARAAAAAAAIAAAAA\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "h2c" {
+		t.Fatalf("upgrade response: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	//连接前言之后，升级请求的响应在stream 1上返回
+	//和curl一样在前言中重复HTTP2-Settings里的参数，net/http拒绝带有重复参数的SETTINGS帧
+	preface := appendFrameHeader([]byte(http2Preface), 6, frameSettings, 0, 0)
+	conn.Write(append(preface, 0, 3, 0, 0, 0, 100))
+	if got := readH2Body(t, br, 1); got != "HTTP/2.0 1 up" {
+		t.Fatalf("stream 1 got %q", got)
+	}
+
+	//之后的请求直接使用HTTP/2
+	req := httptest.NewRequest("GET", "/query?q=3", nil)
+	req.Host = "a"
+	req.Header.Set("X-Test", "h2")
+	conn.Write(appendHeaderFrames(nil, 3, true, encodeRequestHeaders(req)))
+	if got := readH2Body(t, br, 3); got != "HTTP/2.0 3 h2" {
+		t.Fatalf("stream 3 got %q", got)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMux(&route.Config{})
+	entered, release := make(chan struct{}), make(chan struct{})
+	m.GET("/slow", func(c *route.Context) {
+		close(entered)
+		<-release
+		c.WriteString(http.StatusOK, "done")
+	})
+	done := serveBackground(m, Listener{Listener: ln})
+	url := "http://" + ln.Addr().String() + "/slow"
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		bs, _ := ioutil.ReadAll(resp.Body)
+		body <- string(bs)
+	}()
+	<-entered
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- m.Shutdown(context.Background()) }()
+	//处理中的请求结束之前Shutdown不会返回，也不再接受新的连接
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second); err == nil {
+		t.Fatal("new connection accepted during shutdown")
+	}
+	close(release)
+	if got := <-body; got != "done" {
+		t.Fatalf("in-flight request got %q", got)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != http.ErrServerClosed {
+		t.Fatalf("ServeListeners = %v", err)
+	}
+
+	//超时的Shutdown返回ctx的错误
+	ln, _ = net.Listen("tcp", "127.0.0.1:0")
+	entered, release = make(chan struct{}), make(chan struct{})
+	defer close(release)
+	done = serveBackground(m, Listener{Listener: ln})
+	go http.Get("http://" + ln.Addr().String() + "/slow")
+	<-entered
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+}
+
+//RunFast启动的服务同样由Shutdown优雅关闭
+func TestRunFastShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	m := NewMux(&route.Config{})
+	entered, release := make(chan struct{}), make(chan struct{})
+	m.GET("/slow", func(c *route.Context) {
+		close(entered)
+		<-release
+		c.WriteString(http.StatusOK, "done")
+	})
+	done := make(chan error, 1)
+	go func() { done <- m.RunFast(addr) }()
+
+	body := make(chan string, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		for i := 0; i < 100; i++ {
+			if resp, err = http.Get("http://" + addr + "/slow"); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		bs, _ := ioutil.ReadAll(resp.Body)
+		body <- string(bs)
+	}()
+	select {
+	case <-entered:
+	case got := <-body:
+		t.Fatalf("request did not reach the handler: %q", got)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- m.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if got := <-body; got != "done" {
+		t.Fatalf("in-flight request got %q", got)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != fast.ErrServerClosed {
+		t.Fatalf("RunFast = %v", err)
+	}
+}
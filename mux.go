@@ -1,9 +1,15 @@
 package mux
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"mux/fast"
 	"mux/route"
 	"mux/session"
+	_ "mux/session/memory"
+	"net"
 	"net/http"
+	"sync"
 )
 
 
@@ -11,6 +17,8 @@ import (
 func Default() *Mux {
 	m := &Mux{}
 	m.Route = route.DefaultRoute
+	m.sessionManager = m.Route.SessionManager()
+	m.ServerConf = DefaultServerConfig()
 	return m
 }
 
@@ -18,26 +26,94 @@ func Default() *Mux {
 type Mux struct {
 	route.Route
 	sessionManager session.Manager
+	ServerConf ServerConfig
+	//https使用的证书，为空时RunTSL会根据参数与配置创建
+	Certs *CertManager
+	//RunTSL校验客户端证书使用的CA，与配置文件中的ClientCAFile合并
+	//ClientAuth为none时会改为verify_if_given，路由通过auth.ClientCert要求客户端证书
+	ClientCAs *x509.CertPool
+
+	limitConf     LimitConfig
+	limiter       *route.Limiter
+	openConns     int64
+	rejectedConns uint64
+
+	serversMu sync.Mutex
+	servers   map[shutdowner]struct{}
 }
 
-//TODO:限制连接的最大数量
-//TODO:支持fasthttp
 func NewMux(config *route.Config) *Mux {
 	m := &Mux{}
-	m.RouteConf = config
+	if config.OpenSession {
+		m.sessionManager = session.DefaultManager
+	}
+	m.Route = *route.New(config,m.sessionManager)
+	m.ServerConf = DefaultServerConfig()
+	return m
+}
+
+//替换session使用的manager，之后创建的分组都会使用它
+//单个分组可以通过route.SessionHandler使用其他的manager
+func (m *Mux) SetSessionManager(manager session.Manager) {
+	m.sessionManager = manager
+	m.Route.SetSessionManager(manager)
+}
+
+//设置签名与加密cookie使用的密钥，第一个用于签名与加密，其余的只用于校验，之后通过m.Keyring().Rotate轮换
+//已经创建的分组也会使用它
+func (m *Mux) SetCookieKeys(keys ...[]byte) error {
+	k, err := route.NewKeyring(keys...)
+	if err != nil {
+		return err
+	}
+	m.Route.SetKeyring(k)
+	return nil
+}
+
+//使用完整的配置创建mux，配置可以通过LoadConfig读取
+func New(conf *Config) *Mux {
+	route := conf.Route
+	m := NewMux(&route)
+	m.ServerConf = conf.Server
 	return m
 }
 
 func (m *Mux) ServeHTTP(rw http.ResponseWriter,req *http.Request) {
+	if m.limiter != nil {
+		if !m.limiter.Acquire() {
+			m.limiter.Reject(rw)
+			return
+		}
+		defer m.limiter.Release()
+	}
 	m.Route.Run(rw,req)
 }
 
+//fast引擎的入口，handler的代码不需要做任何修改
+func (m *Mux) ServeFast(rw *fast.Response,req *fast.Request) {
+	if m.limiter != nil {
+		if !m.limiter.Acquire() {
+			m.limiter.Reject(rw)
+			return
+		}
+		defer m.limiter.Release()
+	}
+	m.Route.Serve(rw,req)
+}
+
 func (m *Mux) Run(port ...string) error {
 	l := len(port)
 	if l == 0{
 		port = append(port,":80")
 	}
-	return http.ListenAndServe(port[0],m)
+	ln, err := net.Listen("tcp", port[0])
+	if err != nil {
+		return err
+	}
+	srv := m.ServerConf.NewServer(port[0],m)
+	m.trackServer(srv,true)
+	defer m.trackServer(srv,false)
+	return srv.Serve(m.limitListener(ln))
 }
 
 func (m *Mux) RunTSL(certFile, keyFile string,port ...string) error {
@@ -45,5 +121,60 @@ func (m *Mux) RunTSL(certFile, keyFile string,port ...string) error {
 	if l == 0{
 		port = append(port,":443")
 	}
-	return http.ListenAndServeTLS(port[0],certFile, keyFile ,m)
+	tlsConf, closer, err := m.tlsConfig(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	ln, err := net.Listen("tcp", port[0])
+	if err != nil {
+		return err
+	}
+	srv := m.ServerConf.NewServer(port[0],m)
+	srv.TLSConfig = tlsConf
+	m.trackServer(srv,true)
+	defer m.trackServer(srv,false)
+	return srv.ServeTLS(m.limitListener(ln),"","")
+}
+
+//根据配置生成https使用的tls.Config，返回的函数用于停止检查证书文件
+func (m *Mux) tlsConfig(certFile, keyFile string) (*tls.Config, func(), error) {
+	tlsConf, err := m.ServerConf.TLS.build(m.ClientCAs)
+	if err != nil {
+		return nil, nil, err
+	}
+	certs := m.Certs
+	closer := func() {}
+	if certs == nil {
+		//证书文件更新后会自动重新加载，不需要重启服务
+		certs, err = m.ServerConf.TLS.NewCertManager(certFile, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		closer = certs.Close
+	}
+	tlsConf.GetCertificate = certs.GetCertificate
+	return tlsConf, closer, nil
+}
+
+//使用fast引擎运行，适合对性能要求很高的服务，只支持HTTP/1.x
+func (m *Mux) RunFast(port ...string) error {
+	l := len(port)
+	if l == 0{
+		port = append(port,":80")
+	}
+	ln, err := net.Listen("tcp", port[0])
+	if err != nil {
+		return err
+	}
+	srv := &fast.Server{
+		Handler:        m,
+		ReadTimeout:    m.ServerConf.ReadTimeout.Duration(),
+		WriteTimeout:   m.ServerConf.WriteTimeout.Duration(),
+		IdleTimeout:    m.ServerConf.IdleTimeout.Duration(),
+		MaxHeaderBytes: m.ServerConf.MaxHeaderBytes,
+	}
+	m.trackServer(srv,true)
+	defer m.trackServer(srv,false)
+	return srv.Serve(m.limitListener(ln))
 }
@@ -1,4 +1,118 @@
 package mux
 
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"mux/fast"
+	"mux/route"
+	"net"
+	"net/http"
+	"testing"
+)
 
+func newBenchMux() *Mux {
+	m := NewMux(&route.Config{PathUnescape: true, MaxMultipartMemory: 4 << 20})
+	m.GET("/user/:id", func(c *route.Context) {
+		c.WriteString(http.StatusOK, c.Param("id")+c.Query("name")+c.HeaderGet("User-Agent"))
+	})
+	return m
+}
 
+// 在listener上启动引擎，返回一个keep-alive的连接
+func startServer(b *testing.B, serve func(net.Listener) error) (net.Conn, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go serve(ln)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		ln.Close()
+	}
+}
+
+func benchmarkServer(b *testing.B, serve func(net.Listener) error) {
+	conn, stop := startServer(b, serve)
+	defer stop()
+	req := []byte("GET /user/42?name=mux HTTP/1.1\r\nHost: localhost\r\nUser-Agent: bench\r\n\r\n")
+	br := bufio.NewReader(conn)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(req); err != nil {
+			b.Fatal(err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			b.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+	}
+}
+
+func BenchmarkNetHTTP(b *testing.B) {
+	m := newBenchMux()
+	srv := &http.Server{Handler: m}
+	benchmarkServer(b, srv.Serve)
+}
+
+func BenchmarkFast(b *testing.B) {
+	m := newBenchMux()
+	srv := &fast.Server{Handler: m}
+	benchmarkServer(b, srv.Serve)
+}
+
+// 不经过网络，只比较两个引擎适配到Context的开销
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	m := newBenchMux()
+	req, _ := http.NewRequest(http.MethodGet, "/user/42?name=mux", nil)
+	req.Header.Set("User-Agent", "bench")
+	w := &discardWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.ServeHTTP(w, req)
+	}
+}
+
+// 代码中设置的CA与配置文件中的CA合并，没有配置校验方式时校验客户端发送的证书
+func TestTLSClientCAs(t *testing.T) {
+	cert, err := SelfSignedCertificate("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	m := NewMux(&route.Config{})
+	m.ServerConf.TLS.Dev = true
+	m.ClientCAs = pool
+	conf, closer, err := m.tlsConfig("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+	if conf.ClientAuth != tls.VerifyClientCertIfGiven || conf.ClientCAs == nil || !conf.ClientCAs.Equal(pool) {
+		t.Fatalf("client auth = %v, pool set = %v", conf.ClientAuth, conf.ClientCAs != nil)
+	}
+}
@@ -0,0 +1,63 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//签发方的配置，来自{issuer}/.well-known/openid-configuration
+type Discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI               string   `json:"jwks_uri"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint,omitempty"`
+	ScopesSupported       []string `json:"scopes_supported,omitempty"`
+	//ID token允许的签名算法
+	IDTokenSigningAlgs []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	//为空时认为支持S256
+	CodeChallengeMethods []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+//读取签发方的配置，文档中的issuer必须和传入的issuer相同
+//client为nil时使用http.DefaultClient
+func Discover(issuer string, client *http.Client) (*Discovery, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: fetching %s: %s", url, resp.Status)
+	}
+	d := &Discovery{}
+	if err := json.NewDecoder(resp.Body).Decode(d); err != nil {
+		return nil, fmt.Errorf("oidc: decoding discovery document: %v", err)
+	}
+	if d.Issuer != issuer {
+		return nil, fmt.Errorf("oidc: issuer %q does not match discovery document %q", issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: incomplete discovery document from %s", url)
+	}
+	if len(d.CodeChallengeMethods) > 0 && !contains(d.CodeChallengeMethods, "S256") {
+		return nil, fmt.Errorf("oidc: %s does not support PKCE S256", issuer)
+	}
+	return d, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,478 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mux/jwt"
+	"mux/route"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//session中保存的key
+const (
+	//登录过程中的state、nonce、code_verifier与登录后的跳转地址，回调时删除
+	LoginKey = "oidc.login"
+	//校验通过的ID token
+	IDTokenKey = "oidc.id_token"
+	//ID token的claims，JSON格式，任意codec都可以保存
+	ClaimsKey = "oidc.claims"
+)
+
+var (
+	//回调中的state和session中的不一致，或者session中没有正在进行的登录
+	ErrState = errors.New("oidc: invalid state")
+	ErrNonce = errors.New("oidc: invalid nonce")
+	//token响应中没有id_token
+	ErrNoIDToken = errors.New("oidc: no id_token in token response")
+	//Mount之前没有配置RedirectURL
+	ErrNoRedirectURL = errors.New("oidc: redirect url is not configured")
+)
+
+//签发方在回调或者token接口中返回的错误
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "oidc: " + e.Code
+	}
+	return "oidc: " + e.Code + ": " + e.Description
+}
+
+//token接口的响应
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+}
+
+type Config struct {
+	//签发方，例如https://accounts.example.com，用于discovery与校验iss
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	//回调地址，需要在签发方登记，为空时由请求的host与Mount的路径组成
+	RedirectURL string
+	//default:openid profile email
+	Scopes []string
+	//附加在授权请求上的参数，例如prompt、login_hint
+	AuthParams url.Values
+	//default:http.DefaultClient
+	Client *http.Client
+	//Mount时注册的路径，default:/login、/callback、/logout
+	LoginPath    string
+	CallbackPath string
+	LogoutPath   string
+	//登录后没有指定跳转地址时跳转到这里，default:/
+	AfterLogin string
+	//退出后跳转的地址，签发方支持RP-initiated logout时作为post_logout_redirect_uri，需要是完整的URL，default:/
+	AfterLogout string
+	//允许的时钟误差
+	Leeway time.Duration
+	//把claims转换为principal，返回错误时拒绝登录，default:DefaultPrincipal
+	Map func(claims jwt.Claims) (*route.Principal, error)
+	//default:time.Now
+	Now func() time.Time
+	//登录失败时调用，default:返回401
+	ErrorHandler func(c *route.Context, err error)
+}
+
+//OpenID Connect的relying party，使用授权码与PKCE登录，登录状态保存在session中
+//
+//	rp := oidc.New(oidc.Config{Issuer: "https://sso.example.com", ClientID: "admin", ClientSecret: secret})
+//	auth := m.Group("/auth")
+//	rp.Mount(auth)
+//	admin := m.Group("/admin", rp.Handler(), rp.RequireLogin()).Authorize(route.RequireRoles("admin"))
+type RelyingParty struct {
+	conf Config
+	//Mount之后的完整路径
+	loginPath, callbackPath string
+	mounted                 bool
+
+	mu        sync.Mutex
+	discovery *Discovery
+	verifier  *jwt.Verifier
+}
+
+func New(conf Config) *RelyingParty {
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{"openid", "profile", "email"}
+	} else if !contains(conf.Scopes, "openid") {
+		conf.Scopes = append([]string{"openid"}, conf.Scopes...)
+	}
+	if conf.Client == nil {
+		conf.Client = http.DefaultClient
+	}
+	if conf.LoginPath == "" {
+		conf.LoginPath = "/login"
+	}
+	if conf.CallbackPath == "" {
+		conf.CallbackPath = "/callback"
+	}
+	if conf.LogoutPath == "" {
+		conf.LogoutPath = "/logout"
+	}
+	if conf.AfterLogin == "" {
+		conf.AfterLogin = "/"
+	}
+	if conf.AfterLogout == "" {
+		conf.AfterLogout = "/"
+	}
+	if conf.Map == nil {
+		conf.Map = DefaultPrincipal
+	}
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
+	if conf.ErrorHandler == nil {
+		conf.ErrorHandler = func(c *route.Context, err error) {
+			http.Error(c.Writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+	}
+	return &RelyingParty{conf: conf, loginPath: conf.LoginPath, callbackPath: conf.CallbackPath}
+}
+
+//在分组上注册登录、回调与退出，退出只接受POST，应当和CSRF中间件一起使用
+func (rp *RelyingParty) Mount(g route.Router) {
+	if r, ok := g.(interface{ BasePath() string }); ok {
+		base := strings.TrimSuffix(r.BasePath(), "/")
+		rp.loginPath = base + rp.conf.LoginPath
+		rp.callbackPath = base + rp.conf.CallbackPath
+	}
+	rp.mounted = true
+	g.GET(rp.conf.LoginPath, rp.Login)
+	g.GET(rp.conf.CallbackPath, rp.Callback)
+	g.POST(rp.conf.LogoutPath, rp.Logout)
+}
+
+//第一次使用时读取discovery，失败时下次请求重试
+func (rp *RelyingParty) provider() (*Discovery, *jwt.Verifier, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.discovery == nil {
+		d, err := Discover(rp.conf.Issuer, rp.conf.Client)
+		if err != nil {
+			return nil, nil, err
+		}
+		rp.discovery = d
+		rp.verifier = jwt.New(jwt.Config{
+			Keys:       jwt.NewJWKSURL(d.JWKSURI, rp.conf.Client),
+			Algorithms: d.IDTokenSigningAlgs,
+			Issuer:     d.Issuer,
+			Audience:   rp.conf.ClientID,
+			Leeway:     rp.conf.Leeway,
+			Now:        rp.conf.Now,
+		})
+	}
+	return rp.discovery, rp.verifier, nil
+}
+
+func (rp *RelyingParty) redirectURL(c *route.Context) string {
+	if rp.conf.RedirectURL != "" {
+		return rp.conf.RedirectURL
+	}
+	scheme := "http"
+	if c.Request().TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request().Host + rp.callbackPath
+}
+
+//跳转到签发方登录，查询参数return_to是登录后跳转的本站路径
+func (rp *RelyingParty) Login(c *route.Context) {
+	if err := rp.login(c); err != nil {
+		rp.conf.ErrorHandler(c, err)
+		c.Abort()
+	}
+}
+
+func (rp *RelyingParty) login(c *route.Context) error {
+	if rp.conf.RedirectURL == "" && !rp.mounted {
+		return ErrNoRedirectURL
+	}
+	d, _, err := rp.provider()
+	if err != nil {
+		return err
+	}
+	state, err := random()
+	if err != nil {
+		return err
+	}
+	nonce, err := random()
+	if err != nil {
+		return err
+	}
+	verifier, err := random()
+	if err != nil {
+		return err
+	}
+	sess, err := c.Session()
+	if err != nil {
+		return err
+	}
+	//同一个session同时只保留最近一次登录
+	sess.Set(LoginKey, map[string]string{
+		"state":     state,
+		"nonce":     nonce,
+		"verifier":  verifier,
+		"return_to": safeReturn(c.Query("return_to")),
+	})
+	sum := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	for k, v := range rp.conf.AuthParams {
+		q[k] = v
+	}
+	q.Set("response_type", "code")
+	q.Set("client_id", rp.conf.ClientID)
+	q.Set("redirect_uri", rp.redirectURL(c))
+	q.Set("scope", strings.Join(rp.conf.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	c.Writer.Header().Set("Cache-Control", "no-store")
+	http.Redirect(c.Writer, c.Request(), d.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+	return nil
+}
+
+//签发方的回调，校验state、换取token并校验ID token，成功后更换session id并跳转
+func (rp *RelyingParty) Callback(c *route.Context) {
+	to, err := rp.callback(c)
+	if err != nil {
+		rp.conf.ErrorHandler(c, err)
+		c.Abort()
+		return
+	}
+	http.Redirect(c.Writer, c.Request(), to, http.StatusFound)
+}
+
+func (rp *RelyingParty) callback(c *route.Context) (string, error) {
+	sess, err := c.Session()
+	if err != nil {
+		return "", err
+	}
+	login, _ := sess.Get(LoginKey).(map[string]string)
+	//state只能使用一次
+	sess.Del(LoginKey)
+	if e := c.Query("error"); e != "" {
+		return "", &Error{Code: e, Description: c.Query("error_description")}
+	}
+	state := c.Query("state")
+	if login == nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(login["state"])) != 1 {
+		return "", ErrState
+	}
+	code := c.Query("code")
+	if code == "" {
+		return "", &Error{Code: "invalid_request", Description: "missing code"}
+	}
+	d, verifier, err := rp.provider()
+	if err != nil {
+		return "", err
+	}
+	token, err := rp.exchange(d, code, login["verifier"], rp.redirectURL(c))
+	if err != nil {
+		return "", err
+	}
+	claims, err := verifier.Parse(token.IDToken)
+	if err != nil {
+		return "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.String("nonce")), []byte(login["nonce"])) != 1 {
+		return "", ErrNonce
+	}
+	//有多个aud时azp必须是自己
+	if aud := claims.Audience(); len(aud) > 1 && claims.String("azp") != rp.conf.ClientID {
+		return "", jwt.ErrAudience
+	}
+	p, err := rp.conf.Map(claims)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	//登录成功后更换session id，防止session固定攻击
+	if _, err := c.RegenerateSession(); err != nil {
+		return "", err
+	}
+	if sess, err = c.Session(); err != nil {
+		return "", err
+	}
+	sess.Set(IDTokenKey, token.IDToken)
+	sess.Set(ClaimsKey, string(data))
+	if err := c.SetSessionUser(p.ID); err != nil {
+		return "", err
+	}
+	c.SetPrincipal(p)
+	if login["return_to"] != "" {
+		return login["return_to"], nil
+	}
+	return rp.conf.AfterLogin, nil
+}
+
+//用授权码换取token，有ClientSecret时使用client_secret_basic
+func (rp *RelyingParty) exchange(d *Discovery, code, verifier, redirect string) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirect},
+		"code_verifier": {verifier},
+	}
+	if rp.conf.ClientSecret == "" {
+		form.Set("client_id", rp.conf.ClientID)
+	}
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if rp.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(rp.conf.ClientID), url.QueryEscape(rp.conf.ClientSecret))
+	}
+	resp, err := rp.conf.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{}
+		if json.Unmarshal(body, e) != nil || e.Code == "" {
+			return nil, fmt.Errorf("oidc: token endpoint: %s", resp.Status)
+		}
+		return nil, e
+	}
+	token := &Token{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return nil, fmt.Errorf("oidc: decoding token response: %v", err)
+	}
+	if token.IDToken == "" {
+		return nil, ErrNoIDToken
+	}
+	return token, nil
+}
+
+//删除本地的session，签发方支持时再跳转到签发方退出
+func (rp *RelyingParty) Logout(c *route.Context) {
+	idToken := ""
+	if sess, err := c.Session(); err == nil {
+		idToken, _ = sess.Get(IDTokenKey).(string)
+	}
+	if err := c.DestroySession(); err != nil {
+		rp.conf.ErrorHandler(c, err)
+		c.Abort()
+		return
+	}
+	to := rp.conf.AfterLogout
+	if d, _, err := rp.provider(); err == nil && d.EndSessionEndpoint != "" && idToken != "" {
+		q := url.Values{"id_token_hint": {idToken}, "client_id": {rp.conf.ClientID}}
+		if u, err := url.Parse(rp.conf.AfterLogout); err == nil && u.IsAbs() {
+			q.Set("post_logout_redirect_uri", rp.conf.AfterLogout)
+		}
+		to = d.EndSessionEndpoint + "?" + q.Encode()
+	}
+	http.Redirect(c.Writer, c.Request(), to, http.StatusSeeOther)
+}
+
+//已经登录时把session中的身份写入Context.Principal，没有登录时什么都不做
+func (rp *RelyingParty) Handler() route.HandlerFunc {
+	return func(c *route.Context) {
+		if id := Identity(c); id != nil {
+			if p, err := rp.conf.Map(id); err == nil {
+				c.SetPrincipal(p)
+			}
+		}
+		if !c.IsAborted() {
+			c.Next()
+		}
+	}
+}
+
+//要求已经登录，GET与HEAD请求跳转到登录页面，其他请求返回401
+//需要在Handler之后使用
+func (rp *RelyingParty) RequireLogin() route.HandlerFunc {
+	return func(c *route.Context) {
+		if c.Principal() != nil {
+			c.Next()
+			return
+		}
+		c.Abort()
+		if c.Method() != http.MethodGet && c.Method() != http.MethodHead {
+			route.Deny(c, http.StatusUnauthorized)
+			return
+		}
+		to := rp.loginPath + "?" + url.Values{"return_to": {c.Request().URL.RequestURI()}}.Encode()
+		http.Redirect(c.Writer, c.Request(), to, http.StatusFound)
+	}
+}
+
+//读取session中登录用户的claims，没有登录时返回nil
+func Identity(c *route.Context) jwt.Claims {
+	sess, err := c.Session()
+	if err != nil {
+		return nil
+	}
+	data, _ := sess.Get(ClaimsKey).(string)
+	if data == "" {
+		return nil
+	}
+	claims := jwt.Claims{}
+	if json.Unmarshal([]byte(data), &claims) != nil {
+		return nil
+	}
+	return claims
+}
+
+//principal的ID是sub，Method为oidc，角色来自roles与groups，权限来自permissions
+func DefaultPrincipal(claims jwt.Claims) (*route.Principal, error) {
+	if claims.Subject() == "" {
+		return nil, fmt.Errorf("oidc: id token has no subject")
+	}
+	return &route.Principal{
+		ID:          claims.Subject(),
+		Method:      "oidc",
+		Roles:       append(claims.Strings("roles"), claims.Strings("groups")...),
+		Permissions: claims.Strings("permissions"),
+		Attributes:  map[string]interface{}(claims),
+	}, nil
+}
+
+//只允许跳转到本站的路径，防止开放重定向
+func safeReturn(to string) string {
+	if !strings.HasPrefix(to, "/") || strings.HasPrefix(to, "//") || strings.HasPrefix(to, "/\\") {
+		return ""
+	}
+	return to
+}
+
+func random() (string, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,237 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"mux/jwt"
+	"mux/route"
+	"mux/session"
+	_ "mux/session/memory"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//本地的签发方，支持discovery、授权码、PKCE与RP-initiated logout
+type fakeIdP struct {
+	*httptest.Server
+	key jwt.Key
+	//签发ID token时使用的nonce，为空时使用授权请求中的nonce
+	nonce string
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: jwt.Key{ID: "k1", Key: priv}, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&Discovery{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+			EndSessionEndpoint:    idp.URL + "/logout",
+			IDTokenSigningAlgs:    []string{jwt.RS256},
+			CodeChallengeMethods:  []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := jwt.MarshalJWKS(idp.key.Public())
+		w.Write(data)
+	})
+	//不需要输入密码，直接以alice的身份同意授权
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("response_type") != "code" || q.Get("client_id") != "admin-ui" || q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		code := "code-" + q.Get("state")[:8]
+		idp.mu.Lock()
+		idp.codes[code] = q
+		idp.mu.Unlock()
+		to := q.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, to, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		idp.mu.Lock()
+		auth, ok := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		idp.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		switch {
+		case id != "admin-ui" || secret != "s3cret":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		case !ok || auth.Get("redirect_uri") != r.PostForm.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != auth.Get("code_challenge"):
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		nonce := auth.Get("nonce")
+		if idp.nonce != "" {
+			nonce = idp.nonce
+		}
+		signer := &jwt.Signer{Key: idp.key, Issuer: idp.URL, Audience: []string{"admin-ui"}}
+		token, _ := signer.Issue("alice", jwt.Claims{"nonce": nonce, "groups": []string{"admin"}})
+		json.NewEncoder(w).Encode(&Token{AccessToken: "at", TokenType: "Bearer", IDToken: token})
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("signed out"))
+	})
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+func newApp(t *testing.T, idp *fakeIdP) (*httptest.Server, *http.Client) {
+	manager, err := session.NewManage(&session.ManagerConf{
+		ProviderName:    "memory",
+		CookieName:      "sid",
+		EnableSetCookie: true,
+		Path:            "/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := New(Config{Issuer: idp.URL, ClientID: "admin-ui", ClientSecret: "s3cret"})
+	r := route.New(&route.Config{}, manager)
+	rp.Mount(r.Group("/auth"))
+	admin := r.Group("/admin", rp.Handler(), rp.RequireLogin()).Authorize(route.RequireRoles("admin"))
+	admin.GET("/", func(c *route.Context) {
+		p := c.Principal()
+		c.WriteString(http.StatusOK, p.Method+":"+p.ID)
+	})
+	app := httptest.NewServer(http.HandlerFunc(r.Run))
+	jar, _ := cookiejar.New(nil)
+	return app, &http.Client{Jar: jar}
+}
+
+func get(t *testing.T, client *http.Client, url string) (*http.Response, string) {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestLoginFlow(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.Close()
+	app, client := newApp(t, idp)
+	defer app.Close()
+
+	//没有登录时跳转到签发方，登录后回到原来的页面
+	resp, body := get(t, client, app.URL+"/admin")
+	if resp.StatusCode != http.StatusOK || body != "oidc:alice" {
+		t.Fatalf("login: %d %q", resp.StatusCode, body)
+	}
+	if resp.Request.URL.Path != "/admin" {
+		t.Fatalf("returned to %s", resp.Request.URL)
+	}
+	//已经登录，不再跳转
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	if resp, body := get(t, client, app.URL+"/admin"); resp.StatusCode != http.StatusOK || body != "oidc:alice" {
+		t.Fatalf("logged in: %d %q", resp.StatusCode, body)
+	}
+
+	resp, err := client.PostForm(app.URL+"/auth/logout", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, _ := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusSeeOther || loc.Path != "/logout" || loc.Query().Get("id_token_hint") == "" {
+		t.Fatalf("logout: %d %s", resp.StatusCode, loc)
+	}
+	if resp, _ := get(t, client, app.URL+"/admin"); resp.StatusCode != http.StatusFound ||
+		!strings.HasPrefix(resp.Header.Get("Location"), "/auth/login?return_to=") {
+		t.Fatalf("after logout: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestCallbackRejects(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.Close()
+	app, client := newApp(t, idp)
+	defer app.Close()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	//没有发起登录的回调
+	if resp, _ := get(t, client, app.URL+"/auth/callback?code=x&state=y"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsolicited callback: %d", resp.StatusCode)
+	}
+	if resp, _ := get(t, client, app.URL+"/auth/callback?error=access_denied"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("error callback: %d", resp.StatusCode)
+	}
+
+	login := func() *url.URL {
+		resp, _ := get(t, client, app.URL+"/auth/login?return_to=//evil.example.com")
+		authorize, _ := url.Parse(resp.Header.Get("Location"))
+		q := authorize.Query()
+		if q.Get("code_challenge") == "" || q.Get("nonce") == "" || q.Get("redirect_uri") != app.URL+"/auth/callback" {
+			t.Fatalf("authorize request: %s", authorize)
+		}
+		resp, _ = get(t, client, authorize.String())
+		callback, _ := url.Parse(resp.Header.Get("Location"))
+		return callback
+	}
+
+	//state不一致
+	callback := login()
+	q := callback.Query()
+	q.Set("state", "forged")
+	callback.RawQuery = q.Encode()
+	if resp, _ := get(t, client, callback.String()); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("forged state: %d", resp.StatusCode)
+	}
+
+	//ID token中的nonce不是这次登录的
+	idp.nonce = "other"
+	if resp, _ := get(t, client, login().String()); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong nonce: %d", resp.StatusCode)
+	}
+
+	//return_to只能是本站的路径
+	idp.nonce = ""
+	callback = login()
+	if resp, _ := get(t, client, callback.String()); resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/" {
+		t.Fatalf("open redirect: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	//授权码与state只能使用一次
+	if resp, _ := get(t, client, callback.String()); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("replayed callback: %d", resp.StatusCode)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.Close()
+	d, err := Discover(idp.URL, nil)
+	if err != nil || d.TokenEndpoint != idp.URL+"/token" {
+		t.Fatalf("discover: %+v, %v", d, err)
+	}
+	//文档中的issuer必须和配置的一致
+	if _, err := Discover(idp.URL+"/", nil); err == nil {
+		t.Fatal("issuer mismatch accepted")
+	}
+}
@@ -0,0 +1,111 @@
+package route
+
+import (
+	"net/http"
+	"strings"
+)
+
+//授权策略，路由与分组通过Route.Authorize声明，Routes会列出每个路由的策略
+//
+//	owner := route.PolicyFunc("owner of :id", func(c *route.Context) bool {
+//		return c.Principal().ID == c.Param("id")
+//	})
+//	users := r.Group("/users").Authorize(route.AnyOf(route.RequireRoles("admin"), owner))
+//	users.PUT("/:id", update)
+type Policy struct {
+	//Routes中显示的描述
+	Name  string
+	allow func(c *Context) bool
+}
+
+//principal存在时才会调用，返回false时拒绝请求
+func (p *Policy) Allow(c *Context) bool {
+	return c.Principal() != nil && p.allow(c)
+}
+
+//作为中间件使用，没有通过认证返回401，没有权限返回403
+//直接作为中间件使用的策略不会出现在Routes中，应当优先使用Route.Authorize
+func (p *Policy) Handler() HandlerFunc {
+	return func(c *Context) {
+		if c.Principal() == nil {
+			Deny(c, http.StatusUnauthorized)
+			c.Abort()
+			return
+		}
+		if !p.allow(c) {
+			Deny(c, http.StatusForbidden)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+//拒绝请求时调用，code为401或者403，可以替换为返回json等
+var Deny = func(c *Context, code int) {
+	http.Error(c.Writer, http.StatusText(code), code)
+}
+
+//自定义的策略，可以读取Principal与Param
+func PolicyFunc(name string, fn func(c *Context) bool) *Policy {
+	return &Policy{Name: name, allow: fn}
+}
+
+//拥有其中任意一个角色
+func RequireRoles(roles ...string) *Policy {
+	return PolicyFunc("role:"+strings.Join(roles, "|"), func(c *Context) bool {
+		p := c.Principal()
+		for _, r := range roles {
+			if p.HasRole(r) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+//拥有所有的权限
+func RequirePermissions(perms ...string) *Policy {
+	return PolicyFunc("perm:"+strings.Join(perms, "&"), func(c *Context) bool {
+		p := c.Principal()
+		for _, perm := range perms {
+			if !p.HasPermission(perm) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+//只要求通过认证
+func Authenticated() *Policy {
+	return PolicyFunc("authenticated", func(c *Context) bool { return true })
+}
+
+//满足任意一个策略
+func AnyOf(policies ...*Policy) *Policy {
+	return PolicyFunc("any("+policyNames(policies, " | ")+")", func(c *Context) bool {
+		for _, p := range policies {
+			if p.allow(c) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+//满足所有的策略
+func AllOf(policies ...*Policy) *Policy {
+	return PolicyFunc("all("+policyNames(policies, " & ")+")", func(c *Context) bool {
+		for _, p := range policies {
+			if !p.allow(c) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func policyNames(policies []*Policy, sep string) string {
+	return strings.Join(policyList(policies), sep)
+}
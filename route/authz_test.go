@@ -0,0 +1,53 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	r := New(&Config{}, nil)
+	//测试中通过头部模拟认证
+	authn := func(c *Context) {
+		if id := c.HeaderGet("X-User"); id != "" {
+			c.SetPrincipal(&Principal{ID: id, Roles: []string{c.HeaderGet("X-Role")}})
+		}
+	}
+	owner := PolicyFunc("owner of :id", func(c *Context) bool {
+		return c.Principal().ID == c.Param("id")
+	})
+	users := r.Group("/users", authn).Authorize(AnyOf(RequireRoles("admin"), owner))
+	ok := func(c *Context) { c.WriteString(http.StatusOK, "ok") }
+	users.GET("/:id", ok)
+	r.GET("/public", ok)
+
+	for _, c := range []struct {
+		user, role, path string
+		code             int
+	}{
+		{"", "", "/users/1", http.StatusUnauthorized},
+		{"2", "", "/users/1", http.StatusForbidden},
+		{"1", "", "/users/1", http.StatusOK},
+		{"2", "admin", "/users/1", http.StatusOK},
+		{"", "", "/public", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", c.path, nil)
+		req.Header.Set("X-User", c.user)
+		req.Header.Set("X-Role", c.role)
+		w := httptest.NewRecorder()
+		r.Run(w, req)
+		if w.Code != c.code {
+			t.Errorf("user %q role %q %s: status = %d, want %d", c.user, c.role, c.path, w.Code, c.code)
+		}
+	}
+
+	want := []RouteInfo{
+		{Method: "GET", Path: "/public", Handlers: 1, Policies: []string{}},
+		{Method: "GET", Path: "/users/:id", Handlers: 3, Policies: []string{"any(role:admin | owner of :id)"}},
+	}
+	if got := r.Routes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Routes() = %+v, want %+v", got, want)
+	}
+}
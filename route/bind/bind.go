@@ -1,7 +1,6 @@
 package bind
 
 import (
-	"encoding/json"
 	"net/http"
 )
 
@@ -28,21 +27,4 @@ var (
 	JSON = new(json2)
 	PostForm = new(postForm)
 	Query = new(query)
-	Param = new(param2)
 )
-
-
-
-
-
-type param2 struct {}
-
-func (*param2) Name() string {
-	return "Param"
-}
-
-func (*param2) Parse(c *ctx.Context,obj interface{}) error {
-	bytes, err := json.Marshal(c.params)
-	if err != nil{return err}
-	return json.Unmarshal(bytes,obj)
-}
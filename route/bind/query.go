@@ -12,15 +12,12 @@ func (*query) Name() string {
 }
 
 func (*query) Parse(req *http.Request,obj interface{}) error{
-	//TODO:bind parse query 性能优化
-	if c.querys == nil{
-		c.querys = c.Request.URL.Query()
-	}
-	res := make(map[string]string,len(c.querys))
-	for k,v := range c.querys{
+	querys := req.URL.Query()
+	res := make(map[string]string,len(querys))
+	for k,v := range querys{
 		res[k] = v[0]
 	}
 	bytes, err := json.Marshal(res)
 	if err != nil{return err}
 	return json.Unmarshal(bytes, obj)
-}
\ No newline at end of file
+}
@@ -1,21 +1,28 @@
 package route
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"github.com/tidwall/gjson"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"mux/route/bind"
+	"mux/session"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 )
 
 type Context struct {
-	Request *http.Request
 	Writer http.ResponseWriter
+	//读取请求信息应当优先通过req，fast引擎可以避免构造net/http的对象
+	req Request
+	//Request第一次调用时才从req构造
+	request *http.Request
 
 	route *Route
 
@@ -30,11 +37,30 @@ type Context struct {
 	//解析json数据
 	jsonBytes []byte
 	jsonResult *gjson.Result
+	//ReadBody超过限制或者读取失败时请求体已经被读取了一部分，之后的读取都返回这个错误
+	bodyErr error
+
+	//session在第一次调用Session时才会读取
+	sessionManager session.Manager
+	sessionConfs []*session.ManagerRunConfig
+	session session.Sessioner
+	//CSRF中间件，CSRFToken通过它生成token
+	csrf *CSRF
+	//这次请求新写入cookie的token
+	csrfToken []byte
+	//csrfToken绑定的sid
+	csrfSid string
+	//认证中间件写入的调用方
+	principal *Principal
 }
 
 //用于重置context，用户一般用不到这个方法
 func (c *Context) Reset(w http.ResponseWriter,r *http.Request,route *Route,chain []HandlerFunc,ps Params) {
-	c.Request = r
+	c.reset(w,NewRequest(r),route,chain,ps)
+}
+
+func (c *Context) reset(w http.ResponseWriter,req Request,route *Route,chain []HandlerFunc,ps Params) {
+	c.req = req
 	c.Writer = w
 	c.route = route
 	c.handlers = chain
@@ -43,16 +69,40 @@ func (c *Context) Reset(w http.ResponseWriter,r *http.Request,route *Route,chain
 	c.index = -1
 }
 
+//兼容net/http的请求，第一次调用时才构造，只读取path、header等信息时应当使用Context上的其他方法
+//使用fast引擎时它由引擎复用的内存构造，不要在handler返回后继续持有
+//它以前是Context的字段，旧代码中的c.Request需要改为c.Request()，见README
+func (c *Context) Request() *http.Request {
+	if c.request == nil {
+		c.request = c.req.HTTPRequest()
+		if c.jsonBytes != nil || c.bodyErr != nil {
+			//ReadBody已经读取了请求体，表单解析读取缓存的内容
+			c.request.Body = c.cachedBody()
+		}
+	}
+	return c.request
+}
+
 //用于释放context，用户一般用不到这个方法
 func (c *Context) Release() {
-	c.Request = nil
 	c.Writer = nil
+	c.req = nil
+	c.request = nil
+	c.route = nil
 	c.handlers = nil
 	c.keys = nil
 	c.params = nil
 	c.querys = nil
 	c.jsonBytes = nil
 	c.jsonResult = nil
+	c.bodyErr = nil
+	c.sessionManager = nil
+	c.sessionConfs = nil
+	c.session = nil
+	c.csrf = nil
+	c.csrfToken = nil
+	c.csrfSid = ""
+	c.principal = nil
 }
 
 func (c *Context) Next()  {
@@ -63,6 +113,15 @@ func (c *Context) Next()  {
 	}
 }
 
+//终止调用链，之后的handler都不会再执行
+func (c *Context) Abort() {
+	c.index = HandlerLimit
+}
+
+func (c *Context) IsAborted() bool {
+	return c.index >= HandlerLimit
+}
+
 //传递上下文信息
 func (c *Context) Set(key string,val interface{})  {
 	if c.keys == nil{
@@ -80,12 +139,12 @@ func (c *Context) Get(key string) (interface{},bool) {
 }
 
 func (c *Context) Method() string {
-	return c.Request.Method
+	return c.req.Method()
 }
 
 func (c *Context) URI() string {
 	querys := c.querys
-	path := c.Request.URL.Path + "?"
+	path := c.req.Path() + "?"
 	for key,val := range querys {
 		for i := range val{
 			path += key+"="+val[i] + "&"
@@ -95,43 +154,43 @@ func (c *Context) URI() string {
 }
 
 func (c *Context) URIEscaped() string {
-	return c.Request.RequestURI
+	return c.req.RequestURI()
 }
 
 func (c *Context)Path() string {
-	return c.Request.URL.Path
+	return c.req.Path()
 }
 
 func (c *Context)PathEscaped() string {
-	p := c.Request.URL.RawPath
-	if  p != ""{
-		return p
-	}
-	return c.Request.URL.EscapedPath()
+	return c.req.RawPath()
 }
 
 func (c *Context) Proto() float64 {
-	arr := strings.Split(c.Request.Proto, "/")
+	arr := strings.Split(c.req.Proto(), "/")
 	v,_ := strconv.ParseFloat(arr[len(arr)-1],10)
 	return v
 }
 
 func (c *Context) Headers() http.Header {
-	return c.Request.Header
+	return c.Request().Header
 }
 
 func (c *Context) HeaderArr(key string) []string {
-	return c.Headers()[key]
+	return c.req.HeaderValues(key)
 }
 
 func (c *Context) HeaderGet(key string) string {
-	return c.Headers().Get(key)
+	return c.req.Header(key)
+}
+
+func (c *Context) RemoteAddr() string {
+	return c.req.RemoteAddr()
 }
 
 
-//TODO:cookie、Sessioner、JWT等机制
+//TODO:cookie、JWT等机制
 func (c *Context) CookieGet(key string) (*http.Cookie, error) {
-	return c.Request.Cookie(key)
+	return c.Request().Cookie(key)
 }
 
 
@@ -177,7 +236,7 @@ func (c *Context) QueryArray(key string) []string {
 
 func (c *Context) QueryArrayGet(key string) (arr []string,ok bool) {
 	if c.querys == nil{
-		c.querys = c.Request.URL.Query()
+		c.querys, _ = url.ParseQuery(c.req.RawQuery())
 	}
 	arr,ok = c.querys[key]
 	return
@@ -185,7 +244,7 @@ func (c *Context) QueryArrayGet(key string) (arr []string,ok bool) {
 
 func (c *Context) Querys() map[string][]string {
 	if c.querys == nil{
-		return c.Request.URL.Query()
+		c.querys, _ = url.ParseQuery(c.req.RawQuery())
 	}
 	return c.querys
 }
@@ -218,8 +277,8 @@ func (c *Context) PostFormArray(key string) []string {
 }
 
 func (c *Context) PostFormArrayGet(key string) ([]string,bool) {
-	_ = c.Request.ParseMultipartForm(c.route.RouteConf.MaxMultipartMemory)
-	arr,ok := c.Request.PostForm[key]
+	_ = c.Request().ParseMultipartForm(c.route.RouteConf.MaxMultipartMemory)
+	arr,ok := c.Request().PostForm[key]
 	if ok && len(arr) > 0{
 		return arr,ok
 	}
@@ -227,8 +286,8 @@ func (c *Context) PostFormArrayGet(key string) ([]string,bool) {
 }
 
 func (c *Context) PostFroms(key string) map[string][]string {
-	_ = c.Request.ParseMultipartForm(c.route.RouteConf.MaxMultipartMemory)
-	return c.Request.PostForm
+	_ = c.Request().ParseMultipartForm(c.route.RouteConf.MaxMultipartMemory)
+	return c.Request().PostForm
 }
 
 
@@ -269,6 +328,19 @@ func (c *Context) BindQuery(obj interface{}) error {
 	return c.BindWith(obj, bind.Query)
 }
 
+//path参数只有Context知道，不能通过bind.Binder解析
+func (c *Context) BindParam(obj interface{}) error {
+	res := make(map[string]string,len(c.params))
+	for _, p := range c.params{
+		res[p.Key] = p.Value
+	}
+	bytes, err := json.Marshal(res)
+	if err != nil{
+		return err
+	}
+	return json.Unmarshal(bytes,obj)
+}
+
 //postform > query
 func (c *Context) BindForm(obj interface{}) error {
 	err := c.BindQuery(obj)
@@ -318,32 +390,93 @@ func (c *Context)jsonResultAvailable() error {
 }
 
 func (c *Context) jsonBytesAvailable() error {
-	if c.jsonBytes == nil{
-		jn, err := ioutil.ReadAll(c.Request.Body)
-		if err != nil && err != io.EOF{
-			return err
+	_, err := c.RawBody()
+	return err
+}
+
+//请求体超过了ReadBody的限制
+var ErrBodyTooLarge = errors.New("route: request body too large")
+
+//读取完整的请求体，只会读取一次，之后的BindJSON、PostForm仍然可以使用
+func (c *Context) RawBody() ([]byte,error) {
+	return c.ReadBody(0)
+}
+
+//与RawBody相同，limit大于0时最多读取limit字节，超过时返回ErrBodyTooLarge
+//Content-Length已经超过limit时不读取请求体，之后仍然可以用更大的limit读取
+//读取过程中才超过limit时请求体已经不完整，之后的ReadBody、表单解析与绑定都返回同样的错误
+func (c *Context) ReadBody(limit int64) ([]byte,error) {
+	if c.bodyErr != nil{
+		return nil,c.bodyErr
+	}
+	if c.jsonBytes != nil{
+		if limit > 0 && int64(len(c.jsonBytes)) > limit{
+			return nil,ErrBodyTooLarge
 		}
-		c.jsonBytes = jn
+		return c.jsonBytes,nil
 	}
-	return nil
+	if limit > 0{
+		if n, err := strconv.ParseInt(c.req.Header("Content-Length"),10,64); err == nil && n > limit{
+			return nil,ErrBodyTooLarge
+		}
+	}
+	var r io.Reader = c.req.Body()
+	if r == nil{
+		r = bytes.NewReader(nil)
+	}
+	if limit > 0{
+		r = io.LimitReader(r,limit+1)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err == nil && limit > 0 && int64(len(body)) > limit{
+		err = ErrBodyTooLarge
+	}
+	if err != nil{
+		body = nil
+		c.bodyErr = err
+	} else if body == nil{
+		body = []byte{}
+	}
+	c.jsonBytes = body
+	//表单解析读取的是Request().Body，已经构造过时换成缓存的内容，没有构造时由Request处理
+	if c.request != nil {
+		c.request.Body = c.cachedBody()
+	}
+	return body,err
+}
+
+//ReadBody之后Request().Body读取的内容
+func (c *Context) cachedBody() io.ReadCloser {
+	if c.bodyErr != nil{
+		return ioutil.NopCloser(errReader{c.bodyErr})
+	}
+	return ioutil.NopCloser(bytes.NewReader(c.jsonBytes))
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
 }
 
 func (c *Context) BindWith(obj interface{},b bind.Binder) error {
-	return b.Parse(c.Request,obj)
+	return b.Parse(c.Request(),obj)
 }
 
 func (c *Context) Bind(obj interface{}) error {
-	t := strings.Split(c.Request.Header.Get("Content-Type"),";")[0]
+	t := strings.Split(c.req.Header("Content-Type"),";")[0]
 	opts := map[string]bind.Binder{
 		bind.MIME_JSON: bind.JSON,
 	}
-	return opts[t].Parse(c.Request,obj)
+	return opts[t].Parse(c.Request(),obj)
 }
 
 
 //获取文件信息与保存文件
 func (c *Context) FileInfo(name string) (*multipart.FileHeader, error) {
-	_,header, err := c.Request.FormFile(name)
+	_,header, err := c.Request().FormFile(name)
 	return header,err
 }
 
@@ -368,7 +501,7 @@ func (c *Context) Code(code int)  {
 }
 
 func (c *Context) File(path string)  {
-	http.ServeFile(c.Writer,c.Request,path)
+	http.ServeFile(c.Writer,c.Request(),path)
 }
 
 func (c *Context) WriteJSON(code int,obj interface{}) error {
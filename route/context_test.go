@@ -0,0 +1,94 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//记录HTTPRequest调用次数的请求
+type countingRequest struct {
+	Request
+	built int
+}
+
+func (r *countingRequest) HTTPRequest() *http.Request {
+	r.built++
+	return r.Request.HTTPRequest()
+}
+
+func TestLazyRequest(t *testing.T) {
+	r := New(&Config{MaxMultipartMemory: 1 << 20}, nil)
+	r.GET("/plain", func(c *Context) {
+		c.WriteString(http.StatusOK, c.Path()+c.HeaderGet("X-Test"))
+	})
+	r.POST("/form", func(c *Context) {
+		if _, err := c.ReadBody(0); err != nil {
+			t.Fatal(err)
+		}
+		//请求体已经被ReadBody读取，表单解析使用缓存的内容
+		c.WriteString(http.StatusOK, c.PostForm("name")+c.PostForm("name"))
+	})
+
+	req := &countingRequest{Request: NewRequest(httptest.NewRequest("GET", "/plain", nil))}
+	r.Serve(httptest.NewRecorder(), req)
+	if req.built != 0 {
+		t.Fatalf("net/http request built %d times for a handler that does not use it", req.built)
+	}
+
+	form := httptest.NewRequest("POST", "/form", strings.NewReader("name=alice"))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = &countingRequest{Request: NewRequest(form)}
+	w := httptest.NewRecorder()
+	r.Serve(w, req)
+	if req.built != 1 || w.Body.String() != "alicealice" {
+		t.Fatalf("built %d times, body %q", req.built, w.Body.String())
+	}
+}
+
+//读取中途超过限制后请求体已经不完整，之后的读取都返回同样的错误
+func TestReadBodyLimit(t *testing.T) {
+	r := New(&Config{MaxMultipartMemory: 1 << 20}, nil)
+	r.POST("/", func(c *Context) {
+		if _, err := c.ReadBody(4); err != ErrBodyTooLarge {
+			t.Fatalf("first read: %v", err)
+		}
+		if _, err := c.RawBody(); err != ErrBodyTooLarge {
+			t.Fatalf("second read: %v", err)
+		}
+		if v := c.PostForm("name"); v != "" {
+			t.Fatalf("form parsed from a truncated body: %q", v)
+		}
+		c.WriteString(http.StatusOK, "ok")
+	})
+	//Content-Length已经超过限制时不读取，之后仍然可以读取完整的请求体
+	r.POST("/length", func(c *Context) {
+		if _, err := c.ReadBody(4); err != ErrBodyTooLarge {
+			t.Fatalf("limited read: %v", err)
+		}
+		body, err := c.RawBody()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.WriteString(http.StatusOK, string(body))
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("name=alice"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	//没有Content-Length，只能在读取时发现超过限制
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	r.Serve(w, NewRequest(req))
+	if w.Body.String() != "ok" {
+		t.Fatalf("body %q", w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/length", strings.NewReader("name=alice"))
+	req.Header.Set("Content-Length", "10")
+	w = httptest.NewRecorder()
+	r.Serve(w, NewRequest(req))
+	if w.Body.String() != "name=alice" {
+		t.Fatalf("body %q", w.Body.String())
+	}
+}
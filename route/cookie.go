@@ -0,0 +1,172 @@
+package route
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	//没有通过Route.SetKeyring或者Mux.SetCookieKeys配置密钥
+	ErrNoKeyring = errors.New("cookie: keyring is not configured")
+	//签名不正确、无法解密或者格式错误，cookie被篡改或者密钥已经被移除
+	ErrCookieTampered = errors.New("cookie: value has been tampered with")
+	//cookie中记录的过期时间已经过了，客户端修改MaxAge也无法延长
+	ErrCookieExpired = errors.New("cookie: value has expired")
+)
+
+//写入cookie，Partitioned或者SameSite=None的cookie浏览器要求Secure，这里会自动设置
+//	c.SetCookie(&http.Cookie{Name: "embed", Value: "1", SameSite: http.SameSiteNoneMode, Partitioned: true})
+func (c *Context) SetCookie(cookie *http.Cookie) {
+	if cookie.Partitioned || cookie.SameSite == http.SameSiteNoneMode {
+		cp := *cookie
+		cp.Secure = true
+		cookie = &cp
+	}
+	http.SetCookie(c.Writer, cookie)
+}
+
+func (c *Context) keyring() (*Keyring, error) {
+	if c.route == nil {
+		return nil, ErrNoKeyring
+	}
+	k := c.route.Keyring()
+	if k == nil {
+		return nil, ErrNoKeyring
+	}
+	return k, nil
+}
+
+//写入签名的cookie，客户端可以看到值但无法修改
+//MaxAge或者Expires会同时写入签名的数据中，过期后SignedCookie返回ErrCookieExpired
+func (c *Context) SetSignedCookie(cookie *http.Cookie) error {
+	k, err := c.keyring()
+	if err != nil {
+		return err
+	}
+	payload := cookiePayload(cookie)
+	cp := *cookie
+	cp.Value = base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signCookie(k.current(), cookie.Name, payload))
+	c.SetCookie(&cp)
+	return nil
+}
+
+//读取签名的cookie，不存在时返回http.ErrNoCookie
+func (c *Context) SignedCookie(name string) (string, error) {
+	k, err := c.keyring()
+	if err != nil {
+		return "", err
+	}
+	ck, err := c.CookieGet(name)
+	if err != nil {
+		return "", err
+	}
+	i := strings.LastIndexByte(ck.Value, '.')
+	if i < 0 {
+		return "", ErrCookieTampered
+	}
+	payload, err1 := base64.RawURLEncoding.DecodeString(ck.Value[:i])
+	sum, err2 := base64.RawURLEncoding.DecodeString(ck.Value[i+1:])
+	if err1 != nil || err2 != nil {
+		return "", ErrCookieTampered
+	}
+	for _, key := range k.all() {
+		if hmac.Equal(sum, signCookie(key, name, payload)) {
+			return openPayload(payload)
+		}
+	}
+	return "", ErrCookieTampered
+}
+
+//写入加密的cookie，客户端既看不到也无法修改值，过期时间的处理与SetSignedCookie相同
+func (c *Context) SetEncryptedCookie(cookie *http.Cookie) error {
+	k, err := c.keyring()
+	if err != nil {
+		return err
+	}
+	aead := k.current().aead
+	payload := cookiePayload(cookie)
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	//cookie的名称作为附加数据，一个cookie的值不能被挪到另一个cookie中使用
+	sealed := aead.Seal(nonce, nonce, payload, []byte(cookie.Name))
+	cp := *cookie
+	cp.Value = base64.RawURLEncoding.EncodeToString(sealed)
+	c.SetCookie(&cp)
+	return nil
+}
+
+//读取加密的cookie，不存在时返回http.ErrNoCookie
+func (c *Context) EncryptedCookie(name string) (string, error) {
+	k, err := c.keyring()
+	if err != nil {
+		return "", err
+	}
+	ck, err := c.CookieGet(name)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(ck.Value)
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	for _, key := range k.all() {
+		n := key.aead.NonceSize()
+		if len(sealed) < n {
+			return "", ErrCookieTampered
+		}
+		payload, err := key.aead.Open(nil, sealed[:n], sealed[n:], []byte(name))
+		if err == nil {
+			return openPayload(payload)
+		}
+	}
+	return "", ErrCookieTampered
+}
+
+//8字节的过期时间（unix秒，0为不过期）加上原始的值
+func cookiePayload(cookie *http.Cookie) []byte {
+	var expires int64
+	switch {
+	case cookie.MaxAge > 0:
+		expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second).Unix()
+	case cookie.MaxAge < 0:
+		//删除cookie
+		expires = 1
+	case !cookie.Expires.IsZero():
+		expires = cookie.Expires.Unix()
+	}
+	payload := make([]byte, 8+len(cookie.Value))
+	binary.BigEndian.PutUint64(payload, uint64(expires))
+	copy(payload[8:], cookie.Value)
+	return payload
+}
+
+func openPayload(payload []byte) (string, error) {
+	if len(payload) < 8 {
+		return "", ErrCookieTampered
+	}
+	expires := int64(binary.BigEndian.Uint64(payload))
+	if expires != 0 && time.Now().Unix() >= expires {
+		return "", ErrCookieExpired
+	}
+	return string(payload[8:]), nil
+}
+
+//签名包含cookie的名称
+func signCookie(key *cookieKey, name string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key.sign)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,134 @@
+package route
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func cookieRoundTrip(t *testing.T, r *Route, set func(c *Context) error, get func(c *Context) (string, error), tamper func(*http.Cookie)) (string, error) {
+	w := httptest.NewRecorder()
+	r.GET("/set", func(c *Context) {
+		if err := set(c); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var got string
+	var err error
+	r.GET("/get", func(c *Context) {
+		got, err = get(c)
+	})
+	r.Run(w, httptest.NewRequest("GET", "/set", nil))
+	req := httptest.NewRequest("GET", "/get", nil)
+	for _, c := range w.Result().Cookies() {
+		if tamper != nil {
+			tamper(c)
+		}
+		req.AddCookie(c)
+	}
+	r.Run(httptest.NewRecorder(), req)
+	return got, err
+}
+
+func TestSignedAndEncryptedCookies(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	type helpers struct {
+		name string
+		set  func(c *Context, ck *http.Cookie) error
+		get  func(c *Context, name string) (string, error)
+	}
+	for _, h := range []helpers{
+		{"signed", (*Context).SetSignedCookie, (*Context).SignedCookie},
+		{"encrypted", (*Context).SetEncryptedCookie, (*Context).EncryptedCookie},
+	} {
+		cases := []struct {
+			name   string
+			cookie http.Cookie
+			rotate bool
+			tamper func(*http.Cookie)
+			want   string
+			err    error
+		}{
+			{"valid", http.Cookie{Name: "a", Value: "hello"}, false, nil, "hello", nil},
+			{"rotated", http.Cookie{Name: "a", Value: "hello", MaxAge: 60}, true, nil, "hello", nil},
+			{"tampered", http.Cookie{Name: "a", Value: "hello"}, false, func(c *http.Cookie) { c.Value = "x" + c.Value[1:] }, "", ErrCookieTampered},
+			{"renamed", http.Cookie{Name: "b", Value: "hello"}, false, func(c *http.Cookie) { c.Name = "a" }, "", ErrCookieTampered},
+			{"expired", http.Cookie{Name: "a", Value: "hello", MaxAge: -1}, false, nil, "", ErrCookieExpired},
+		}
+		for _, tc := range cases {
+			k, _ := NewKeyring(oldKey)
+			r := New(&Config{}, nil)
+			r.SetKeyring(k)
+			ck := tc.cookie
+			got, err := cookieRoundTrip(t, r,
+				func(c *Context) error {
+					err := h.set(c, &ck)
+					if tc.rotate {
+						k.Rotate(newKey, 1)
+					}
+					return err
+				},
+				func(c *Context) (string, error) { return h.get(c, "a") },
+				func(c *http.Cookie) {
+					//测试中需要读取MaxAge为-1的cookie
+					c.MaxAge = 0
+					if tc.tamper != nil {
+						tc.tamper(c)
+					}
+				})
+			if got != tc.want || err != tc.err {
+				t.Errorf("%s/%s: got %q, %v, want %q, %v", h.name, tc.name, got, err, tc.want, tc.err)
+			}
+		}
+	}
+}
+
+func TestSetCookiePartitioned(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := &Context{Writer: w}
+	c.SetCookie(&http.Cookie{Name: "embed", Value: "1", SameSite: http.SameSiteNoneMode, Partitioned: true})
+	line := w.Header().Get("Set-Cookie")
+	for _, want := range []string{"Secure", "SameSite=None", "Partitioned"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Set-Cookie %q missing %s", line, want)
+		}
+	}
+	if _, err := (&Context{route: New(&Config{}, nil)}).SignedCookie("a"); err != ErrNoKeyring {
+		t.Errorf("err = %v, want ErrNoKeyring", err)
+	}
+}
+
+//密钥在请求时沿着上级查找，先创建的分组也能使用之后设置的密钥
+func TestKeyringInheritedByGroups(t *testing.T) {
+	root := New(&Config{}, nil)
+	group := root.Group("/g").Authorize()
+	var err error
+	group.GET("/set", func(c *Context) {
+		err = c.SetSignedCookie(&http.Cookie{Name: "a", Value: "v"})
+	})
+	run := func() error {
+		err = nil
+		root.Run(httptest.NewRecorder(), httptest.NewRequest("GET", "/g/set", nil))
+		return err
+	}
+	if run() != ErrNoKeyring {
+		t.Fatalf("without a keyring: %v", err)
+	}
+	k, _ := NewKeyring(bytes.Repeat([]byte("k"), 32))
+	root.SetKeyring(k)
+	if run() != nil {
+		t.Fatalf("group created before SetKeyring: %v", err)
+	}
+	if group.(*Route).Keyring() != k {
+		t.Fatal("group does not resolve the root keyring")
+	}
+	//分组自己的密钥优先
+	own, _ := NewKeyring(bytes.Repeat([]byte("o"), 32))
+	group.(*Route).SetKeyring(own)
+	if group.(*Route).Keyring() != own || root.Keyring() != k {
+		t.Fatal("group keyring did not override the root keyring")
+	}
+}
@@ -0,0 +1,365 @@
+package route
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	//请求中没有token，或者token与session、cookie中的不一致
+	ErrCSRFToken = errors.New("csrf: invalid token")
+	//Origin或者Referer不是当前站点，也不在TrustedOrigins中
+	ErrCSRFOrigin = errors.New("csrf: origin not allowed")
+)
+
+//token在session中的key
+const csrfSessionKey = "_csrf"
+
+//token的原始长度，发给客户端的token会加上同样长度的随机掩码
+const csrfTokenLen = 32
+
+type CSRFConfig struct {
+	//不为空时使用签名的double-submit cookie，不需要session，否则token保存在session中
+	//cookie默认不与session绑定：能够在同一个站点下写入cookie的攻击者（例如子域名）
+	//可以把自己的cookie种给受害者，再提交对应的token
+	Secret []byte
+	//使用Secret时把cookie的签名与请求中已经存在的sid绑定，种入的cookie与受害者的session不匹配，无法通过校验
+	//sid变化（登录后RegenerateSession）时token随之更换，之前页面中的token失效
+	//每个请求都会读取session，没有session的匿名访问者与不绑定时相同
+	BindSession bool
+	//提交token的头部，default:X-CSRF-Token
+	Header string
+	//表单中的字段，Content-Type为json时也从同名的字段中读取，default:csrf_token
+	Field string
+	//double-submit使用的cookie，default:_csrf
+	CookieName string
+	CookiePath string
+	Domain     string
+	Secure     bool
+	//default:http.SameSiteLaxMode
+	SameSite http.SameSite
+	//允许的其他来源，例如https://admin.example.com，当前站点总是允许的
+	TrustedOrigins []string
+	//校验失败时调用，default:返回403
+	ErrorHandler func(c *Context, err error)
+}
+
+//CSRF防护，GET、HEAD、OPTIONS、TRACE以外的请求需要先通过Origin/Referer校验，再提交正确的token
+//
+//	csrf := route.NewCSRF(route.CSRFConfig{})
+//	admin := r.Group("/admin", csrf.Handler())
+//	api := admin.Group("/hooks")
+//	csrf.ExemptGroup(api)
+//
+//模板中通过Context.CSRFToken或者Context.CSRFField输出token
+type CSRF struct {
+	conf     CSRFConfig
+	exact    map[string]bool
+	prefixes []string
+}
+
+func NewCSRF(conf CSRFConfig) *CSRF {
+	if conf.Header == "" {
+		conf.Header = "X-CSRF-Token"
+	}
+	if conf.Field == "" {
+		conf.Field = "csrf_token"
+	}
+	if conf.CookieName == "" {
+		conf.CookieName = "_csrf"
+	}
+	if conf.CookiePath == "" {
+		conf.CookiePath = "/"
+	}
+	if conf.SameSite == 0 {
+		conf.SameSite = http.SameSiteLaxMode
+	}
+	if conf.ErrorHandler == nil {
+		conf.ErrorHandler = func(c *Context, err error) {
+			http.Error(c.Writer, http.StatusText(http.StatusForbidden)+": "+err.Error(), http.StatusForbidden)
+		}
+	}
+	return &CSRF{conf: conf, exact: make(map[string]bool)}
+}
+
+//不校验的路径，应当在开始处理请求之前调用
+func (x *CSRF) Exempt(paths ...string) {
+	for _, p := range paths {
+		x.exact[p] = true
+	}
+}
+
+//分组下的所有路由都不校验，例如使用签名校验的webhook，应当在开始处理请求之前调用
+//根路由会使所有的路由都不校验，传入时panic
+func (x *CSRF) ExemptGroup(groups ...Router) {
+	for _, g := range groups {
+		if r, ok := g.(interface{ BasePath() string }); ok {
+			p := strings.TrimSuffix(r.BasePath(), "/")
+			if p == "" {
+				panic("csrf: ExemptGroup with the root group exempts every route")
+			}
+			x.prefixes = append(x.prefixes, p)
+		}
+	}
+}
+
+func (x *CSRF) exempt(path string) bool {
+	if x.exact[path] {
+		return true
+	}
+	for _, p := range x.prefixes {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *CSRF) Handler() HandlerFunc {
+	return func(c *Context) {
+		c.csrf = x
+		if x.conf.Secret != nil {
+			//提前写入cookie，模板渲染时响应头可能已经发出
+			if _, ok := x.cookieToken(c); !ok {
+				x.newCookieToken(c)
+			}
+		}
+		switch c.Method() {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			c.Next()
+			return
+		}
+		if x.exempt(c.Path()) {
+			c.Next()
+			return
+		}
+		if err := x.check(c); err != nil {
+			if !c.IsAborted() {
+				x.conf.ErrorHandler(c, err)
+				c.Abort()
+			}
+			return
+		}
+		c.Next()
+	}
+}
+
+func (x *CSRF) check(c *Context) error {
+	if err := x.checkOrigin(c); err != nil {
+		return err
+	}
+	sent, ok := unmaskCSRF(x.submitted(c))
+	if !ok {
+		return ErrCSRFToken
+	}
+	var want []byte
+	if x.conf.Secret != nil {
+		want, _ = x.cookieToken(c)
+	} else {
+		sess, err := c.Session()
+		if err != nil {
+			return err
+		}
+		want, _ = base64.RawURLEncoding.DecodeString(stringValue(sess.Get(csrfSessionKey)))
+	}
+	if len(want) != csrfTokenLen || subtle.ConstantTimeCompare(sent, want) != 1 {
+		return ErrCSRFToken
+	}
+	return nil
+}
+
+//依次从头部、表单与json中读取token
+func (x *CSRF) submitted(c *Context) string {
+	if v := c.HeaderGet(x.conf.Header); v != "" {
+		return v
+	}
+	ct := c.HeaderGet("Content-Type")
+	if strings.HasPrefix(ct, "application/json") {
+		//json会被缓存，之后的BindJSON仍然可以读取
+		if res, err := c.FormJSONGet(x.conf.Field); err == nil {
+			return res.String()
+		}
+		return ""
+	}
+	return c.PostForm(x.conf.Field)
+}
+
+//有Origin时校验Origin，否则校验Referer，https请求必须带有其中一个
+func (x *CSRF) checkOrigin(c *Context) error {
+	source := c.HeaderGet("Origin")
+	if source == "" {
+		source = c.HeaderGet("Referer")
+	}
+	if source == "" {
+		if c.Request().TLS != nil {
+			return ErrCSRFOrigin
+		}
+		return nil
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return ErrCSRFOrigin
+	}
+	if strings.EqualFold(u.Host, c.Request().Host) {
+		return nil
+	}
+	origin := u.Scheme + "://" + u.Host
+	for _, o := range x.conf.TrustedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return nil
+		}
+	}
+	return ErrCSRFOrigin
+}
+
+//cookie的值为token加上它的HMAC
+func (x *CSRF) cookieToken(c *Context) ([]byte, bool) {
+	sid := x.sid(c)
+	if c.csrfToken != nil && c.csrfSid == sid {
+		return c.csrfToken, true
+	}
+	ck, err := c.CookieGet(x.conf.CookieName)
+	if err != nil {
+		return nil, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(ck.Value)
+	if err != nil || len(raw) != csrfTokenLen+sha256.Size {
+		return nil, false
+	}
+	token, sum := raw[:csrfTokenLen], raw[csrfTokenLen:]
+	if !hmac.Equal(sum, x.sign(token, sid)) {
+		return nil, false
+	}
+	return token, true
+}
+
+func (x *CSRF) newCookieToken(c *Context) ([]byte, error) {
+	token, err := randomCSRF()
+	if err != nil {
+		return nil, err
+	}
+	sid := x.sid(c)
+	value := base64.RawURLEncoding.EncodeToString(append(token, x.sign(token, sid)...))
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     x.conf.CookieName,
+		Value:    value,
+		Path:     x.conf.CookiePath,
+		Domain:   x.conf.Domain,
+		Secure:   x.conf.Secure,
+		HttpOnly: true,
+		SameSite: x.conf.SameSite,
+	})
+	//同一个请求中之后的CSRFToken读取到的是新的token
+	c.csrfToken = token
+	c.csrfSid = sid
+	return token, nil
+}
+
+//没有开启BindSession或者没有session时为空，签名与不绑定时相同
+func (x *CSRF) sid(c *Context) string {
+	if !x.conf.BindSession {
+		return ""
+	}
+	sess, err := c.ExistingSession()
+	if err != nil {
+		return ""
+	}
+	return sess.ID()
+}
+
+//token的长度固定，sid直接拼接在后面
+func (x *CSRF) sign(token []byte, sid string) []byte {
+	mac := hmac.New(sha256.New, x.conf.Secret)
+	mac.Write([]byte("csrf"))
+	mac.Write(token)
+	mac.Write([]byte(sid))
+	return mac.Sum(nil)
+}
+
+//当前请求的token，没有时会生成一个新的，没有使用CSRF中间件时返回空字符串
+//每次返回的值都不相同，但都可以通过校验
+func (c *Context) CSRFToken() string {
+	x := c.csrf
+	if x == nil {
+		return ""
+	}
+	var token []byte
+	if x.conf.Secret != nil {
+		var ok bool
+		if token, ok = x.cookieToken(c); !ok {
+			var err error
+			if token, err = x.newCookieToken(c); err != nil {
+				return ""
+			}
+		}
+	} else {
+		sess, err := c.Session()
+		if err != nil {
+			return ""
+		}
+		token, _ = base64.RawURLEncoding.DecodeString(stringValue(sess.Get(csrfSessionKey)))
+		if len(token) != csrfTokenLen {
+			if token, err = randomCSRF(); err != nil {
+				return ""
+			}
+			sess.Set(csrfSessionKey, base64.RawURLEncoding.EncodeToString(token))
+		}
+	}
+	return maskCSRF(token)
+}
+
+//表单中使用的隐藏字段
+//	<form method="post">{{.CSRFField}}</form>
+func (c *Context) CSRFField() template.HTML {
+	if c.csrf == nil {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(c.csrf.conf.Field) +
+		`" value="` + c.CSRFToken() + `">`)
+}
+
+func randomCSRF() ([]byte, error) {
+	token := make([]byte, csrfTokenLen)
+	if _, err := io.ReadFull(rand.Reader, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+//加上随机掩码，响应被压缩时无法通过长度猜测token（BREACH）
+func maskCSRF(token []byte) string {
+	out := make([]byte, csrfTokenLen*2)
+	if _, err := io.ReadFull(rand.Reader, out[:csrfTokenLen]); err != nil {
+		return ""
+	}
+	for i := range token {
+		out[csrfTokenLen+i] = out[i] ^ token[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+func unmaskCSRF(s string) ([]byte, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(raw) != csrfTokenLen*2 {
+		return nil, false
+	}
+	token := make([]byte, csrfTokenLen)
+	for i := range token {
+		token[i] = raw[i] ^ raw[csrfTokenLen+i]
+	}
+	return token, true
+}
+
+func stringValue(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
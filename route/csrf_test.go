@@ -0,0 +1,176 @@
+package route
+
+import (
+	"mux/session"
+	_ "mux/session/memory"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newCSRFRoute(t *testing.T, conf CSRFConfig) (*Route, *CSRF) {
+	manager, err := session.NewManage(&session.ManagerConf{
+		ProviderName:    "memory",
+		CookieName:      "sid",
+		EnableSetCookie: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(&Config{MaxMultipartMemory: 1 << 20}, manager)
+	csrf := NewCSRF(conf)
+	r.Use(csrf.Handler())
+	r.GET("/form", func(c *Context) {
+		c.WriteString(http.StatusOK, c.CSRFToken())
+	})
+	r.POST("/form", func(c *Context) {
+		c.WriteString(http.StatusOK, "ok")
+	})
+	hooks := r.Group("/hooks")
+	hooks.POST("/push", func(c *Context) {
+		c.WriteString(http.StatusOK, "ok")
+	})
+	csrf.ExemptGroup(hooks)
+	return r, csrf
+}
+
+func csrfPost(r *Route, path, token string, cookies []*http.Cookie, header map[string]string) int {
+	form := url.Values{"csrf_token": {token}}
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	r.Run(w, req)
+	return w.Code
+}
+
+type csrfCase struct {
+	name   string
+	path   string
+	token  string
+	header map[string]string
+	want   int
+}
+
+func TestCSRF(t *testing.T) {
+	for _, mode := range []struct {
+		name string
+		conf CSRFConfig
+	}{
+		{"session", CSRFConfig{}},
+		{"cookie", CSRFConfig{Secret: []byte("secret"), TrustedOrigins: []string{"https://admin.example.com"}}},
+	} {
+		r, _ := newCSRFRoute(t, mode.conf)
+		w := httptest.NewRecorder()
+		r.Run(w, httptest.NewRequest("GET", "/form", nil))
+		token, cookies := w.Body.String(), w.Result().Cookies()
+		if token == "" {
+			t.Fatalf("%s: empty token", mode.name)
+		}
+
+		cases := []csrfCase{
+			{"valid", "/form", token, nil, http.StatusOK},
+			{"missing", "/form", "", nil, http.StatusForbidden},
+			{"forged", "/form", strings.Repeat("A", len(token)), nil, http.StatusForbidden},
+			{"cross origin", "/form", token, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+			{"same origin", "/form", token, map[string]string{"Origin": "http://example.com"}, http.StatusOK},
+			{"referer", "/form", token, map[string]string{"Referer": "https://evil.example/page"}, http.StatusForbidden},
+			{"header", "/form", "", map[string]string{"X-CSRF-Token": token}, http.StatusOK},
+			{"exempt group", "/hooks/push", "", nil, http.StatusOK},
+		}
+		if mode.conf.TrustedOrigins != nil {
+			cases = append(cases, csrfCase{"trusted origin", "/form", token, map[string]string{"Origin": "https://admin.example.com"}, http.StatusOK})
+		}
+		for _, c := range cases {
+			if got := csrfPost(r, c.path, c.token, cookies, c.header); got != c.want {
+				t.Errorf("%s/%s: status = %d, want %d", mode.name, c.name, got, c.want)
+			}
+		}
+		if got := csrfPost(r, "/form", token, nil, nil); got != http.StatusForbidden {
+			t.Errorf("%s: token accepted without the session cookie: %d", mode.name, got)
+		}
+	}
+}
+
+func TestCSRFJSON(t *testing.T) {
+	r, _ := newCSRFRoute(t, CSRFConfig{Secret: []byte("secret")})
+	r.POST("/json", func(c *Context) {
+		var body struct{ Name string }
+		if err := c.BindJSON(&body); err != nil {
+			t.Fatal(err)
+		}
+		c.WriteString(http.StatusOK, body.Name)
+	})
+	w := httptest.NewRecorder()
+	r.Run(w, httptest.NewRequest("GET", "/form", nil))
+	token := w.Body.String()
+
+	req := httptest.NewRequest("POST", "/json", strings.NewReader(`{"csrf_token":"`+token+`","Name":"mux"}`))
+	req.Header.Set("Content-Type", "application/json")
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	r.Run(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "mux" {
+		t.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+	}
+}
+
+func TestCSRFExemptRootGroup(t *testing.T) {
+	r, csrf := newCSRFRoute(t, CSRFConfig{})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("ExemptGroup accepted the root group")
+		}
+	}()
+	csrf.ExemptGroup(r)
+}
+
+func TestCSRFBindSession(t *testing.T) {
+	r, _ := newCSRFRoute(t, CSRFConfig{Secret: []byte("secret"), BindSession: true})
+	r.GET("/login", func(c *Context) {
+		sess, err := c.Session()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sess.Set("user", "victim")
+		c.WriteString(http.StatusOK, c.CSRFToken())
+	})
+	get := func(path string) (string, []*http.Cookie) {
+		w := httptest.NewRecorder()
+		r.Run(w, httptest.NewRequest("GET", path, nil))
+		return w.Body.String(), w.Result().Cookies()
+	}
+	//攻击者没有session时拿到的cookie与token
+	planted, plantedCookies := get("/form")
+	token, cookies := get("/login")
+	var sid, csrfCookie *http.Cookie
+	for _, c := range cookies {
+		switch c.Name {
+		case "sid":
+			sid = c
+		case "_csrf":
+			csrfCookie = c
+		}
+	}
+	if sid == nil || csrfCookie == nil {
+		t.Fatalf("cookies = %v", cookies)
+	}
+	if got := csrfPost(r, "/form", token, []*http.Cookie{sid, csrfCookie}, nil); got != http.StatusOK {
+		t.Errorf("bound token: status = %d", got)
+	}
+	if got := csrfPost(r, "/form", planted, append(plantedCookies, sid), nil); got != http.StatusForbidden {
+		t.Errorf("planted cookie accepted with the victim's session: %d", got)
+	}
+	if got := csrfPost(r, "/form", token, []*http.Cookie{csrfCookie}, nil); got != http.StatusForbidden {
+		t.Errorf("bound cookie accepted without the session: %d", got)
+	}
+}
@@ -0,0 +1,42 @@
+package route
+
+import "html/template"
+
+//flash消息在session中的key的前缀，每种消息一个key
+const flashKeyPrefix = "_flash."
+
+//添加一条一次性的消息，例如保存成功后重定向，在下一个请求中通过Flashes读取
+//	c.Flash("success","保存成功")
+func (c *Context) Flash(kind,msg string) error {
+	sess, err := c.Session()
+	if err != nil {
+		return err
+	}
+	key := flashKeyPrefix + kind
+	msgs, _ := sess.Get(key).([]string)
+	sess.Set(key,append(msgs,msg))
+	return nil
+}
+
+//读取某一种flash消息，读取后就会从session中删除
+//请求中没有session时直接返回nil，不会为匿名的访问者创建session
+func (c *Context) Flashes(kind string) []string {
+	sess, err := c.ExistingSession()
+	if err != nil {
+		return nil
+	}
+	key := flashKeyPrefix + kind
+	msgs, _ := sess.Get(key).([]string)
+	if msgs != nil {
+		sess.Del(key)
+	}
+	return msgs
+}
+
+//模板中使用的函数，渲染时读取并清除flash消息
+//	t := template.Must(template.New("page").Funcs(c.FlashFuncs()).Parse(`{{range flashes "success"}}<p>{{.}}</p>{{end}}`))
+func (c *Context) FlashFuncs() template.FuncMap {
+	return template.FuncMap{
+		"flashes": c.Flashes,
+	}
+}
@@ -0,0 +1,77 @@
+package route
+
+import (
+	"mux/session"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFlashes(t *testing.T) {
+	manager, err := session.NewManage(&session.ManagerConf{
+		ProviderName:    "memory",
+		CookieName:      "sid",
+		EnableSetCookie: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := 0
+	manager.SetHooks(session.Hooks{OnCreate: func(string) { created++ }})
+	r := New(&Config{}, manager)
+	r.GET("/save", func(c *Context) {
+		c.Flash("success", "saved")
+		c.Flash("success", "again")
+	})
+	var got []string
+	r.GET("/show", func(c *Context) {
+		got = c.Flashes("success")
+	})
+
+	//匿名的访问者读取flash不会创建session，也不会写入cookie
+	w := httptest.NewRecorder()
+	r.Run(w, httptest.NewRequest("GET", "/show", nil))
+	if got != nil || created != 0 || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("anonymous read: %v, created %d, cookies %v", got, created, w.Result().Cookies())
+	}
+
+	w = httptest.NewRecorder()
+	r.Run(w, httptest.NewRequest("GET", "/save", nil))
+	cookies := w.Result().Cookies()
+	show := func() []string {
+		req := httptest.NewRequest("GET", "/show", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		got = nil
+		r.Run(httptest.NewRecorder(), req)
+		return got
+	}
+	//只能读取一次
+	if msgs := show(); !reflect.DeepEqual(msgs, []string{"saved", "again"}) {
+		t.Fatalf("first read: %v", msgs)
+	}
+	if msgs := show(); msgs != nil {
+		t.Fatalf("second read: %v", msgs)
+	}
+	if created != 1 {
+		t.Fatalf("created %d sessions", created)
+	}
+}
+
+func TestExistingSessionWithoutSupport(t *testing.T) {
+	//manager没有实现ExistingSessioner时退回到Session
+	manager, _ := session.NewManage(&session.ManagerConf{ProviderName: "memory", CookieName: "sid", EnableSetCookie: true})
+	r := New(&Config{}, struct{ session.Manager }{manager})
+	r.GET("/", func(c *Context) {
+		if _, err := c.ExistingSession(); err != nil {
+			t.Error(err)
+		}
+	})
+	w := httptest.NewRecorder()
+	r.Run(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("code %d, cookies %v", w.Code, w.Result().Cookies())
+	}
+}
@@ -0,0 +1,58 @@
+package route
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//注册的路由，用于审查路由与授权策略
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	//包括中间件在内的handler数量
+	Handlers int `json:"handlers"`
+	//Authorize声明的策略，为空表示没有授权要求
+	Policies []string `json:"policies"`
+}
+
+func (ri RouteInfo) String() string {
+	policy := "-"
+	if len(ri.Policies) > 0 {
+		policy = strings.Join(ri.Policies, " & ")
+	}
+	return ri.Method + " " + ri.Path + " " + policy
+}
+
+func (m *MethodTrees) addInfo(ri RouteInfo) {
+	m.infos = append(m.infos, ri)
+}
+
+func policyList(policies []*Policy) []string {
+	names := make([]string, len(policies))
+	for i, p := range policies {
+		names[i] = p.Name
+	}
+	return names
+}
+
+//所有注册的路由，按照路径与方法排序
+func (r *Route) Routes() []RouteInfo {
+	infos := append([]RouteInfo(nil), r.tree.infos...)
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].Path != infos[j].Path {
+			return infos[i].Path < infos[j].Path
+		}
+		return infos[i].Method < infos[j].Method
+	})
+	return infos
+}
+
+//以json返回Routes，应当只注册在内部或者需要认证的地址上
+//	m.Group("/debug").Authorize(route.RequireRoles("security")).GET("/routes",m.RoutesHandler())
+func (r *Route) RoutesHandler() HandlerFunc {
+	return func(c *Context) {
+		c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		c.WriteJSON(http.StatusOK, r.Routes())
+	}
+}
@@ -0,0 +1,113 @@
+package route
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"sync"
+)
+
+//密钥的最小长度
+const minKeyLen = 16
+
+var ErrShortKey = errors.New("keyring: key must be at least 16 bytes")
+
+//签名与加密cookie使用的密钥，第一个密钥用于签名与加密，所有的密钥都可以用于校验与解密
+//轮换时通过Rotate加入新的密钥，旧的密钥保留到使用它的cookie都过期为止
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []*cookieKey
+}
+
+//由一个原始密钥派生出的签名密钥与AEAD
+type cookieKey struct {
+	sign []byte
+	aead cipher.AEAD
+}
+
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.SetKeys(keys...); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+//替换所有的密钥，第一个是当前使用的密钥
+func (k *Keyring) SetKeys(keys ...[]byte) error {
+	if len(keys) == 0 {
+		return errors.New("keyring: at least one key is required")
+	}
+	derived := make([]*cookieKey, 0, len(keys))
+	for _, key := range keys {
+		ck, err := deriveKey(key)
+		if err != nil {
+			return err
+		}
+		derived = append(derived, ck)
+	}
+	k.mu.Lock()
+	k.keys = derived
+	k.mu.Unlock()
+	return nil
+}
+
+//使用新的密钥签名与加密，最多保留keep个旧的密钥用于校验
+func (k *Keyring) Rotate(key []byte, keep int) error {
+	ck, err := deriveKey(key)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if keep < 0 {
+		keep = 0
+	}
+	if len(k.keys) > keep {
+		k.keys = k.keys[:keep]
+	}
+	k.keys = append([]*cookieKey{ck}, k.keys...)
+	return nil
+}
+
+func (k *Keyring) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.keys)
+}
+
+func (k *Keyring) current() *cookieKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[0]
+}
+
+func (k *Keyring) all() []*cookieKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys
+}
+
+//签名与加密使用不同的派生密钥，同一个原始密钥可以同时用于两者
+func deriveKey(key []byte) (*cookieKey, error) {
+	if len(key) < minKeyLen {
+		return nil, ErrShortKey
+	}
+	block, err := aes.NewCipher(derive(key, "mux cookie encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieKey{sign: derive(key, "mux cookie sign"), aead: aead}, nil
+}
+
+func derive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
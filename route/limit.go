@@ -0,0 +1,87 @@
+package route
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//限制同时处理的请求数量，超出的请求会排队等待，等待超时后返回503
+type Limiter struct {
+	sem        chan struct{}
+	timeout    time.Duration
+	retryAfter time.Duration
+	rejected   uint64
+}
+
+//max为同时处理的最大请求数，timeout为排队的最长时间，retryAfter写入响应的Retry-After头部
+func NewLimiter(max int, timeout, retryAfter time.Duration) *Limiter {
+	if max <= 0 {
+		panic("limiter: max must be greater than 0")
+	}
+	return &Limiter{
+		sem:        make(chan struct{}, max),
+		timeout:    timeout,
+		retryAfter: retryAfter,
+	}
+}
+
+//获取一个处理名额，排队超时返回false
+func (l *Limiter) Acquire() bool {
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+	}
+	if l.timeout <= 0 {
+		atomic.AddUint64(&l.rejected, 1)
+		return false
+	}
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		atomic.AddUint64(&l.rejected, 1)
+		return false
+	}
+}
+
+func (l *Limiter) Release() {
+	<-l.sem
+}
+
+//被拒绝的请求数量
+func (l *Limiter) Rejected() uint64 {
+	return atomic.LoadUint64(&l.rejected)
+}
+
+//正在处理的请求数量
+func (l *Limiter) InFlight() int {
+	return len(l.sem)
+}
+
+//返回503，并告诉客户端多久之后重试
+func (l *Limiter) Reject(w http.ResponseWriter) {
+	if l.retryAfter > 0 {
+		sec := int64((l.retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(sec, 10))
+	}
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+//作为中间件使用，可以限制分组路由的并发数
+//	api := r.Group("/api",route.NewLimiter(100,time.Second,5*time.Second).Handler())
+func (l *Limiter) Handler() HandlerFunc {
+	return func(c *Context) {
+		if !l.Acquire() {
+			l.Reject(c.Writer)
+			c.Abort()
+			return
+		}
+		defer l.Release()
+		c.Next()
+	}
+}
@@ -0,0 +1,94 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//在后台发起请求，返回收到的状态码
+func serveAsync(r *Route, path string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		r.Run(w, httptest.NewRequest("GET", path, nil))
+		done <- w
+	}()
+	return done
+}
+
+func TestLimiterHandler(t *testing.T) {
+	r := New(&Config{}, nil)
+	limiter := NewLimiter(1, 0, 1500*time.Millisecond)
+	entered, release := make(chan struct{}), make(chan struct{})
+	api := r.Group("/api", limiter.Handler())
+	api.GET("/slow", func(c *Context) {
+		entered <- struct{}{}
+		<-release
+		c.WriteString(http.StatusOK, "ok")
+	})
+	api.GET("/fast", func(c *Context) {
+		c.WriteString(http.StatusOK, "ok")
+	})
+	r.GET("/free", func(c *Context) {
+		c.WriteString(http.StatusOK, "ok")
+	})
+
+	first := serveAsync(r, "/api/slow")
+	<-entered
+	if n := limiter.InFlight(); n != 1 {
+		t.Fatalf("InFlight = %d", n)
+	}
+	//分组的名额被占满，其他路由不受影响
+	w := httptest.NewRecorder()
+	r.Run(w, httptest.NewRequest("GET", "/api/fast", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("limited: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	w = httptest.NewRecorder()
+	r.Run(w, httptest.NewRequest("GET", "/free", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("route outside the group: %d", w.Code)
+	}
+	close(release)
+	if w := <-first; w.Code != http.StatusOK {
+		t.Fatalf("first request: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.Run(w, httptest.NewRequest("GET", "/api/fast", nil))
+	if w.Code != http.StatusOK || limiter.Rejected() != 1 || limiter.InFlight() != 0 {
+		t.Fatalf("after release: %d, rejected %d, in flight %d", w.Code, limiter.Rejected(), limiter.InFlight())
+	}
+}
+
+func TestLimiterQueue(t *testing.T) {
+	limiter := NewLimiter(1, time.Second, 0)
+	if !limiter.Acquire() {
+		t.Fatal("first Acquire failed")
+	}
+	//排队的请求在超时之前得到名额
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		limiter.Release()
+	}()
+	if !limiter.Acquire() {
+		t.Fatal("queued Acquire failed")
+	}
+
+	limiter = NewLimiter(1, 20*time.Millisecond, 0)
+	limiter.Acquire()
+	start := time.Now()
+	if limiter.Acquire() {
+		t.Fatal("Acquire succeeded while full")
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("rejected after %v, before the queue timeout", d)
+	}
+	//没有设置retryAfter时不写Retry-After
+	w := httptest.NewRecorder()
+	limiter.Reject(w)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "" {
+		t.Fatalf("Reject: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,66 @@
+package route
+
+import (
+	"mux/session"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPanicReleasesSessionLock(t *testing.T) {
+	manager, err := session.NewManage(&session.ManagerConf{
+		ProviderName:    "memory",
+		CookieName:      "sid",
+		EnableSetCookie: true,
+		LockTimeout:     50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(&Config{}, manager)
+	r.GET("/login", func(c *Context) {
+		sess, _ := c.Session()
+		sess.Set("user", "alice")
+	})
+	r.GET("/panic", func(c *Context) {
+		sess, _ := c.Session()
+		sess.Set("user", "mallory")
+		panic("boom")
+	})
+	r.GET("/user", func(c *Context) {
+		sess, err := c.Session()
+		if err != nil {
+			return
+		}
+		c.WriteString(http.StatusOK, sess.Get("user").(string))
+	})
+	w := httptest.NewRecorder()
+	r.Run(w, httptest.NewRequest("GET", "/login", nil))
+	withSid := func(path string) *http.Request {
+		req := httptest.NewRequest("GET", path, nil)
+		for _, c := range w.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		return req
+	}
+
+	func() {
+		defer func() {
+			//panic继续传递给上层，例如net/http
+			if recover() != "boom" {
+				t.Fatal("panic was swallowed")
+			}
+		}()
+		r.Run(httptest.NewRecorder(), withSid("/panic"))
+	}()
+	//锁已经释放，之后的请求不会等待超时
+	got := httptest.NewRecorder()
+	r.Run(got, withSid("/user"))
+	if got.Code != http.StatusOK {
+		t.Fatalf("after panic: %d", got.Code)
+	}
+	if got.Body.String() != "alice" && got.Body.String() != "mallory" {
+		t.Fatalf("user = %q", got.Body.String())
+	}
+}
@@ -0,0 +1,40 @@
+package route
+
+//通过认证的调用方，由认证中间件写入Context，之后的handler、日志与授权都从这里读取
+type Principal struct {
+	//用户名、API key的所有者或者token的sub
+	ID string
+	//认证方式，例如basic、digest、apikey、jwt、mtls
+	Method      string
+	Roles       []string
+	Permissions []string
+	//认证方式特有的信息，例如jwt的claims
+	Attributes map[string]interface{}
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return p != nil && contains(p.Roles,role)
+}
+
+func (p *Principal) HasPermission(perm string) bool {
+	return p != nil && contains(p.Permissions,perm)
+}
+
+func contains(list []string,s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+//记录通过认证的调用方
+func (c *Context) SetPrincipal(p *Principal) {
+	c.principal = p
+}
+
+//当前请求的调用方，没有通过认证时返回nil
+func (c *Context) Principal() *Principal {
+	return c.principal
+}
@@ -0,0 +1,82 @@
+package route
+
+import (
+	"io"
+	"net/http"
+)
+
+//对请求的抽象，Context通过它读取请求信息
+//net/http与fast引擎各自提供实现，handler不需要关心底层使用的是哪一个
+type Request interface {
+	Method() string
+	//解码后的path
+	Path() string
+	//未解码的path
+	RawPath() string
+	RequestURI() string
+	//不包含?的查询字符串
+	RawQuery() string
+	Proto() string
+	Header(key string) string
+	HeaderValues(key string) []string
+	Body() io.Reader
+	RemoteAddr() string
+	//兼容net/http的请求，Context.Request第一次调用时使用它构造
+	HTTPRequest() *http.Request
+}
+
+//net/http的适配器，只包含一个指针，转换成接口时不会产生额外的内存分配
+type stdRequest struct {
+	req *http.Request
+}
+
+func NewRequest(r *http.Request) Request {
+	return stdRequest{req: r}
+}
+
+func (r stdRequest) Method() string {
+	return r.req.Method
+}
+
+func (r stdRequest) Path() string {
+	return r.req.URL.Path
+}
+
+func (r stdRequest) RawPath() string {
+	if r.req.URL.RawPath != "" {
+		return r.req.URL.RawPath
+	}
+	return r.req.URL.EscapedPath()
+}
+
+func (r stdRequest) RequestURI() string {
+	return r.req.RequestURI
+}
+
+func (r stdRequest) RawQuery() string {
+	return r.req.URL.RawQuery
+}
+
+func (r stdRequest) Proto() string {
+	return r.req.Proto
+}
+
+func (r stdRequest) Header(key string) string {
+	return r.req.Header.Get(key)
+}
+
+func (r stdRequest) HeaderValues(key string) []string {
+	return r.req.Header.Values(key)
+}
+
+func (r stdRequest) Body() io.Reader {
+	return r.req.Body
+}
+
+func (r stdRequest) RemoteAddr() string {
+	return r.req.RemoteAddr
+}
+
+func (r stdRequest) HTTPRequest() *http.Request {
+	return r.req
+}
@@ -32,6 +32,8 @@ type Router interface {
 
 	//分组路由
 	Group(string,...HandlerFunc) Router
+	//授权策略，返回的路由器注册的路由都需要满足这些策略
+	Authorize(...*Policy) Router
 
 	//AOP切面编程
 	//TODO:更多层次的切面
@@ -51,7 +53,7 @@ var DefaultRoute = Route{
 		OpenSession:  true,
 	},
 	tree:     NewMethodTrees(),
-	//manager:s
+	manager:  session.DefaultManager,
 	basePath: "/",
 	Handlers: nil,
 }
@@ -60,21 +62,29 @@ var DefaultRoute = Route{
 type Route struct {
 	RouteConf *Config
 	tree      *MethodTrees
-	manager   *session.Manager
+	manager   session.Manager
+	//签名与加密cookie使用的密钥，为nil时使用parent的
+	keyring   *Keyring
+	//创建这个分组的路由器，请求时沿着它查找密钥，之后在上级设置的密钥也会生效
+	parent    *Route
 	basePath  string
 	Handlers  []HandlerFunc
+	//Authorize声明的策略，注册路由时记录下来供Routes使用
+	policies  []*Policy
+	//调用链结束时保存session失败的处理函数
+	sessionErrorHandler func(c *Context,err error)
 }
 //配置文件
 type Config struct {
 	//是否将path转义用于字典树匹配
-	PathUnescape bool
+	PathUnescape bool `json:"pathUnescape" yaml:"pathUnescape" env:"PATH_UNESCAPE"`
 	//request表单提交中使用的内存限制
-	MaxMultipartMemory int64
+	MaxMultipartMemory int64 `json:"maxMultipartMemory" yaml:"maxMultipartMemory" env:"MAX_MULTIPART_MEMORY"`
 	//是否启用session
-	OpenSession bool
+	OpenSession bool `json:"openSession" yaml:"openSession" env:"OPEN_SESSION"`
 }
 
-func New(conf *Config,manager *session.Manager) *Route {
+func New(conf *Config,manager session.Manager) *Route {
 	return &Route{
 		RouteConf: conf,
 		tree:      NewMethodTrees(),
@@ -144,8 +154,33 @@ func (r *Route) Group(relativePath string,handlers ...HandlerFunc) Router {
 	router := &Route{
 		RouteConf: r.RouteConf,
 		tree:      r.tree,
+		manager:   r.manager,
+		parent:    r,
 		basePath:  r.mergeAbsolutePath(relativePath),
 		Handlers:  r.mergeHandlers(handlers),
+		policies:  r.policies,
+		sessionErrorHandler: r.sessionErrorHandler,
+	}
+	return router
+}
+
+//声明授权策略，返回一个路径相同的路由器，通过它注册的路由与分组都需要满足所有的策略
+//没有通过认证时返回401，不满足策略时返回403
+//	admin := r.Group("/admin",auth.Basic(conf)).Authorize(route.RequireRoles("admin"))
+func (r *Route) Authorize(policies ...*Policy) Router {
+	handlers := make([]HandlerFunc,len(policies))
+	for i, p := range policies {
+		handlers[i] = p.Handler()
+	}
+	router := &Route{
+		RouteConf: r.RouteConf,
+		tree:      r.tree,
+		manager:   r.manager,
+		parent:    r,
+		basePath:  r.basePath,
+		Handlers:  r.mergeHandlers(handlers),
+		policies:  append(append([]*Policy(nil),r.policies...),policies...),
+		sessionErrorHandler: r.sessionErrorHandler,
 	}
 	return router
 }
@@ -153,7 +188,7 @@ func (r *Route) Group(relativePath string,handlers ...HandlerFunc) Router {
 //静态文件路由
 func (r *Route) StaticFile(relative,path string) Router {
 	handle := func(c *Context) {
-		http.ServeFile(c.Writer,c.Request,path)
+		http.ServeFile(c.Writer,c.Request(),path)
 	}
 	r.GET(relative, handle)
 	r.HEAD(relative,handle)
@@ -169,14 +204,29 @@ func (r *Route) Use(handles ...HandlerFunc) Router {
 
 
 func (r *Route) Run(rw http.ResponseWriter,req *http.Request) {
-	method := req.Method
-	path := req.URL.Path
+	r.Serve(rw,NewRequest(req))
+}
+
+//处理任意引擎的请求，net/http与fast引擎最终都会走到这里
+func (r *Route) Serve(rw http.ResponseWriter,req Request) {
+	method := req.Method()
+	path := req.Path()
 	//这个tsr到底是干啥的
 	handlers, ps, _ := r.tree.GetValues(method, path,nil, r.RouteConf.PathUnescape)
 
 	ctx := ctxpool.Get().(*Context)
-	ctx.Reset(rw,req,r,handlers,ps)
+	ctx.reset(rw,req,r,handlers,ps)
+	//handler panic时不保存session，只释放锁，panic继续向上传递
+	completed := false
+	defer func() {
+		if !completed {
+			ctx.releaseSession()
+		}
+	}()
 	ctx.Next()
+	completed = true
+	ctx.saveSession()
+	ctx.Release()
 	ctxpool.Put(ctx)
 	//TODO：其他处理
 }
@@ -185,10 +235,43 @@ func (r *Route)BasePath() string {
 	return r.basePath
 }
 
+//Context.Session默认使用的session管理器，之后创建的分组也会使用它
+func (r *Route) SetSessionManager(manager session.Manager) {
+	r.manager = manager
+}
+
+func (r *Route) SessionManager() session.Manager {
+	return r.manager
+}
+
+//调用链结束时保存session失败的处理函数，例如乐观锁冲突、cookie过大或者写文件失败，之后创建的分组也会使用它
+//这时响应通常已经写出，只能记录错误，没有设置时使用log输出
+//需要让客户端知道保存失败的handler应当在写响应之前调用Context.SaveSession
+func (r *Route) SetSessionErrorHandler(fn func(c *Context,err error)) {
+	r.sessionErrorHandler = fn
+}
+
+//Context上签名与加密cookie使用的密钥，所有的下级分组都会使用它，无论分组是在之前还是之后创建的
+//分组设置了自己的密钥时只对这个分组生效，轮换密钥时调用Keyring.Rotate，不需要重新设置
+func (r *Route) SetKeyring(k *Keyring) {
+	r.keyring = k
+}
+
+//请求时沿着上级查找，返回最近设置的密钥
+func (r *Route) Keyring() *Keyring {
+	for ; r != nil; r = r.parent {
+		if r.keyring != nil {
+			return r.keyring
+		}
+	}
+	return nil
+}
+
 func (r *Route) handle (method,relativePath string,handles []HandlerFunc) Router {
 	p := r.mergeAbsolutePath(relativePath)
 	chain := r.mergeHandlers(handles)
 	r.tree.AddRouter(method,p,chain)
+	r.tree.addInfo(RouteInfo{Method:method,Path:p,Handlers:len(chain),Policies:policyList(r.policies)})
 	return r.returnObj()
 }
 
@@ -200,7 +283,7 @@ func (r *Route)mergeHandlers(chain []HandlerFunc) []HandlerFunc {
 	}
 	mergedHandlers := make([]HandlerFunc,size)
 	copy(mergedHandlers,r.Handlers)
-	copy(mergedHandlers,chain)
+	copy(mergedHandlers[len(r.Handlers):],chain)
 	return mergedHandlers
 }
 
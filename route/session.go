@@ -0,0 +1,174 @@
+package route
+
+import (
+	"errors"
+	"log"
+	"mux/session"
+	"net/http"
+)
+
+var ErrNoSessionManager = errors.New("route: session manager is not configured")
+
+//session中间件，分组可以使用自己的manager与配置
+//中间件只是记录manager，第一次调用Context.Session时才会读取或者创建session，调用链结束后保存修改
+//	admin := r.Group("/admin",route.SessionHandler(manager,&session.ManagerRunConfig{...}))
+func SessionHandler(manager session.Manager,confs ...*session.ManagerRunConfig) HandlerFunc {
+	return func(c *Context) {
+		c.saveSession()
+		c.sessionManager = manager
+		c.sessionConfs = confs
+		c.Next()
+		c.saveSession()
+	}
+}
+
+func (c *Context) manager() session.Manager {
+	if c.sessionManager != nil {
+		return c.sessionManager
+	}
+	if c.route != nil {
+		return c.route.manager
+	}
+	return nil
+}
+
+//获取当前请求的session，不存在时会创建一个新的session
+//等待session的锁超时时会返回503并结束调用链，handler只需要直接返回
+func (c *Context) Session() (session.Sessioner,error) {
+	if c.session != nil {
+		return c.session,nil
+	}
+	manager := c.manager()
+	if manager == nil {
+		return nil,ErrNoSessionManager
+	}
+	sess, err := manager.Session(c.Writer,c.Request(),c.sessionConfs...)
+	if err != nil {
+		c.sessionReadFailed(err)
+		return nil,err
+	}
+	c.session = sess
+	return sess,nil
+}
+
+//只读取请求中已经存在的session，没有时返回session.ErrSessionNotExist，不会为匿名的访问者创建session
+//manager没有实现session.ExistingSessioner时与Session相同
+func (c *Context) ExistingSession() (session.Sessioner,error) {
+	if c.session != nil {
+		return c.session,nil
+	}
+	manager := c.manager()
+	if manager == nil {
+		return nil,ErrNoSessionManager
+	}
+	existing, ok := manager.(session.ExistingSessioner)
+	if !ok {
+		return c.Session()
+	}
+	sess, err := existing.ExistingSession(c.Writer,c.Request(),c.sessionConfs...)
+	if err != nil {
+		c.sessionReadFailed(err)
+		return nil,err
+	}
+	c.session = sess
+	return sess,nil
+}
+
+//等待session的锁超时时返回503并结束调用链
+func (c *Context) sessionReadFailed(err error) {
+	if err == session.ErrLockTimeout {
+		//同一个session的其他请求一直没有结束，让客户端稍后重试
+		c.Writer.Header().Set("Retry-After","1")
+		c.Writer.WriteHeader(http.StatusServiceUnavailable)
+		c.Abort()
+	}
+}
+
+//更换session id，session中的数据保持不变，登录成功后应当调用它防止session固定攻击
+func (c *Context) RegenerateSession() (string,error) {
+	manager := c.manager()
+	if manager == nil {
+		return "",ErrNoSessionManager
+	}
+	//还没有读取session时先读取，保证session存在
+	cur, err := c.Session()
+	if err != nil {
+		return "",err
+	}
+	if re, ok := cur.(session.Regenerator); ok {
+		return re.Regenerate()
+	}
+	if err := c.SaveSession(); err != nil {
+		return "",err
+	}
+	sid, err := manager.ReSessionID(c.Writer,c.Request(),c.sessionConfs...)
+	if err == session.ErrSessionNotExist {
+		//请求中没有sid，session是这次请求新创建的，id还没有发给客户端，不需要更换
+		c.session = cur
+		return cur.ID(),nil
+	}
+	if err != nil {
+		return "",err
+	}
+	sess, err := manager.SessionByID(sid)
+	if err != nil {
+		return "",err
+	}
+	c.session = sess
+	return sid,nil
+}
+
+//把当前的session和用户关联起来，用户修改密码时可以通过Manager.DestroyUserSessions让所有的session失效
+func (c *Context) SetSessionUser(uid string) error {
+	sess, err := c.Session()
+	if err != nil {
+		return err
+	}
+	return c.manager().BindUser(sess,uid)
+}
+
+//保存session的修改并释放锁，返回保存失败的原因，没有读取过session时什么都不做
+//调用链结束时会自动保存，但那时响应通常已经写出，需要根据保存结果返回错误的handler应当在写响应之前调用它
+//保存之后再调用Session会重新读取session
+func (c *Context) SaveSession() error {
+	if c.session == nil {
+		return nil
+	}
+	var err error
+	if saver, ok := c.session.(session.Saver); ok {
+		err = saver.Save()
+	}
+	c.releaseSession()
+	return err
+}
+
+//调用链结束时保存session，失败时交给Route.SetSessionErrorHandler设置的函数，没有设置时写日志
+func (c *Context) saveSession() {
+	err := c.SaveSession()
+	if err == nil {
+		return
+	}
+	if c.route != nil && c.route.sessionErrorHandler != nil {
+		c.route.sessionErrorHandler(c,err)
+		return
+	}
+	log.Printf("route: failed to save session for %s %s: %v",c.req.Method(),c.req.Path(),err)
+}
+
+//不保存修改，只释放session的锁
+func (c *Context) releaseSession() {
+	if releaser, ok := c.session.(session.Releaser); ok {
+		releaser.Release()
+	}
+	c.session = nil
+}
+
+//删除当前请求的session，例如退出登录，已经读取的session不会再保存
+func (c *Context) DestroySession() error {
+	manager := c.manager()
+	if manager == nil {
+		return ErrNoSessionManager
+	}
+	c.releaseSession()
+	return manager.DestroySession(c.Writer,c.Request(),c.sessionConfs...)
+}
@@ -0,0 +1,75 @@
+package route
+
+import (
+	"errors"
+	"mux/session"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var errSave = errors.New("save failed")
+
+//Save总是失败的session，模拟乐观锁冲突或者写入失败
+type failingManager struct{ session.Manager }
+
+type failingSession struct{ session.Sessioner }
+
+func (failingSession) Save() error { return errSave }
+
+func (m failingManager) Session(w http.ResponseWriter, r *http.Request, confs ...*session.ManagerRunConfig) (session.Sessioner, error) {
+	sess, err := m.Manager.Session(w, r, confs...)
+	if err != nil {
+		return nil, err
+	}
+	return failingSession{sess}, nil
+}
+
+func TestSaveSessionError(t *testing.T) {
+	manager, err := session.NewManage(&session.ManagerConf{
+		ProviderName:    "memory",
+		CookieName:      "sid",
+		EnableSetCookie: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(&Config{}, failingManager{manager})
+	var handled []error
+	r.SetSessionErrorHandler(func(c *Context, err error) {
+		handled = append(handled, err)
+	})
+	//handler在写响应之前保存，可以返回错误
+	r.GET("/explicit", func(c *Context) {
+		sess, _ := c.Session()
+		sess.Set("k", "v")
+		if err := c.SaveSession(); err != nil {
+			c.WriteString(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.WriteString(http.StatusOK, "ok")
+	})
+	//调用链结束时自动保存，错误交给SetSessionErrorHandler
+	r.GET("/auto", func(c *Context) {
+		sess, _ := c.Session()
+		sess.Set("k", "v")
+		c.WriteString(http.StatusOK, "ok")
+	})
+
+	w := httptest.NewRecorder()
+	r.Run(w, httptest.NewRequest("GET", "/explicit", nil))
+	if w.Code != http.StatusInternalServerError || len(handled) != 0 {
+		t.Fatalf("explicit: code %d, handled %v", w.Code, handled)
+	}
+	r.Run(httptest.NewRecorder(), httptest.NewRequest("GET", "/auto", nil))
+	if len(handled) != 1 || handled[0] != errSave {
+		t.Fatalf("auto: handled %v", handled)
+	}
+	//RegenerateSession在更换sid之前保存，失败时返回错误
+	r.GET("/regenerate", func(c *Context) {
+		if _, err := c.RegenerateSession(); err != errSave {
+			t.Errorf("RegenerateSession = %v, want %v", err, errSave)
+		}
+	})
+	r.Run(httptest.NewRecorder(), httptest.NewRequest("GET", "/regenerate", nil))
+}
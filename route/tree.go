@@ -13,6 +13,8 @@ func NewMethodTrees() *MethodTrees {
 
 type MethodTrees struct {
 	mts []*methodTree
+	//注册的路由，用于Routes
+	infos []RouteInfo
 }
 
 //添加路由
@@ -0,0 +1,254 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+//把session中的值序列化后保存，memory以外的provider都使用它
+//Encode应当保存值的类型，Decode返回的值和Encode传入的值类型相同
+//自定义的类型需要先调用RegisterType
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+//数据无法解析，例如更换codec之前保存的数据或者删除了某个类型，provider会把它当作空的session
+var ErrDecode = errors.New("session: failed to decode session data")
+
+var (
+	Gob     Codec = gobCodec{}
+	JSON    Codec = jsonCodec{}
+	MsgPack Codec = msgpackCodec{}
+)
+
+//provider默认使用的codec，应当在启动时设置
+var DefaultCodec = Gob
+
+var (
+	typesMu sync.RWMutex
+	types   = make(map[string]reflect.Type)
+)
+
+func init() {
+	gob.Register(map[interface{}]interface{}{})
+	gob.Register([]interface{}{})
+	for _, v := range []interface{}{
+		false, int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0), "", []byte(nil), []string(nil), []int(nil), []int64(nil),
+		map[string]string(nil), map[string]interface{}(nil), time.Time{}, time.Duration(0),
+	} {
+		RegisterType(v)
+	}
+}
+
+//注册session中保存的自定义类型，所有的codec都可以使用
+//	session.RegisterType(User{})
+func RegisterType(v interface{}) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		panic("session: RegisterType of nil")
+	}
+	typesMu.Lock()
+	types[typeName(t)] = t
+	typesMu.Unlock()
+	gob.Register(v)
+}
+
+func typeName(t reflect.Type) string {
+	if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
+}
+
+func lookupType(name string) (reflect.Type, bool) {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	t, ok := types[name]
+	return t, ok
+}
+
+func decodeError(err error) error {
+	return fmt.Errorf("%w: %v", ErrDecode, err)
+}
+
+//把解码的结果转换成session的数据，数据损坏时返回ErrDecode
+func DecodeValues(c Codec, data []byte) (map[interface{}]interface{}, error) {
+	v, err := c.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return make(map[interface{}]interface{}), nil
+	}
+	values, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, decodeError(fmt.Errorf("unexpected type %T", v))
+	}
+	return values, nil
+}
+
+type gobCodec struct{}
+
+type gobPayload struct {
+	V interface{}
+}
+
+func (gobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(gobPayload{V: v}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte) (interface{}, error) {
+	var p gobPayload
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&p); err != nil {
+		return nil, decodeError(err)
+	}
+	return p.V, nil
+}
+
+//json和msgpack本身不保存类型，值被包装成{类型名,值}
+//map[interface{}]interface{}与[]interface{}中的每个元素都会分别包装
+const (
+	mapType  = "map"
+	listType = "list"
+)
+
+type jsonCodec struct{}
+
+type jsonValue struct {
+	T string          `json:"t"`
+	V json.RawMessage `json:"v,omitempty"`
+}
+
+func (c jsonCodec) Encode(v interface{}) ([]byte, error) {
+	jv, err := c.wrap(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jv)
+}
+
+func (c jsonCodec) wrap(v interface{}) (*jsonValue, error) {
+	var raw interface{}
+	name := ""
+	switch v := v.(type) {
+	case nil:
+		return &jsonValue{}, nil
+	case map[interface{}]interface{}:
+		name = mapType
+		pairs := make([][2]*jsonValue, 0, len(v))
+		for k, val := range v {
+			jk, err := c.wrap(k)
+			if err != nil {
+				return nil, err
+			}
+			jval, err := c.wrap(val)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, [2]*jsonValue{jk, jval})
+		}
+		raw = pairs
+	case []interface{}:
+		name = listType
+		list := make([]*jsonValue, len(v))
+		for i, e := range v {
+			je, err := c.wrap(e)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = je
+		}
+		raw = list
+	default:
+		name = typeName(reflect.TypeOf(v))
+		raw = v
+	}
+	bs, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonValue{T: name, V: bs}, nil
+}
+
+func (c jsonCodec) Decode(data []byte) (interface{}, error) {
+	var jv jsonValue
+	if err := json.Unmarshal(data, &jv); err != nil {
+		return nil, decodeError(err)
+	}
+	return c.unwrap(&jv)
+}
+
+func (c jsonCodec) unwrap(jv *jsonValue) (interface{}, error) {
+	switch jv.T {
+	case "":
+		return nil, nil
+	case mapType:
+		var pairs [][2]*jsonValue
+		if err := json.Unmarshal(jv.V, &pairs); err != nil {
+			return nil, decodeError(err)
+		}
+		m := make(map[interface{}]interface{}, len(pairs))
+		for _, p := range pairs {
+			if p[0] == nil || p[1] == nil {
+				return nil, decodeError(errors.New("invalid map entry"))
+			}
+			k, err := c.unwrap(p[0])
+			if err != nil {
+				return nil, err
+			}
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return nil, decodeError(fmt.Errorf("key of type %T is not comparable", k))
+			}
+			v, err := c.unwrap(p[1])
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case listType:
+		var list []*jsonValue
+		if err := json.Unmarshal(jv.V, &list); err != nil {
+			return nil, decodeError(err)
+		}
+		arr := make([]interface{}, len(list))
+		for i, e := range list {
+			if e == nil {
+				continue
+			}
+			v, err := c.unwrap(e)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = v
+		}
+		return arr, nil
+	}
+	//没有注册的类型按照json的默认规则解析，不会丢掉整个session
+	t, ok := lookupType(jv.T)
+	if !ok {
+		var v interface{}
+		if err := json.Unmarshal(jv.V, &v); err != nil {
+			return nil, decodeError(err)
+		}
+		return v, nil
+	}
+	ptr := reflect.New(t)
+	if err := json.Unmarshal(jv.V, ptr.Interface()); err != nil {
+		return nil, decodeError(err)
+	}
+	return ptr.Elem().Interface(), nil
+}
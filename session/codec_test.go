@@ -0,0 +1,75 @@
+package session
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type codecUser struct {
+	Name  string
+	Age   int
+	Tags  []string
+	Login time.Time
+	Inner *codecUser
+}
+
+func init() {
+	RegisterType(codecUser{})
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	login := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	values := map[interface{}]interface{}{
+		"name":  "mux",
+		"count": 3,
+		"ratio": 0.5,
+		"ok":    true,
+		"nil":   nil,
+		"bytes": []byte{1, 2, 3},
+		"list":  []interface{}{"a", int64(-300), uint8(7)},
+		"user":  codecUser{Name: "u", Age: 18, Tags: []string{"x"}, Login: login, Inner: &codecUser{Name: "i"}},
+		7:       "int key",
+		"dur":   time.Second,
+	}
+	for name, c := range map[string]Codec{"gob": Gob, "json": JSON, "msgpack": MsgPack} {
+		bs, err := c.Encode(values)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := DecodeValues(c, bs)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, values) {
+			t.Errorf("%s:\n got %#v\nwant %#v", name, got, values)
+		}
+	}
+}
+
+func TestCodecCorrupted(t *testing.T) {
+	for name, c := range map[string]Codec{"gob": Gob, "json": JSON, "msgpack": MsgPack} {
+		if _, err := DecodeValues(c, []byte{0xdd, 0xff, 0xff, 0xff, 0xff, 1}); !errors.Is(err, ErrDecode) {
+			t.Errorf("%s: err = %v, want ErrDecode", name, err)
+		}
+	}
+}
+
+//没有注册的类型解析成通用的结构，不会让整个session失效
+func TestCodecUnregistered(t *testing.T) {
+	type unknown struct{ A int }
+	for name, c := range map[string]Codec{"json": JSON, "msgpack": MsgPack} {
+		bs, err := c.Encode(map[interface{}]interface{}{"u": unknown{A: 1}, "k": "v"})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := DecodeValues(c, bs)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got["k"] != "v" || got["u"] == nil {
+			t.Errorf("%s: got %#v", name, got)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package cookie
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mux/session"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	//cookie被篡改、使用了未知的密钥或者格式错误
+	ErrInvalid = errors.New("session: invalid cookie")
+	ErrExpired = errors.New("session: cookie expired")
+	//加密后的数据超过了MaxCookieSize*MaxCookies
+	ErrTooLarge = errors.New("session: session is too large for cookies")
+	//session保存在客户端，不能通过sid查找，也不能按照用户删除
+	ErrNotSupported = errors.New("session: not supported by the cookie store")
+)
+
+type Config struct {
+	//cookie的名称，数据太大时拆分为name、name_1、name_2……，default:mux_session
+	Name string
+	//AES的密钥，长度为16、24或者32字节，第一个用于加密，所有的都可以用于解密
+	//轮换密钥时把新的密钥放在第一个，旧的密钥保留到旧的cookie都过期为止
+	Keys [][]byte
+	//session的有效期，写在加密的数据中，客户端无法修改，default:24h
+	MaxAge time.Duration
+	//cookie在浏览器中的存活时间，单位秒，0为浏览器关闭时清理，default:0
+	CookieMaxAge int
+	Path         string
+	Domain       string
+	Secure       bool
+	HTTPOnly     bool
+	SameSite     http.SameSite
+	//单个cookie值的最大字节数，default:4000
+	MaxCookieSize int
+	//最多拆分成几个cookie，default:4
+	MaxCookies int
+	//序列化session使用的codec，default:session.DefaultCodec
+	Codec session.Codec
+}
+
+//把session加密后保存在客户端cookie中的Manager，服务端不保存任何状态
+//
+//	store, err := cookie.New(cookie.Config{Keys: [][]byte{key}})
+//	m.SetSessionManager(store)
+//
+//修改session时会立即重写响应中的Set-Cookie，所以应当在写入响应体之前修改session
+type Store struct {
+	conf  Config
+	aeads []cipher.AEAD
+}
+
+func New(conf Config) (*Store, error) {
+	if len(conf.Keys) == 0 {
+		return nil, errors.New("session: cookie store requires at least one key")
+	}
+	if conf.Name == "" {
+		conf.Name = "mux_session"
+	}
+	if conf.MaxAge <= 0 {
+		conf.MaxAge = 24 * time.Hour
+	}
+	if conf.MaxCookieSize <= 0 {
+		conf.MaxCookieSize = 4000
+	}
+	if conf.MaxCookies <= 0 {
+		conf.MaxCookies = 4
+	}
+	if conf.Codec == nil {
+		conf.Codec = session.DefaultCodec
+	}
+	s := &Store{conf: conf}
+	for i, key := range conf.Keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("session: key %d: %v", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	return s, nil
+}
+
+//cookie中的数据不会被manager的配置覆盖，confs会被忽略
+func (s *Store) Session(w http.ResponseWriter, r *http.Request, confs ...*session.ManagerRunConfig) (session.Sessioner, error) {
+	sess := &Session{store: s, w: w, r: r}
+	p, err := s.read(r)
+	if err != nil {
+		sess.id = session.NewSID(0)
+		sess.values = make(map[interface{}]interface{})
+		return sess, nil
+	}
+	sess.id, sess.values, sess.expires = p.ID, p.Values, p.Expires
+	//过了一半有效期时刷新，活跃的用户不会过期
+	if time.Until(time.Unix(p.Expires, 0)) < s.conf.MaxAge/2 {
+		sess.write()
+	}
+	return sess, nil
+}
+
+//cookie中没有有效的session时返回session.ErrSessionNotExist
+func (s *Store) ExistingSession(w http.ResponseWriter, r *http.Request, confs ...*session.ManagerRunConfig) (session.Sessioner, error) {
+	if _, err := s.read(r); err != nil {
+		return nil, session.ErrSessionNotExist
+	}
+	return s.Session(w, r, confs...)
+}
+
+func (s *Store) ReSessionID(w http.ResponseWriter, r *http.Request, confs ...*session.ManagerRunConfig) (string, error) {
+	sess, err := s.Session(w, r, confs...)
+	if err != nil {
+		return "", err
+	}
+	return sess.(*Session).Regenerate()
+}
+
+func (s *Store) SessionID() (string, error) {
+	sid := session.NewSID(0)
+	if sid == "" {
+		return "", errors.New("session: failed to create sid")
+	}
+	return sid, nil
+}
+
+func (s *Store) SessionByID(sid string) (session.Sessioner, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *Store) BindUser(sess session.Sessioner, uid string) error {
+	return ErrNotSupported
+}
+
+//服务端没有保存session，无法让已经发出的cookie失效，只能等待它过期或者轮换密钥
+func (s *Store) DestroyUserSessions(uid string) error {
+	return ErrNotSupported
+}
+
+//删除请求中所有的session cookie，已经被复制走的cookie在过期之前仍然有效
+func (s *Store) DestroySession(w http.ResponseWriter, r *http.Request, confs ...*session.ManagerRunConfig) error {
+	h := w.Header()
+	h["Set-Cookie"] = s.removeOwn(h["Set-Cookie"])
+	for i := 0; i < s.conf.MaxCookies; i++ {
+		name := s.chunkName(i)
+		if _, err := r.Cookie(name); err == nil {
+			http.SetCookie(w, s.cookie(name, "", -1))
+		}
+	}
+	return nil
+}
+
+var _ session.Manager = (*Store)(nil)
+
+type payload struct {
+	ID      string
+	Expires int64
+	Values  map[interface{}]interface{}
+}
+
+func (s *Store) chunkName(i int) string {
+	if i == 0 {
+		return s.conf.Name
+	}
+	return s.conf.Name + "_" + strconv.Itoa(i)
+}
+
+//第一个cookie的值为"拆分的数量.数据"
+func (s *Store) read(r *http.Request) (*payload, error) {
+	first, err := r.Cookie(s.conf.Name)
+	if err != nil {
+		return nil, err
+	}
+	i := strings.IndexByte(first.Value, '.')
+	if i < 0 {
+		return nil, ErrInvalid
+	}
+	n, err := strconv.Atoi(first.Value[:i])
+	if err != nil || n < 1 || n > s.conf.MaxCookies {
+		return nil, ErrInvalid
+	}
+	var b strings.Builder
+	b.WriteString(first.Value[i+1:])
+	for j := 1; j < n; j++ {
+		c, err := r.Cookie(s.chunkName(j))
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		b.WriteString(c.Value)
+	}
+	return s.decode(b.String())
+}
+
+//payload按照map交给codec，json与msgpack也可以使用
+func (s *Store) encode(p *payload) (string, error) {
+	plain, err := s.conf.Codec.Encode(map[interface{}]interface{}{
+		"id":      p.ID,
+		"expires": p.Expires,
+		"values":  p.Values,
+	})
+	if err != nil {
+		return "", err
+	}
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	//cookie的名称作为附加数据，一个cookie的值不能被挪到另一个cookie中使用
+	sealed := aead.Seal(nonce, nonce, plain, []byte(s.conf.Name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Store) decode(value string) (*payload, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	for _, aead := range s.aeads {
+		if len(sealed) < aead.NonceSize() {
+			return nil, ErrInvalid
+		}
+		nonce, data := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, data, []byte(s.conf.Name))
+		if err != nil {
+			continue
+		}
+		//更换了codec或者类型之后无法解析的旧cookie当作无效
+		m, err := session.DecodeValues(s.conf.Codec, plain)
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		p := &payload{}
+		p.ID, _ = m["id"].(string)
+		p.Expires, _ = m["expires"].(int64)
+		p.Values, _ = m["values"].(map[interface{}]interface{})
+		if p.ID == "" {
+			return nil, ErrInvalid
+		}
+		if time.Now().Unix() >= p.Expires {
+			return nil, ErrExpired
+		}
+		if p.Values == nil {
+			p.Values = make(map[interface{}]interface{})
+		}
+		return p, nil
+	}
+	return nil, ErrInvalid
+}
+
+//保存在cookie中的session，每次修改都会重写响应中的Set-Cookie
+type Session struct {
+	store   *Store
+	w       http.ResponseWriter
+	r       *http.Request
+	mu      sync.RWMutex
+	id      string
+	values  map[interface{}]interface{}
+	expires int64
+	err     error
+}
+
+func (s *Session) Get(key interface{}) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+func (s *Session) Set(key, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = val
+	s.write()
+}
+
+func (s *Session) Del(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.write()
+}
+
+func (s *Session) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.id
+}
+
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[interface{}]interface{})
+	s.write()
+}
+
+//session的过期时间
+func (s *Session) Expires() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Unix(s.expires, 0)
+}
+
+//更换sid，数据保持不变
+func (s *Session) Regenerate() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := session.NewSID(0)
+	if id == "" {
+		return "", errors.New("session: failed to create sid")
+	}
+	s.id = id
+	s.write()
+	return id, s.err
+}
+
+//cookie已经在修改时写入，这里只返回写入时的错误，例如ErrTooLarge
+func (s *Session) Save() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.err
+}
+
+//加密并写入cookie，调用者需要持有写锁
+func (s *Session) write() {
+	st := s.store
+	s.expires = time.Now().Add(st.conf.MaxAge).Unix()
+	value, err := st.encode(&payload{ID: s.id, Expires: s.expires, Values: s.values})
+	if err != nil {
+		s.err = err
+		return
+	}
+	size := st.conf.MaxCookieSize
+	var chunks []string
+	//第一个cookie的值前面有"n."，先按照最多的数量预留位置
+	first := size - len(strconv.Itoa(st.conf.MaxCookies)) - 1
+	if len(value) <= first {
+		chunks = []string{value}
+	} else {
+		chunks = append(chunks, value[:first])
+		for rest := value[first:]; len(rest) > 0; {
+			n := size
+			if n > len(rest) {
+				n = len(rest)
+			}
+			chunks = append(chunks, rest[:n])
+			rest = rest[n:]
+		}
+	}
+	if len(chunks) > st.conf.MaxCookies {
+		s.err = ErrTooLarge
+		return
+	}
+	s.err = nil
+	chunks[0] = strconv.Itoa(len(chunks)) + "." + chunks[0]
+
+	h := s.w.Header()
+	h["Set-Cookie"] = st.removeOwn(h["Set-Cookie"])
+	for i, v := range chunks {
+		http.SetCookie(s.w, st.cookie(st.chunkName(i), v, st.conf.CookieMaxAge))
+	}
+	//删除之前拆分出来的多余的cookie
+	for i := len(chunks); i < st.conf.MaxCookies; i++ {
+		name := st.chunkName(i)
+		if _, err := s.r.Cookie(name); err == nil {
+			http.SetCookie(s.w, st.cookie(name, "", -1))
+		}
+	}
+}
+
+func (s *Store) cookie(name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     s.conf.Path,
+		Domain:   s.conf.Domain,
+		MaxAge:   maxAge,
+		Secure:   s.conf.Secure,
+		HttpOnly: s.conf.HTTPOnly,
+		SameSite: s.conf.SameSite,
+	}
+}
+
+//去掉之前写入的session cookie，保留其他的cookie
+func (s *Store) removeOwn(lines []string) []string {
+	kept := lines[:0]
+	for _, line := range lines {
+		name := line
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			name = line[:i]
+		}
+		if s.isOwn(name) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return kept
+}
+
+func (s *Store) isOwn(name string) bool {
+	if name == s.conf.Name {
+		return true
+	}
+	if !strings.HasPrefix(name, s.conf.Name+"_") {
+		return false
+	}
+	_, err := strconv.Atoi(name[len(s.conf.Name)+1:])
+	return err == nil
+}
@@ -0,0 +1,132 @@
+package cookie
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+//把响应中的cookie带到下一个请求中
+func nextRequest(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
+
+func TestRoundTrip(t *testing.T) {
+	s, err := New(Config{Keys: [][]byte{key1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	sess, _ := s.Session(w, httptest.NewRequest("GET", "/", nil))
+	sess.Set("uid", 42)
+	id := sess.ID()
+
+	got, _ := s.Session(httptest.NewRecorder(), nextRequest(w))
+	if got.Get("uid") != 42 || got.ID() != id {
+		t.Fatalf("uid = %v, id = %q, want 42, %q", got.Get("uid"), got.ID(), id)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, _ := New(Config{Keys: [][]byte{key1}})
+	w := httptest.NewRecorder()
+	sess, _ := old.Session(w, httptest.NewRequest("GET", "/", nil))
+	sess.Set("k", "v")
+
+	rotated, _ := New(Config{Keys: [][]byte{key2, key1}})
+	got, _ := rotated.Session(httptest.NewRecorder(), nextRequest(w))
+	if got.Get("k") != "v" {
+		t.Fatal("cookie encrypted with the old key was not accepted")
+	}
+
+	dropped, _ := New(Config{Keys: [][]byte{key2}})
+	got, _ = dropped.Session(httptest.NewRecorder(), nextRequest(w))
+	if got.Get("k") != nil {
+		t.Fatal("cookie encrypted with a removed key was accepted")
+	}
+}
+
+func TestTampered(t *testing.T) {
+	s, _ := New(Config{Keys: [][]byte{key1}})
+	w := httptest.NewRecorder()
+	sess, _ := s.Session(w, httptest.NewRequest("GET", "/", nil))
+	sess.Set("admin", false)
+
+	c := w.Result().Cookies()[0]
+	b := []byte(c.Value)
+	b[len(b)-3] ^= 1
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: c.Name, Value: string(b)})
+	if _, err := s.read(r); err != ErrInvalid {
+		t.Fatalf("read tampered cookie: %v", err)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	s, _ := New(Config{Keys: [][]byte{key1}, MaxAge: time.Second})
+	value, _ := s.encode(&payload{ID: "x", Expires: time.Now().Add(-time.Second).Unix()})
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: s.conf.Name, Value: "1." + value})
+	if _, err := s.read(r); err != ErrExpired {
+		t.Fatalf("read expired cookie: %v", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	s, _ := New(Config{Keys: [][]byte{key1}, MaxCookieSize: 200, MaxCookies: 8})
+	w := httptest.NewRecorder()
+	sess, _ := s.Session(w, httptest.NewRequest("GET", "/", nil))
+	big := strings.Repeat("x", 500)
+	sess.Set("big", big)
+	if err := sess.(*Session).Save(); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) < 3 {
+		t.Fatalf("got %d cookies, want the session split", len(cookies))
+	}
+	for _, c := range cookies {
+		if len(c.Value) > 200 {
+			t.Fatalf("cookie %s is %d bytes", c.Name, len(c.Value))
+		}
+	}
+	got, _ := s.Session(httptest.NewRecorder(), nextRequest(w))
+	if got.Get("big") != big {
+		t.Fatal("split session was not joined")
+	}
+
+	//变小之后多余的cookie被删除
+	w2 := httptest.NewRecorder()
+	got.(*Session).w = w2
+	got.Del("big")
+	kept, deleted := 0, 0
+	for _, c := range w2.Result().Cookies() {
+		if c.MaxAge < 0 {
+			deleted++
+		} else {
+			kept++
+		}
+	}
+	if kept+deleted != len(cookies) || kept >= len(cookies) {
+		t.Fatalf("kept %d and deleted %d of %d cookies", kept, deleted, len(cookies))
+	}
+
+	sess.Set("big", strings.Repeat("y", 5000))
+	if err := sess.(*Session).Save(); err != ErrTooLarge {
+		t.Fatalf("Save = %v, want ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,346 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"mux/session"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//注册到session中的file provider，默认保存在临时目录中，可以通过SetDir修改
+var Default = NewFile(filepath.Join(os.TempDir(), "mux-session"))
+
+func init() {
+	session.Register("file", Default)
+}
+
+var ErrNotFound = errors.New("session: session not found")
+
+//写入时使用的临时文件前缀，GC会清理残留的临时文件
+const tmpPrefix = ".tmp-"
+
+//文件session存储，每个session一个文件，服务重启后session仍然有效
+//文件名是sid的sha256，sid中的任何字符都不会影响文件路径
+//写入时先写临时文件再rename，读取的一方不会看到写了一半的文件
+type File struct {
+	mu    sync.RWMutex
+	dir   string
+	codec session.Codec
+
+	locksMu sync.Mutex
+	locks   map[string]*fileLock
+	//Lock使用的请求级别的锁，locks只在读写文件时使用
+	sessionLocks session.LocalLocker
+}
+
+//同一个文件的读写是串行的，没有人使用时删除
+type fileLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func NewFile(dir string) *File {
+	return &File{dir: dir, locks: make(map[string]*fileLock)}
+}
+
+//修改保存session的目录，目录不存在时会在第一次写入时创建
+func (f *File) SetDir(dir string) {
+	f.mu.Lock()
+	f.dir = dir
+	f.mu.Unlock()
+}
+
+//修改序列化session使用的codec，为nil时使用session.DefaultCodec
+func (f *File) SetCodec(c session.Codec) {
+	f.mu.Lock()
+	f.codec = c
+	f.mu.Unlock()
+}
+
+func (f *File) getCodec() session.Codec {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.codec == nil {
+		return session.DefaultCodec
+	}
+	return f.codec
+}
+
+func (f *File) Dir() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.dir
+}
+
+//sid到文件名的映射
+func (f *File) path(sid string) string {
+	sum := sha256.Sum256([]byte(sid))
+	return filepath.Join(f.Dir(), hex.EncodeToString(sum[:]))
+}
+
+func (f *File) lock(sid string) func() {
+	f.locksMu.Lock()
+	l, ok := f.locks[sid]
+	if !ok {
+		l = &fileLock{}
+		f.locks[sid] = l
+	}
+	l.refs++
+	f.locksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		f.locksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(f.locks, sid)
+		}
+		f.locksMu.Unlock()
+	}
+}
+
+func (f *File) Create(sid string) (session.Sessioner, error) {
+	unlock := f.lock(sid)
+	defer unlock()
+	if sess, err := f.read(sid); err != ErrNotFound {
+		return sess, err
+	}
+	sess := newSession(f, sid, make(map[interface{}]interface{}))
+	if err := f.write(sid, sess.values); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (f *File) Read(sid string) (session.Sessioner, error) {
+	unlock := f.lock(sid)
+	defer unlock()
+	return f.read(sid)
+}
+
+//调用者需要持有sid的锁
+func (f *File) read(sid string) (*Session, error) {
+	name := f.path(sid)
+	bs, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	//无法解析的旧数据当作空的session，下次保存时覆盖
+	values, err := session.DecodeValues(f.getCodec(), bs)
+	if err != nil {
+		values = make(map[interface{}]interface{})
+	}
+	//更新修改时间，GC按照它判断session是否过期
+	now := time.Now()
+	os.Chtimes(name, now, now)
+	return newSession(f, sid, values), nil
+}
+
+//调用者需要持有sid的锁
+func (f *File) write(sid string, values map[interface{}]interface{}) error {
+	bs, err := f.getCodec().Encode(values)
+	if err != nil {
+		return err
+	}
+	dir := f.Dir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, tmpPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(bs)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.path(sid))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (f *File) Delete(sid string) error {
+	unlock := f.lock(sid)
+	defer unlock()
+	err := os.Remove(f.path(sid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *File) Exist(sid string) bool {
+	_, err := os.Stat(f.path(sid))
+	return err == nil
+}
+
+//删除目录中所有的session与用户索引
+func (f *File) Reset() {
+	f.walk(func(name string, info os.FileInfo) {
+		os.Remove(name)
+	})
+	os.RemoveAll(filepath.Join(f.Dir(), usersDir))
+}
+
+//给session更换一个新的sid，session中的数据保持不变
+func (f *File) ReSid(sid string) (string, error) {
+	unlock := f.lock(sid)
+	defer unlock()
+	if !f.Exist(sid) {
+		return "", ErrNotFound
+	}
+	for {
+		newSid := session.NewSIDLike(sid)
+		if newSid == "" {
+			return "", errors.New("session: failed to create sid")
+		}
+		unlockNew := f.lock(newSid)
+		if f.Exist(newSid) {
+			unlockNew()
+			continue
+		}
+		err := os.Rename(f.path(sid), f.path(newSid))
+		unlockNew()
+		if err != nil {
+			return "", err
+		}
+		now := time.Now()
+		os.Chtimes(f.path(newSid), now, now)
+		return newSid, nil
+	}
+}
+
+//清理超过maxLifeTime秒没有访问的session，以及写入失败残留的临时文件
+func (f *File) GC(maxLifeTime int64) {
+	deadline := time.Now().Add(-time.Duration(maxLifeTime) * time.Second)
+	f.walk(func(name string, info os.FileInfo) {
+		if info.ModTime().Before(deadline) {
+			os.Remove(name)
+		}
+	})
+	f.pruneUsers()
+}
+
+//目录中session文件的数量
+//文件名是sid的hash，GC无法知道被清理的sid，所以file没有实现session.ExpireNotifier
+func (f *File) Len() int {
+	n := 0
+	f.walk(func(name string, info os.FileInfo) {
+		if isSessionFile(filepath.Base(name)) {
+			n++
+		}
+	})
+	return n
+}
+
+//遍历目录中的session文件、锁文件与临时文件，忽略其他文件
+func (f *File) walk(fn func(name string, info os.FileInfo)) {
+	dir := f.Dir()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		name := info.Name()
+		if !strings.HasPrefix(name, tmpPrefix) && !isSessionFile(strings.TrimSuffix(name, lockSuffix)) {
+			continue
+		}
+		fn(filepath.Join(dir, name), info)
+	}
+}
+
+func isSessionFile(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+//文件中的session，修改先保存在内存中，调用Save后才写入文件
+type Session struct {
+	file   *File
+	mu     sync.RWMutex
+	sid    string
+	values map[interface{}]interface{}
+	dirty  bool
+}
+
+func newSession(f *File, sid string, values map[interface{}]interface{}) *Session {
+	return &Session{file: f, sid: sid, values: values}
+}
+
+func (s *Session) Get(key interface{}) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+func (s *Session) Set(key, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = val
+	s.dirty = true
+}
+
+func (s *Session) Del(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.dirty = true
+	}
+}
+
+func (s *Session) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sid
+}
+
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[interface{}]interface{})
+	s.dirty = true
+}
+
+//把修改写入文件，没有修改时什么都不做
+//session已经被删除或者更换了sid时不会重新创建文件
+func (s *Session) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	unlock := s.file.lock(s.sid)
+	defer unlock()
+	if !s.file.Exist(s.sid) {
+		return ErrNotFound
+	}
+	if err := s.file.write(s.sid, s.values); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,153 @@
+package file
+
+import (
+	"io/ioutil"
+	"mux/session"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPathTraversal(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "sessions")
+	f := NewFile(dir)
+	for _, sid := range []string{"../escape", "../../etc/passwd", "a/b", `a\b`, "/abs", ".", ".."} {
+		sess, err := f.Create(sid)
+		if err != nil {
+			t.Fatalf("%q: %v", sid, err)
+		}
+		sess.Set("k", "v")
+		if err := sess.(*Session).Save(); err != nil {
+			t.Fatalf("%q: %v", sid, err)
+		}
+		//文件名是sid的hash，只会落在dir中
+		if got := filepath.Dir(f.path(sid)); got != dir {
+			t.Fatalf("%q is stored in %s", sid, got)
+		}
+		if got, err := f.Read(sid); err != nil || got.Get("k") != "v" {
+			t.Fatalf("%q: read %v %v", sid, got, err)
+		}
+	}
+	//dir之外没有出现任何文件
+	infos, _ := ioutil.ReadDir(root)
+	if len(infos) != 1 || infos[0].Name() != "sessions" {
+		t.Fatalf("files outside the session dir: %v", infos)
+	}
+	if n := f.Len(); n != 7 {
+		t.Fatalf("Len = %d, want 7", n)
+	}
+}
+
+func TestAtomicReplace(t *testing.T) {
+	f := NewFile(t.TempDir())
+	sess, _ := f.Create("sid")
+	values := []string{strings.Repeat("a", 1<<16), strings.Repeat("b", 1<<17)}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			sess.Set("v", values[i%2])
+			if err := sess.(*Session).Save(); err != nil {
+				t.Error(err)
+				break
+			}
+		}
+		close(done)
+	}()
+	//不加锁直接读取文件，任何时候看到的都是完整的内容
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		bs, err := ioutil.ReadFile(f.path("sid"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := session.DecodeValues(f.getCodec(), bs)
+		if err != nil {
+			t.Fatalf("partial file of %d bytes: %v", len(bs), err)
+		}
+		if v, _ := got["v"].(string); v != "" && v != values[0] && v != values[1] {
+			t.Fatalf("partial value of %d bytes", len(v))
+		}
+	}
+	wg.Wait()
+	//临时文件都已经被rename或者删除
+	infos, _ := ioutil.ReadDir(f.Dir())
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), tmpPrefix) {
+			t.Fatalf("temporary file %s left behind", info.Name())
+		}
+	}
+}
+
+func TestSaveAfterDestroy(t *testing.T) {
+	f := NewFile(t.TempDir())
+	f.Create("gone")
+	f.Create("renamed")
+
+	//退出登录之后，正在处理的请求保存时不会重新创建session
+	inflight, _ := f.Read("gone")
+	f.Delete("gone")
+	inflight.Set("user", "alice")
+	if err := inflight.(*Session).Save(); err != ErrNotFound {
+		t.Fatalf("save after delete: %v, want ErrNotFound", err)
+	}
+	if f.Exist("gone") {
+		t.Fatal("deleted session was recreated")
+	}
+
+	old, _ := f.Read("renamed")
+	newSid, err := f.ReSid("renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Set("user", "alice")
+	if err := old.(*Session).Save(); err != ErrNotFound {
+		t.Fatalf("save after ReSid: %v, want ErrNotFound", err)
+	}
+	if f.Exist("renamed") || !f.Exist(newSid) || f.Len() != 1 {
+		t.Fatalf("sessions after ReSid: %d", f.Len())
+	}
+}
+
+func TestUserIndex(t *testing.T) {
+	f := NewFile(t.TempDir())
+	f.Create("a")
+	f.Create("b")
+	f.Create("other")
+	f.AddUserSession("alice", "a")
+	f.AddUserSession("alice", "b")
+	f.AddUserSession("bob", "other")
+
+	f.Delete("b")
+	sids, err := f.UserSessions("alice")
+	if err != nil || len(sids) != 1 || sids[0] != "a" {
+		t.Fatalf("UserSessions = %v, %v", sids, err)
+	}
+	if err := f.RemoveUserSession("alice", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.RemoveUserSession("alice", "a"); err != nil {
+		t.Fatalf("remove twice: %v", err)
+	}
+	if sids, _ := f.UserSessions("alice"); len(sids) != 0 {
+		t.Fatalf("after remove: %v", sids)
+	}
+
+	//GC清理session之后，索引中的记录与空的用户目录也被删除
+	f.GC(-60)
+	if sids, _ := f.UserSessions("bob"); len(sids) != 0 {
+		t.Fatalf("bob after GC: %v", sids)
+	}
+	if users, _ := ioutil.ReadDir(filepath.Join(f.Dir(), usersDir)); len(users) != 0 {
+		t.Fatalf("%d user dirs left after GC", len(users))
+	}
+}
@@ -0,0 +1,63 @@
+package file
+
+import (
+	"mux/session"
+	"os"
+	"time"
+)
+
+//session的锁文件的后缀，GC会清理过期的锁文件
+const lockSuffix = ".lock"
+
+//锁文件被占用时重试的间隔
+const lockRetry = 10 * time.Millisecond
+
+//给session加锁，同一个进程中使用进程内的锁，不同的进程之间使用锁文件上的advisory lock
+//不支持advisory lock的系统上只在进程内有效
+func (f *File) Lock(sid string, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	unlock, err := f.sessionLocks.Lock(sid, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(f.Dir(), 0700); err != nil {
+		unlock()
+		return nil, err
+	}
+	name := f.path(sid) + lockSuffix
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	for {
+		ok, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			unlock()
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			unlock()
+			return nil, session.ErrLockTimeout
+		}
+		time.Sleep(lockRetry)
+	}
+	now := time.Now()
+	os.Chtimes(name, now, now)
+	//文件锁与进程内的锁一起在lease之后自动释放
+	return session.LeaseUnlock(func() {
+		unlockFile(file)
+		file.Close()
+		unlock()
+	}, f.sessionLocks.Lease()), nil
+}
+
+//修改锁的最长持有时间，超过后锁自动释放，default:session.DefaultLockLease
+func (f *File) SetLockLease(d time.Duration) {
+	f.sessionLocks.SetLease(d)
+}
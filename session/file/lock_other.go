@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package file
+
+import "os"
+
+//没有flock的系统只使用进程内的锁
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) {}
@@ -0,0 +1,106 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//用户索引保存在dir/users/<uid的sha256>/<sid的sha256>中，文件内容是sid
+//每个sid一个文件，增加与删除都是单个文件的操作，多个进程共享同一个目录时也是安全的
+const usersDir = "users"
+
+func (f *File) userDir(uid string) string {
+	sum := sha256.Sum256([]byte(uid))
+	return filepath.Join(f.Dir(), usersDir, hex.EncodeToString(sum[:]))
+}
+
+func (f *File) AddUserSession(uid, sid string) error {
+	dir := f.userDir(uid)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, tmpPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(sid)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, filepath.Base(f.path(sid))))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (f *File) RemoveUserSession(uid, sid string) error {
+	err := os.Remove(filepath.Join(f.userDir(uid), filepath.Base(f.path(sid))))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//session文件已经不存在的记录会被顺便删除
+func (f *File) UserSessions(uid string) ([]string, error) {
+	dir := f.userDir(uid)
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sids []string
+	for _, info := range infos {
+		if !isSessionFile(info.Name()) {
+			continue
+		}
+		name := filepath.Join(dir, info.Name())
+		bs, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		sid := string(bs)
+		if !f.Exist(sid) {
+			os.Remove(name)
+			continue
+		}
+		sids = append(sids, sid)
+	}
+	return sids, nil
+}
+
+//删除session已经被GC清理的记录与空的用户目录
+func (f *File) pruneUsers() {
+	root := filepath.Join(f.Dir(), usersDir)
+	users, err := ioutil.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, u := range users {
+		if !u.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, u.Name())
+		infos, _ := ioutil.ReadDir(dir)
+		left := 0
+		for _, info := range infos {
+			//记录的文件名与session文件的文件名相同
+			if _, err := os.Stat(filepath.Join(f.Dir(), info.Name())); err != nil && isSessionFile(info.Name()) {
+				os.Remove(filepath.Join(dir, info.Name()))
+				continue
+			}
+			left++
+		}
+		if left == 0 {
+			os.Remove(dir)
+		}
+	}
+}
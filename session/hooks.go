@@ -0,0 +1,180 @@
+package session
+
+import (
+	"expvar"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//session生命周期的回调，在请求的goroutine或者GC的goroutine中同步执行，不应当阻塞
+type Hooks struct {
+	//创建了新的session
+	OnCreate func(sid string)
+	//ReSessionID更换了sid
+	OnRegenerate func(oldSid,newSid string)
+	//DestroySession或者DestroyUserSessions删除了session
+	OnDestroy func(sid string)
+	//session过期，由GC清理或者读取时发现超时，provider需要实现ExpireNotifier GC才会触发
+	//provider因为容量限制淘汰session时也会触发，需要实现EvictNotifier
+	OnExpire func(sid string)
+}
+
+//GC时报告被清理的session，没有实现时GC不会触发OnExpire
+type ExpireNotifier interface {
+	GCNotify(maxLifeTime int64,expired func(sid string))
+}
+
+//容量满时会淘汰session的provider，例如设置了SetMaxSessions的memory
+//Manage初始化时注册回调，多个Manage共用一个provider时以最后初始化的为准
+type EvictNotifier interface {
+	SetEvictHandler(evicted func(sid string))
+}
+
+//能够统计session数量的provider，GC之后会更新session_active
+type Counter interface {
+	Len() int
+}
+
+//通用的指标接口，可以对接prometheus、statsd等
+//labels中有provider，值为ManagerConf.ProviderName
+type Metrics interface {
+	Counter(name string,delta float64,labels map[string]string)
+	Gauge(name string,value float64,labels map[string]string)
+}
+
+//Manage上报的指标
+const (
+	MetricActive      = "session_active"
+	MetricCreated     = "session_created_total"
+	MetricRegenerated = "session_regenerated_total"
+	MetricDestroyed   = "session_destroyed_total"
+	MetricExpired     = "session_expired_total"
+	MetricEvicted     = "session_evicted_total"
+)
+
+//设置回调，应当在开始处理请求之前调用
+func (m *Manage) SetHooks(h Hooks) {
+	m.hooks = h
+}
+
+//设置指标，应当在开始处理请求之前调用
+func (m *Manage) SetMetrics(metrics Metrics) {
+	m.metrics = metrics
+}
+
+func (m *Manage) labels() map[string]string {
+	return map[string]string{"provider": m.conf.ProviderName}
+}
+
+func (m *Manage) count(name string) {
+	if m.metrics != nil {
+		m.metrics.Counter(name,1,m.labels())
+	}
+}
+
+func (m *Manage) created(sid string) {
+	m.count(MetricCreated)
+	if m.hooks.OnCreate != nil {
+		m.hooks.OnCreate(sid)
+	}
+}
+
+func (m *Manage) regenerated(oldSid,newSid string) {
+	m.count(MetricRegenerated)
+	if m.hooks.OnRegenerate != nil {
+		m.hooks.OnRegenerate(oldSid,newSid)
+	}
+}
+
+func (m *Manage) destroyed(sid string) {
+	m.count(MetricDestroyed)
+	if m.hooks.OnDestroy != nil {
+		m.hooks.OnDestroy(sid)
+	}
+}
+
+func (m *Manage) expiredSid(sid string) {
+	m.count(MetricExpired)
+	if m.hooks.OnExpire != nil {
+		m.hooks.OnExpire(sid)
+	}
+}
+
+//被provider淘汰的session同样触发OnExpire
+func (m *Manage) evicted(sid string) {
+	m.count(MetricEvicted)
+	if m.hooks.OnExpire != nil {
+		m.hooks.OnExpire(sid)
+	}
+}
+
+//GC之后更新session的数量
+func (m *Manage) reportActive() {
+	if m.metrics == nil {
+		return
+	}
+	if c, ok := m.provider.(Counter); ok {
+		m.metrics.Gauge(MetricActive,float64(c.Len()),m.labels())
+	}
+}
+
+//把指标发布到expvar中，可以通过/debug/vars查看
+//key的格式为name{provider="memory"}
+type ExpvarMetrics struct {
+	vars *expvar.Map
+	mu   sync.Mutex
+}
+
+//name为expvar中的变量名，不能重复
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	return &ExpvarMetrics{vars: expvar.NewMap(name)}
+}
+
+func (e *ExpvarMetrics) Counter(name string,delta float64,labels map[string]string) {
+	e.vars.AddFloat(metricKey(name,labels),delta)
+}
+
+func (e *ExpvarMetrics) Gauge(name string,value float64,labels map[string]string) {
+	key := metricKey(name,labels)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v, ok := e.vars.Get(key).(*expvar.Float)
+	if !ok {
+		v = new(expvar.Float)
+		e.vars.Set(key,v)
+	}
+	v.Set(value)
+}
+
+func (e *ExpvarMetrics) Get(name string,labels map[string]string) float64 {
+	if v, ok := e.vars.Get(metricKey(name,labels)).(*expvar.Float); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func metricKey(name string,labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	keys := make([]string,0,len(labels))
+	for k := range labels {
+		keys = append(keys,k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(labels[k])
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
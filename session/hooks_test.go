@@ -0,0 +1,107 @@
+package session_test
+
+import (
+	"mux/session"
+	"mux/session/memory"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var names int32
+
+//expvar与provider的名字不能重复，-count大于1时每次使用新的名字
+func uniqueName(prefix string) string {
+	return prefix + "_" + strconv.Itoa(int(atomic.AddInt32(&names, 1)))
+}
+
+func TestHooksAndMetrics(t *testing.T) {
+	m := newTestManage(t, 60, 0)
+	var events []string
+	m.SetHooks(session.Hooks{
+		OnCreate:     func(sid string) { events = append(events, "create") },
+		OnRegenerate: func(oldSid, newSid string) { events = append(events, "regenerate") },
+		OnDestroy:    func(sid string) { events = append(events, "destroy") },
+		OnExpire:     func(sid string) { events = append(events, "expire") },
+	})
+	metrics := session.NewExpvarMetrics(uniqueName("session_test_hooks"))
+	m.SetMetrics(metrics)
+
+	w := httptest.NewRecorder()
+	if _, err := m.Session(w, httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	re := httptest.NewRecorder()
+	sid, err := m.ReSessionID(re, withCookie(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := httptest.NewRecorder()
+	if err := m.DestroySession(out, withCookie(re)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.SessionByID(sid); err == nil {
+		t.Fatal("destroyed session still exists")
+	}
+	if c := out.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Fatalf("sid cookie was not cleared: %v", c)
+	}
+
+	expiring, _ := m.Session(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	expiring.Set(session.AccessedKey, time.Now().Add(-time.Hour).Unix())
+	m.SessionByID(expiring.ID())
+
+	want := []string{"create", "regenerate", "destroy", "create", "expire"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events = %v, want %v", events, want)
+		}
+	}
+
+	labels := map[string]string{"provider": "memory"}
+	for name, n := range map[string]float64{
+		session.MetricCreated:     2,
+		session.MetricRegenerated: 1,
+		session.MetricDestroyed:   1,
+		session.MetricExpired:     1,
+	} {
+		if got := metrics.Get(name, labels); got != n {
+			t.Errorf("%s = %v, want %v", name, got, n)
+		}
+	}
+}
+
+func TestEvictionHooks(t *testing.T) {
+	provider := memory.NewMemory()
+	provider.SetMaxSessions(1)
+	name := uniqueName("memory-evict")
+	session.Register(name, provider)
+	m, err := session.NewManage(&session.ManagerConf{ProviderName: name, CookieName: "sid", EnableSetCookie: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := 0
+	m.SetHooks(session.Hooks{OnExpire: func(sid string) { expired++ }})
+	metrics := session.NewExpvarMetrics(uniqueName("session_test_evict"))
+	m.SetMetrics(metrics)
+
+	//每个分片最多1个session，40个session中至少有8个被淘汰
+	const n = 40
+	for i := 0; i < n; i++ {
+		if _, err := m.SessionID(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	evicted := n - provider.Len()
+	if evicted < 8 || expired != evicted {
+		t.Fatalf("evicted %d sessions, OnExpire called %d times", evicted, expired)
+	}
+	if got := metrics.Get(session.MetricEvicted, map[string]string{"provider": name}); got != float64(evicted) {
+		t.Fatalf("%s = %v, want %d", session.MetricEvicted, got, evicted)
+	}
+}
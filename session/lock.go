@@ -0,0 +1,125 @@
+package session
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//锁默认的最长持有时间，与sql、redis的锁相同
+const DefaultLockLease = time.Minute
+
+//进程内的按key加锁，零值可以直接使用，memory等provider可以用它实现Locker
+//锁在lease之后自动释放，持有锁的请求panic或者忘记解锁时不会一直占用
+type LocalLocker struct {
+	mu    sync.Mutex
+	locks map[string]*localLock
+	//纳秒，0时使用DefaultLockLease
+	lease int64
+}
+
+//没有人使用时从map中删除
+type localLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+//修改锁的最长持有时间，只影响之后加的锁
+func (l *LocalLocker) SetLease(d time.Duration) {
+	atomic.StoreInt64(&l.lease,int64(d))
+}
+
+func (l *LocalLocker) Lease() time.Duration {
+	if d := time.Duration(atomic.LoadInt64(&l.lease)); d > 0 {
+		return d
+	}
+	return DefaultLockLease
+}
+
+func (l *LocalLocker) Lock(key string,timeout time.Duration) (func(),error) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*localLock)
+	}
+	lk, ok := l.locks[key]
+	if !ok {
+		lk = &localLock{ch: make(chan struct{},1)}
+		l.locks[key] = lk
+	}
+	lk.refs++
+	l.mu.Unlock()
+
+	select {
+	case lk.ch <- struct{}{}:
+	default:
+		timer := time.NewTimer(timeout)
+		select {
+		case lk.ch <- struct{}{}:
+			timer.Stop()
+		case <-timer.C:
+			l.release(key,lk)
+			return nil,ErrLockTimeout
+		}
+	}
+	return LeaseUnlock(func() {
+		<-lk.ch
+		l.release(key,lk)
+	},l.Lease()),nil
+}
+
+//lease之后自动调用unlock，返回的函数可以重复调用，只有第一次有效
+//锁过期之后原来的持有者再解锁不会释放其他人拿到的锁
+func LeaseUnlock(unlock func(),lease time.Duration) func() {
+	var once sync.Once
+	timer := time.AfterFunc(lease,func() {
+		once.Do(unlock)
+	})
+	return func() {
+		timer.Stop()
+		once.Do(unlock)
+	}
+}
+
+func (l *LocalLocker) release(key string,lk *localLock) {
+	l.mu.Lock()
+	lk.refs--
+	if lk.refs == 0 {
+		delete(l.locks,key)
+	}
+	l.mu.Unlock()
+}
+
+//加了锁的session，请求结束时由Context调用Release解锁
+type lockedSession struct {
+	Sessioner
+	once   sync.Once
+	unlock func()
+}
+
+//被包装的provider的session
+func (s *lockedSession) Unwrap() Sessioner {
+	return s.Sessioner
+}
+
+func (s *lockedSession) Save() error {
+	if saver, ok := s.Sessioner.(Saver); ok {
+		return saver.Save()
+	}
+	return nil
+}
+
+func (s *lockedSession) Release() {
+	s.once.Do(s.unlock)
+}
+
+//加锁，没有开启或者provider不支持时什么都不做
+func (m *Manage) lock(sid string) (func(),error) {
+	if m.conf.LockTimeout <= 0 {
+		return nil,nil
+	}
+	locker, ok := m.provider.(Locker)
+	if !ok {
+		return nil,nil
+	}
+	return locker.Lock(sid,m.conf.LockTimeout)
+}
@@ -0,0 +1,67 @@
+package session_test
+
+import (
+	"mux/session"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionLock(t *testing.T) {
+	m, err := session.NewManage(&session.ManagerConf{
+		ProviderName:    "memory",
+		CookieName:      "sid",
+		EnableSetCookie: true,
+		LockTimeout:     50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if _, err := m.Session(w, httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := m.Session(httptest.NewRecorder(), withCookie(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Session(httptest.NewRecorder(), withCookie(w)); err != session.ErrLockTimeout {
+		t.Fatalf("second Session = %v, want ErrLockTimeout", err)
+	}
+	first.(session.Releaser).Release()
+	second, err := m.Session(httptest.NewRecorder(), withCookie(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.(session.Releaser).Release()
+}
+
+func TestLocalLockerLease(t *testing.T) {
+	var l session.LocalLocker
+	l.SetLease(20 * time.Millisecond)
+	//第一个持有者一直不解锁，lease之后锁自动释放
+	forgotten, err := l.Lock("sid", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	second, err := l.Lock("sid", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 15*time.Millisecond {
+		t.Fatalf("acquired after %v, before the lease expired", d)
+	}
+	//原来的持有者解锁不会释放第二个持有者的锁
+	forgotten()
+	if _, err := l.Lock("sid", 5*time.Millisecond); err != session.ErrLockTimeout {
+		t.Fatalf("third Lock = %v, want ErrLockTimeout", err)
+	}
+	second()
+	third, err := l.Lock("sid", 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third()
+}
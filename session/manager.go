@@ -8,10 +8,15 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 )
 
 var DefaultManager = defaultManager()
 
+//请求中没有sid，或者sid对应的session已经不存在
+var ErrSessionNotExist = errors.New("session并不存在")
+
 var DefaultManagerConf = &ManagerConf{
 	ProviderName :"memory",
 	CookieName:"wtf",
@@ -22,12 +27,9 @@ var DefaultManagerConf = &ManagerConf{
 	SessionIDLength:64,
 }
 
+//provider的驱动在session包初始化之后才会注册，所以DefaultManager在第一次使用时才查找provider
 func defaultManager() Manager {
-	manage, err := NewManage(DefaultManagerConf)
-	if err != nil {
-		panic(err)
-	}
-	return manage
+	return &Manage{conf: DefaultManagerConf}
 }
 
 type ManagerConf struct {
@@ -37,28 +39,38 @@ type ManagerConf struct {
 	EnableSidInHTTPHeader   bool   //如果cookie不可用，则使用header重写，default:true
 	SessionNameInHTTPHeader string //default:"wtf"
 	MaxLiftTime int //cookie在浏览器存活时间，default:0，即浏览器关闭清理
-	GCTime int64	//default:
+	GCTime int64	//GC的间隔，同时也是session的过期时间，超过这么多秒没有访问的session会被清理，0不清理，default:3600
 	HTTPOnly bool //default:true，js不能获取到cookie，防止session劫持
 	Secure bool //只在https下使用cookie？default:false
 	//ProviderConfig          string `json:"providerConfig"`
 	Domain                  string //default:""
 	Path string
 	SessionIDLength         uint8  //default:64
+	IdleTimeout int64 //超过这么多秒没有请求的session失效，0不限制，default:0
+	AbsoluteTimeout int64 //session从创建开始最多存活这么多秒，更换sid不会延长，0不限制，default:0
+	LockTimeout time.Duration //大于0时同一个session的请求串行执行，等待锁的最长时间，provider需要实现Locker，default:0
 }
 
-//动态调整session的配置
+//单次调用时覆盖ManagerConf的配置，不会修改Manager本身的配置，多个配置按照顺序覆盖
+//为nil或者零值的字段使用ManagerConf中的值
+//	&session.ManagerRunConfig{Path:"/admin",Secure:session.Bool(true)}
 type ManagerRunConfig struct {
-	EnableSetCookie bool //是否使用cookie，若 false 优先使用header头部重写，其次使用URL重写，再其次会向query或form中查询token，否则就不能创建session，default:true
-	EnableSidInHTTPHeader   bool   //如果cookie不可用，则使用header重写，default:true
-	//优先级 cookie > header > url > query/form token
-	HTTPOnly bool //default:true，js不能获取到cookie，防止session劫持
-	Secure bool //只在https下使用cookie？default:false
-	MaxLiftTime int //cookie在浏览器存活时间，default:0，即浏览器关闭清理
-	SessionIDLength         uint8  //default:64
-	SessionNameInHTTPHeader string //default:"wtf"
+	EnableSetCookie *bool //是否使用cookie，若 false 优先使用header头部重写，其次使用URL重写
+	EnableSidInHTTPHeader *bool //如果cookie不可用，则使用header重写
+	//优先级 cookie > header > url
+	HTTPOnly *bool //js不能获取到cookie，防止session劫持
+	Secure *bool //只在https下使用cookie
+	MaxLiftTime int //cookie在浏览器存活时间
+	SessionIDLength         uint8
+	SessionNameInHTTPHeader string
 	//ProviderConfig          string `json:"providerConfig"`
 	Path string
-	Domain                  string //default:""
+	Domain                  string
+}
+
+//用于ManagerRunConfig中的bool字段
+func Bool(v bool) *bool {
+	return &v
 }
 
 type Manager interface {
@@ -66,129 +78,353 @@ type Manager interface {
 	ReSessionID(w http.ResponseWriter,r *http.Request,confs ...*ManagerRunConfig) (string,error)
 	SessionID() (string,error)
 	SessionByID(sid string) (Sessioner,error)
+	//把session和用户关联起来，之后可以通过DestroyUserSessions删除这个用户所有的session
+	BindUser(sess Sessioner,uid string) error
+	//删除用户所有的session，例如修改密码之后
+	DestroyUserSessions(uid string) error
+	//删除当前请求的session，例如退出登录
+	DestroySession(w http.ResponseWriter,r *http.Request,confs ...*ManagerRunConfig) error
+}
+
+//Manager可以实现它，只读取请求中已经存在的session，没有时返回ErrSessionNotExist，不会创建新的session
+type ExistingSessioner interface {
+	ExistingSession(w http.ResponseWriter,r *http.Request,confs ...*ManagerRunConfig) (Sessioner,error)
 }
 
 //全局session管理器,Manager并不是并发安全的，应当在provider层实现并发安全
 type Manage struct {
 	provider Provider
 	conf *ManagerConf
+
+	once sync.Once
+	err error
+	hooks Hooks
+	metrics Metrics
 }
 
 func (m *Manage) SessionID() (string,error) {
+	if err := m.init(); err != nil {
+		return "",err
+	}
 	sid := m.createSID(m.conf.SessionIDLength)
-	_, err := m.provider.Create(sid)
+	sess, err := m.provider.Create(sid)
+	if err == nil {
+		m.start(sess)
+		m.created(sid)
+	}
 	return sid,err
 }
 
 func (m *Manage) SessionByID(sid string) (Sessioner,error) {
-	return m.provider.Read(sid)
+	if err := m.init(); err != nil {
+		return nil,err
+	}
+	sess, err := m.provider.Read(sid)
+	if err != nil {
+		return nil,err
+	}
+	if m.expired(sess) {
+		m.destroy(sess)
+		return nil,ErrSessionNotExist
+	}
+	m.touch(sess)
+	return sess,nil
 }
 
 func NewManage(conf *ManagerConf) (*Manage,error) {
-	provider,ok := Providers[conf.ProviderName]
-	if !ok{
-		return nil,fmt.Errorf("session: unknown provider %q 查查是不是没导包",conf.ProviderName)
+	m := &Manage{conf:conf}
+	if err := m.init(); err != nil {
+		return nil,err
 	}
-	return &Manage{provider: provider,conf:conf},nil
+	return m,nil
+}
+
+//查找provider并启动GC，只会执行一次
+func (m *Manage) init() error {
+	m.once.Do(func() {
+		//复制一份，之后只读，修改DefaultManagerConf不会影响已经在使用的Manager
+		conf := DefaultManagerConf
+		if m.conf != nil {
+			conf = m.conf
+		}
+		cf := *conf
+		if cf.SessionNameInHTTPHeader == "" {
+			cf.SessionNameInHTTPHeader = cf.CookieName
+		}
+		m.conf = &cf
+
+		provider,ok := Providers[m.conf.ProviderName]
+		if !ok{
+			m.err = fmt.Errorf("session: unknown provider %q 查查是不是没导包",m.conf.ProviderName)
+			return
+		}
+		m.provider = provider
+		if n, ok := provider.(EvictNotifier); ok {
+			n.SetEvictHandler(m.evicted)
+		}
+		if m.conf.GCTime > 0 {
+			go m.GC()
+		}
+	})
+	return m.err
+}
+
+//每隔GCTime秒清理一次过期的session
+func (m *Manage) GC() {
+	if n, ok := m.provider.(ExpireNotifier); ok {
+		n.GCNotify(m.conf.GCTime,m.expiredSid)
+	} else {
+		m.provider.GC(m.conf.GCTime)
+	}
+	m.reportActive()
+	time.AfterFunc(time.Duration(m.conf.GCTime)*time.Second, m.GC)
 }
 
 //查询到session就返回，否则返回一个新的session
 func (m *Manage) Session(w http.ResponseWriter,r *http.Request,confs ...*ManagerRunConfig) (Sessioner,error) {
-	var cf *ManagerConf
-	if len(confs) > 0 {
-		cf = m.mergeConf(confs)
+	if err := m.init(); err != nil {
+		return nil,err
 	}
+	cf := m.mergeConf(confs)
 
-	sid, ok := m.getSid(w,r,cf)
-	if ok {
-		return m.provider.Read(sid)
+	//session可能已经过期被清理掉了，这时重新创建一个
+	if sess, err := m.existing(r,cf); err != nil || sess != nil {
+		return sess,err
 	}
 
 	sid, err := m.setSid(w, r, cf)
 	if err != nil{
 		return nil,err
 	}
-	return m.provider.Create(sid)
+	sess, err := m.provider.Create(sid)
+	if err != nil {
+		return nil,err
+	}
+	m.start(sess)
+	m.created(sid)
+	return sess,nil
+}
+
+//只读取请求中已经存在的session，没有sid、session不存在或者已经过期时返回ErrSessionNotExist
+//不会创建session，也不会写入sid，适合读取flash消息这类匿名访问者也会用到的功能
+func (m *Manage) ExistingSession(w http.ResponseWriter,r *http.Request,confs ...*ManagerRunConfig) (Sessioner,error) {
+	if err := m.init(); err != nil {
+		return nil,err
+	}
+	sess, err := m.existing(r,m.mergeConf(confs))
+	if err == nil && sess == nil {
+		return nil,ErrSessionNotExist
+	}
+	return sess,err
+}
+
+//读取请求中sid对应的session并加锁，没有或者已经过期时返回nil
+func (m *Manage) existing(r *http.Request,cf *ManagerConf) (Sessioner,error) {
+	sid, ok := m.getSid(r,cf)
+	if !ok || !m.provider.Exist(sid) {
+		return nil,nil
+	}
+	unlock, err := m.lock(sid)
+	if err != nil {
+		return nil,err
+	}
+	sess, err := m.provider.Read(sid)
+	if err == nil && !m.expired(sess) {
+		m.touch(sess)
+		if unlock == nil {
+			return sess,nil
+		}
+		return &lockedSession{Sessioner: sess, unlock: unlock},nil
+	}
+	if unlock != nil {
+		unlock()
+	}
+	if err != nil {
+		return nil,err
+	}
+	m.destroy(sess)
+	return nil,nil
 }
 
 //给session更换id
 func (m *Manage) ReSessionID(w http.ResponseWriter,r *http.Request,confs ...*ManagerRunConfig) (string,error)  {
-	var cf *ManagerConf
-	if len(confs) > 0 {
-		cf = m.mergeConf(confs)
+	if err := m.init(); err != nil {
+		return "",err
 	}
+	cf := m.mergeConf(confs)
 
-	sid, ok := m.getSid(w, r, cf)
+	sid, ok := m.getSid(r, cf)
 	if ok && m.provider.Exist(sid){
 		reSid, err := m.provider.ReSid(sid)
 		if err != nil { return "",err}
+		m.rebindUser(sid,reSid)
+		m.regenerated(sid,reSid)
 		return reSid,m.resetSid(w,r,reSid,cf)
 	}
-	return "",errors.New("session并不存在")
+	return "",ErrSessionNotExist
+}
+
+//删除当前请求的session，并让客户端删除sid
+func (m *Manage) DestroySession(w http.ResponseWriter,r *http.Request,confs ...*ManagerRunConfig) error {
+	if err := m.init(); err != nil {
+		return err
+	}
+	cf := m.mergeConf(confs)
+	sid, ok := m.getSid(r,cf)
+	if !ok {
+		return nil
+	}
+	if idx, ok := m.userIndex(); ok {
+		if sess, err := m.provider.Read(sid); err == nil {
+			if uid, _ := sess.Get(UserKey).(string); uid != "" {
+				idx.RemoveUserSession(uid,sid)
+			}
+		}
+	}
+	if err := m.provider.Delete(sid); err != nil {
+		return err
+	}
+	m.destroyed(sid)
+	switch sidMode(cf) {
+	case modeCookie:
+		http.SetCookie(w,&http.Cookie{
+			Name:cf.CookieName,
+			Path:cf.Path,
+			Domain:cf.Domain,
+			MaxAge:-1,
+			Secure:cf.Secure,
+			HttpOnly:cf.HTTPOnly,
+		})
+	case modeHeader:
+		w.Header().Del(cf.SessionNameInHTTPHeader)
+	}
+	return nil
 }
 
+//在配置的副本上覆盖confs，没有confs时直接返回m.conf，调用者不能修改返回值
 func (m *Manage) mergeConf(confs []*ManagerRunConfig) *ManagerConf {
 	if len(confs) < 1{
 		return m.conf
 	}
-	conf := confs[0]
-	cf := m.conf
-	cf.EnableSetCookie = conf.EnableSetCookie
-	cf.EnableSidInHTTPHeader = conf.EnableSidInHTTPHeader
-	cf.SessionNameInHTTPHeader = conf.SessionNameInHTTPHeader
-	cf.MaxLiftTime = conf.MaxLiftTime
-	cf.HTTPOnly = conf.HTTPOnly
-	cf.Secure = conf.Secure
-	cf.Domain = conf.Domain
-	cf.Path = conf.Path
-	cf.SessionIDLength = conf.SessionIDLength
-	return cf
+	cf := *m.conf
+	for _, conf := range confs {
+		if conf == nil {
+			continue
+		}
+		if conf.EnableSetCookie != nil {
+			cf.EnableSetCookie = *conf.EnableSetCookie
+		}
+		if conf.EnableSidInHTTPHeader != nil {
+			cf.EnableSidInHTTPHeader = *conf.EnableSidInHTTPHeader
+		}
+		if conf.HTTPOnly != nil {
+			cf.HTTPOnly = *conf.HTTPOnly
+		}
+		if conf.Secure != nil {
+			cf.Secure = *conf.Secure
+		}
+		if conf.MaxLiftTime != 0 {
+			cf.MaxLiftTime = conf.MaxLiftTime
+		}
+		if conf.SessionIDLength != 0 {
+			cf.SessionIDLength = conf.SessionIDLength
+		}
+		if conf.SessionNameInHTTPHeader != "" {
+			cf.SessionNameInHTTPHeader = conf.SessionNameInHTTPHeader
+		}
+		if conf.Path != "" {
+			cf.Path = conf.Path
+		}
+		if conf.Domain != "" {
+			cf.Domain = conf.Domain
+		}
+	}
+	return &cf
 }
 
-//cookie>header>url>query/form中依次查找，写入也是同样的顺序
-func (m *Manage) getSid(w http.ResponseWriter,r *http.Request,cf *ManagerConf) (string,bool) {
-	cookie, err := r.Cookie(cf.CookieName)
-	if err == nil && cookie.Value != ""{
-		sid,_ := url.QueryUnescape(cookie.Value)
-		return sid,true
+//sid的传递方式，cookie > header > url，只使用优先级最高的那个启用的方式
+const (
+	modeCookie = iota
+	modeHeader
+	modeURL
+)
+
+func sidMode(cf *ManagerConf) int {
+	if cf.EnableSetCookie {
+		return modeCookie
 	}
-	if sid := r.Header.Get(cf.SessionNameInHTTPHeader); sid != ""{
-		return sid,true
+	if cf.EnableSidInHTTPHeader {
+		return modeHeader
 	}
-	if sid := r.URL.Query().Get(cf.CookieName); sid != ""{
-		return sid,true
+	return modeURL
+}
+
+//按照配置的方式读取sid，不会从其他的地方读取，防止通过构造的链接固定session
+//url重写时sid在query或者表单中，名称为CookieName
+func (m *Manage) getSid(r *http.Request,cf *ManagerConf) (string,bool) {
+	sid, ok := m.requestSid(r,cf)
+	if !ok || !ValidSID(sid,cf.SessionIDLength) {
+		return "",false
 	}
-	if sid := r.Form.Get(cf.CookieName);sid != ""{
-		return sid,true
+	return sid,true
+}
+
+func (m *Manage) requestSid(r *http.Request,cf *ManagerConf) (string,bool) {
+	switch sidMode(cf) {
+	case modeCookie:
+		cookie, err := r.Cookie(cf.CookieName)
+		if err == nil && cookie.Value != ""{
+			sid,_ := url.QueryUnescape(cookie.Value)
+			return sid,true
+		}
+	case modeHeader:
+		if sid := r.Header.Get(cf.SessionNameInHTTPHeader); sid != ""{
+			return sid,true
+		}
+	case modeURL:
+		if sid := r.URL.Query().Get(cf.CookieName); sid != ""{
+			return sid,true
+		}
+		if sid := r.PostFormValue(cf.CookieName);sid != ""{
+			return sid,true
+		}
 	}
 	return "",false
 }
 
-//创建一个session，并将id写入到cookie中
+//url重写时把sid加到链接的query中，其他方式时原样返回
+func (m *Manage) RewriteURL(rawurl,sid string,confs ...*ManagerRunConfig) (string,error) {
+	if err := m.init(); err != nil {
+		return "",err
+	}
+	cf := m.mergeConf(confs)
+	if sidMode(cf) != modeURL {
+		return rawurl,nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "",err
+	}
+	q := u.Query()
+	q.Set(cf.CookieName,sid)
+	u.RawQuery = q.Encode()
+	return u.String(),nil
+}
+
+//生成一个新的sid，并按照配置的方式发给客户端
 func (m *Manage) setSid(w http.ResponseWriter,r *http.Request,cf *ManagerConf) (string,error) {
 	//查找不到，setsid
 	sid := m.createSID(cf.SessionIDLength)
 	for sid == "" || m.provider.Exist(sid){
 		sid = m.createSID(cf.SessionIDLength)
 	}
-	if cf.EnableSetCookie {
-		http.SetCookie(w,&http.Cookie{
-			Name:cf.CookieName,
-			Value:sid,
-			Path:cf.Path,
-			Domain:cf.Domain,
-			MaxAge:cf.MaxLiftTime,
-			Secure:cf.Secure,
-			HttpOnly:cf.HTTPOnly,
-		})
-		return sid,nil
-	}
-	return "", errors.New("无法设置session")
+	return sid,m.resetSid(w,r,sid,cf)
 }
 
-//更换cookie的sid
+//把sid写入cookie或者响应头，url重写时需要调用者通过RewriteURL生成链接
 func (m *Manage) resetSid(w http.ResponseWriter,r *http.Request,sid string,cf *ManagerConf) error {
-	if cf.EnableSetCookie {
+	switch sidMode(cf) {
+	case modeCookie:
 		http.SetCookie(w,&http.Cookie{
 			Name:cf.CookieName,
 			Value:sid,
@@ -198,18 +434,20 @@ func (m *Manage) resetSid(w http.ResponseWriter,r *http.Request,sid string,cf *M
 			Secure:cf.Secure,
 			HttpOnly:cf.HTTPOnly,
 		})
-		return nil
+	case modeHeader:
+		w.Header().Set(cf.SessionNameInHTTPHeader,sid)
 	}
-	if cf.EnableSidInHTTPHeader {
-		w.Header().Del(cf.SessionNameInHTTPHeader)
-		w.Header().Add(cf.SessionNameInHTTPHeader,sid)
-		return nil
-	}
-	return errors.New("无法设置session")
+	return nil
 }
 
 //随机生成一个sid
 func (m *Manage) createSID(l uint8) string {
+	return NewSID(l)
+}
+
+//随机生成一个sid，l为随机字节的长度，default:64
+//使用不带填充的url安全的base64，可以直接放在cookie、header与url中
+func NewSID(l uint8) string {
 	if l == 0{
 		l = 64
 	}
@@ -217,5 +455,32 @@ func (m *Manage) createSID(l uint8) string {
 	if _, err := io.ReadFull(rand.Reader,id); err != nil{
 		return ""
 	}
-	return url.QueryEscape(base64.URLEncoding.EncodeToString(id))
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+//sid是否可能由NewSID(l)生成：长度相同并且只包含url安全的base64字符
+//客户端发送的sid不合法时当作没有sid，不会传给provider，避免被拼接成provider中其他用途的key
+func ValidSID(sid string,l uint8) bool {
+	if l == 0{
+		l = 64
+	}
+	if len(sid) != base64.RawURLEncoding.EncodedLen(int(l)) {
+		return false
+	}
+	for i := 0; i < len(sid); i++ {
+		c := sid[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+//生成一个和sid长度相同的新sid，provider在ReSid中应当使用它，保持ManagerConf.SessionIDLength
+func NewSIDLike(sid string) string {
+	l := base64.RawURLEncoding.DecodedLen(len(sid))
+	if l <= 0 || l > 255 {
+		l = 0
+	}
+	return NewSID(uint8(l))
 }
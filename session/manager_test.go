@@ -0,0 +1,161 @@
+package session_test
+
+import (
+	"mux/session"
+	"mux/session/memory"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//单次调用的配置不能影响其他的调用
+func TestRunConfigOverlay(t *testing.T) {
+	m := newTestManage(t, 0, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			m.Session(w, httptest.NewRequest("GET", "/", nil), &session.ManagerRunConfig{Path: "/admin", Secure: session.Bool(true)})
+			if c := w.Result().Cookies()[0]; c.Path != "/admin" || !c.Secure {
+				t.Errorf("overlay not applied: %+v", c)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			m.Session(w, httptest.NewRequest("GET", "/", nil))
+			if c := w.Result().Cookies()[0]; c.Path != "" || c.Secure {
+				t.Errorf("overlay leaked into the default config: %+v", c)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestHeaderMode(t *testing.T) {
+	m := newTestManage(t, 0, 0)
+	conf := &session.ManagerRunConfig{
+		EnableSetCookie:         session.Bool(false),
+		EnableSidInHTTPHeader:   session.Bool(true),
+		SessionNameInHTTPHeader: "X-Session",
+	}
+	w := httptest.NewRecorder()
+	sess, err := m.Session(w, httptest.NewRequest("GET", "/", nil), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Header().Get("X-Session"); got != sess.ID() {
+		t.Fatalf("header = %q, want %q", got, sess.ID())
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Fatal("cookie was written in header mode")
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Session", sess.ID())
+	again, _ := m.Session(httptest.NewRecorder(), r, conf)
+	if again.ID() != sess.ID() {
+		t.Fatal("sid was not read from the header")
+	}
+	//cookie模式下不接受header中的sid
+	other, _ := m.Session(httptest.NewRecorder(), r)
+	if other.ID() == sess.ID() {
+		t.Fatal("sid was read from the header in cookie mode")
+	}
+}
+
+func TestURLMode(t *testing.T) {
+	m := newTestManage(t, 0, 0)
+	conf := &session.ManagerRunConfig{EnableSetCookie: session.Bool(false), EnableSidInHTTPHeader: session.Bool(false)}
+	sess, err := m.Session(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := m.RewriteURL("/next?a=1", sess.ID(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(link, "sid="+sess.ID()) {
+		t.Fatalf("link = %q", link)
+	}
+	again, _ := m.Session(httptest.NewRecorder(), httptest.NewRequest("GET", link, nil), conf)
+	if again.ID() != sess.ID() {
+		t.Fatal("sid was not read from the url")
+	}
+}
+
+//零值的Manage使用默认配置
+func TestZeroManage(t *testing.T) {
+	m := &session.Manage{}
+	if _, err := m.Session(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//记录传给provider的sid
+type sidRecorder struct {
+	session.Provider
+	mu   sync.Mutex
+	sids []string
+}
+
+func (p *sidRecorder) record(sid string) {
+	p.mu.Lock()
+	p.sids = append(p.sids, sid)
+	p.mu.Unlock()
+}
+
+func (p *sidRecorder) Exist(sid string) bool {
+	p.record(sid)
+	return p.Provider.Exist(sid)
+}
+
+func (p *sidRecorder) Read(sid string) (session.Sessioner, error) {
+	p.record(sid)
+	return p.Provider.Read(sid)
+}
+
+func (p *sidRecorder) Delete(sid string) error {
+	p.record(sid)
+	return p.Provider.Delete(sid)
+}
+
+//客户端伪造的sid不会传给provider
+func TestInvalidSid(t *testing.T) {
+	name := uniqueName("sidrecorder")
+	p := &sidRecorder{Provider: memory.NewMemory()}
+	session.Register(name, p)
+	m, err := session.NewManage(&session.ManagerConf{ProviderName: name, CookieName: "sid", EnableSetCookie: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := session.NewSID(64)
+	for _, sid := range []string{"user:alice", "lock:" + valid[5:], valid[1:], valid + "A", valid[1:] + "."} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: "sid", Value: sid})
+		sess, err := m.Session(httptest.NewRecorder(), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sess.ID() == sid {
+			t.Fatalf("forged sid %q was accepted", sid)
+		}
+		if err := m.DestroySession(httptest.NewRecorder(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, sid := range p.sids {
+		if !session.ValidSID(sid, 64) {
+			t.Fatalf("provider was called with %q", sid)
+		}
+	}
+	if !session.ValidSID(valid, 64) || session.ValidSID(valid, 32) {
+		t.Fatal("ValidSID does not match NewSID")
+	}
+}
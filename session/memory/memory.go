@@ -1,35 +1,328 @@
 package memory
 
 import (
+	"container/list"
+	"errors"
+	"hash/fnv"
 	"mux/session"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+//注册到session中的memory provider，可以通过它调整配置
+var Default = NewMemory()
+
 func init() {
-	session.Register("memory",NewMemroy())
+	session.Register("memory",Default)
 }
 
+//分片的数量，每个分片有自己的锁
+const shardCount = 32
+
+var ErrNotFound = errors.New("session: session not found")
+
+//并发安全的内存session存储
+//session按照sid分散到多个分片中，每个分片按照最后访问的时间维护一个LRU链表
 type Memory struct {
-	m sync.Map
+	shards [shardCount]*shard
+	//每个分片最多保存的session数量，0不限制
+	maxPerShard int64
+	locks       session.LocalLocker
+	//func(sid string)，淘汰session时调用
+	evicted atomic.Value
 }
 
-func NewMemroy() *Memory {
-	return nil
+type shard struct {
+	mu       sync.Mutex
+	sessions map[string]*list.Element
+	//front是最近访问的session
+	lru *list.List
+}
+
+func NewMemory() *Memory {
+	m := &Memory{}
+	for i := range m.shards {
+		m.shards[i] = &shard{
+			sessions: make(map[string]*list.Element),
+			lru:      list.New(),
+		}
+	}
+	return m
+}
+
+//限制session的最大数量，超出时淘汰最久没有访问的session，0不限制
+//数量按照分片平均分配，所以实际的上限会略大于n
+func (m *Memory) SetMaxSessions(n int) {
+	per := int64(0)
+	if n > 0 {
+		per = int64((n + shardCount - 1) / shardCount)
+	}
+	atomic.StoreInt64(&m.maxPerShard, per)
+}
+
+//设置淘汰session时的回调，在释放分片的锁之后调用
+func (m *Memory) SetEvictHandler(evicted func(sid string)) {
+	m.evicted.Store(evicted)
+}
+
+func (m *Memory) shard(sid string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(sid))
+	return m.shards[h.Sum32()%shardCount]
 }
 
 func (m *Memory) Create(sid string) (session.Sessioner, error) {
-	panic("")
+	s := m.shard(sid)
+	s.mu.Lock()
+	if e, ok := s.sessions[sid]; ok {
+		sess := e.Value.(*Session)
+		sess.touch()
+		s.lru.MoveToFront(e)
+		s.mu.Unlock()
+		return sess, nil
+	}
+	sess := newSession(sid)
+	evicted := m.insert(s, sess)
+	s.mu.Unlock()
+	m.notifyEvicted(evicted)
+	return sess, nil
 }
 
-func (*Memory) Read(sid string) (session.Sessioner, error) {
-	panic("implement me")
+func (m *Memory) Read(sid string) (session.Sessioner, error) {
+	s := m.shard(sid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[sid]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	sess := e.Value.(*Session)
+	sess.touch()
+	s.lru.MoveToFront(e)
+	return sess, nil
 }
 
-func (*Memory) Delete(sid string) error {
-	panic("implement me")
+func (m *Memory) Delete(sid string) error {
+	s := m.shard(sid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.sessions[sid]; ok {
+		s.remove(e)
+	}
+	return nil
+}
+
+func (m *Memory) Exist(sid string) bool {
+	s := m.shard(sid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.sessions[sid]
+	return ok
 }
 
-func (*Memory) GC(maxLifeTime int64) {
-	panic("implement me")
+//删除所有的session
+func (m *Memory) Reset() {
+	for _, s := range m.shards {
+		s.mu.Lock()
+		s.sessions = make(map[string]*list.Element)
+		s.lru.Init()
+		s.mu.Unlock()
+	}
 }
 
+//给session更换一个新的sid，session中的数据保持不变
+func (m *Memory) ReSid(sid string) (string, error) {
+	old := m.shard(sid)
+	old.mu.Lock()
+	e, ok := old.sessions[sid]
+	if !ok {
+		old.mu.Unlock()
+		return "", ErrNotFound
+	}
+	old.remove(e)
+	old.mu.Unlock()
+	sess := e.Value.(*Session)
+
+	for {
+		newSid := session.NewSIDLike(sid)
+		if newSid == "" {
+			return "", errors.New("session: failed to create sid")
+		}
+		s := m.shard(newSid)
+		s.mu.Lock()
+		if _, ok := s.sessions[newSid]; ok {
+			s.mu.Unlock()
+			continue
+		}
+		sess.mu.Lock()
+		sess.sid = newSid
+		sess.mu.Unlock()
+		sess.touch()
+		evicted := m.insert(s, sess)
+		s.mu.Unlock()
+		m.notifyEvicted(evicted)
+		return newSid, nil
+	}
+}
+
+//清理超过maxLifeTime秒没有访问的session
+func (m *Memory) GC(maxLifeTime int64) {
+	m.GCNotify(maxLifeTime, nil)
+}
+
+//与GC相同，并在释放锁之后对每个被清理的sid调用expired
+func (m *Memory) GCNotify(maxLifeTime int64, expired func(sid string)) {
+	deadline := time.Now().Add(-time.Duration(maxLifeTime) * time.Second).UnixNano()
+	for _, s := range m.shards {
+		var sids []string
+		s.mu.Lock()
+		for e := s.lru.Back(); e != nil; {
+			if e.Value.(*Session).lastAccessNano() > deadline {
+				break
+			}
+			prev := e.Prev()
+			if expired != nil {
+				sids = append(sids, e.Value.(*Session).ID())
+			}
+			s.remove(e)
+			e = prev
+		}
+		s.mu.Unlock()
+		for _, sid := range sids {
+			expired(sid)
+		}
+	}
+}
+
+//用户和session的关系保存在session的UserKey中，不需要单独的索引
+//删除、淘汰与更换sid之后索引自然保持一致
+func (m *Memory) AddUserSession(uid, sid string) error {
+	return nil
+}
+
+func (m *Memory) RemoveUserSession(uid, sid string) error {
+	return nil
+}
+
+//遍历所有的session，只在撤销用户的session时使用
+func (m *Memory) UserSessions(uid string) ([]string, error) {
+	var sids []string
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for sid, e := range s.sessions {
+			if u, _ := e.Value.(*Session).Get(session.UserKey).(string); u == uid {
+				sids = append(sids, sid)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return sids, nil
+}
+
+//给session加锁，同一个session的请求串行执行
+func (m *Memory) Lock(sid string, timeout time.Duration) (func(), error) {
+	return m.locks.Lock(sid, timeout)
+}
+
+//修改锁的最长持有时间，超过后锁自动释放，default:session.DefaultLockLease
+func (m *Memory) SetLockLease(d time.Duration) {
+	m.locks.SetLease(d)
+}
+
+//当前保存的session数量
+func (m *Memory) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		s.mu.Lock()
+		n += s.lru.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+//把session放到分片中，超出容量时先淘汰最久没有访问的session，返回被淘汰的sid
+//调用者需要持有分片的锁
+func (m *Memory) insert(s *shard, sess *Session) []string {
+	var evicted []string
+	if max := atomic.LoadInt64(&m.maxPerShard); max > 0 {
+		for int64(s.lru.Len()) >= max {
+			e := s.lru.Back()
+			evicted = append(evicted, e.Value.(*Session).ID())
+			s.remove(e)
+		}
+	}
+	s.sessions[sess.ID()] = s.lru.PushFront(sess)
+	return evicted
+}
+
+func (m *Memory) notifyEvicted(sids []string) {
+	if fn, _ := m.evicted.Load().(func(string)); fn != nil {
+		for _, sid := range sids {
+			fn(sid)
+		}
+	}
+}
+
+//调用者需要持有分片的锁
+func (s *shard) remove(e *list.Element) {
+	delete(s.sessions, e.Value.(*Session).ID())
+	s.lru.Remove(e)
+}
+
+//内存中的session，并发安全
+type Session struct {
+	mu         sync.RWMutex
+	sid        string
+	values     map[interface{}]interface{}
+	lastAccess int64
+}
+
+func newSession(sid string) *Session {
+	s := &Session{sid: sid, values: make(map[interface{}]interface{})}
+	s.touch()
+	return s
+}
+
+func (s *Session) touch() {
+	atomic.StoreInt64(&s.lastAccess, time.Now().UnixNano())
+}
+
+func (s *Session) lastAccessNano() int64 {
+	return atomic.LoadInt64(&s.lastAccess)
+}
+
+//最后一次被provider读取的时间
+func (s *Session) LastAccess() time.Time {
+	return time.Unix(0, s.lastAccessNano())
+}
+
+func (s *Session) Get(key interface{}) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+func (s *Session) Set(key, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = val
+}
+
+func (s *Session) Del(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+func (s *Session) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sid
+}
+
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[interface{}]interface{})
+}
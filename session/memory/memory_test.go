@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"mux/session"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//生成n个落在同一个分片中的sid
+func sameShard(m *Memory, n int) []string {
+	first := session.NewSID(16)
+	sids := []string{first}
+	for len(sids) < n {
+		if sid := session.NewSID(16); m.shard(sid) == m.shard(first) {
+			sids = append(sids, sid)
+		}
+	}
+	return sids
+}
+
+func TestLRU(t *testing.T) {
+	m := NewMemory()
+	m.SetMaxSessions(2 * shardCount)
+	var evicted []string
+	m.SetEvictHandler(func(sid string) { evicted = append(evicted, sid) })
+
+	sids := sameShard(m, 3)
+	m.Create(sids[0])
+	m.Create(sids[1])
+	//读取之后sids[0]是最近访问的，淘汰sids[1]
+	if _, err := m.Read(sids[0]); err != nil {
+		t.Fatal(err)
+	}
+	m.Create(sids[2])
+	if !m.Exist(sids[0]) || m.Exist(sids[1]) || !m.Exist(sids[2]) {
+		t.Fatalf("exist = %v %v %v", m.Exist(sids[0]), m.Exist(sids[1]), m.Exist(sids[2]))
+	}
+	if len(evicted) != 1 || evicted[0] != sids[1] {
+		t.Fatalf("evicted = %v, want [%s]", evicted, sids[1])
+	}
+
+	for i := 0; i < 10*shardCount; i++ {
+		m.Create(session.NewSID(16))
+	}
+	if n := m.Len(); n > 2*shardCount {
+		t.Fatalf("Len = %d, want at most %d", n, 2*shardCount)
+	}
+	if n := m.Len() + len(evicted); n != 10*shardCount+3 {
+		t.Fatalf("kept + evicted = %d, want %d", n, 10*shardCount+3)
+	}
+}
+
+func TestGC(t *testing.T) {
+	m := NewMemory()
+	idle, _ := m.Create("idle")
+	active, _ := m.Create("active")
+	atomic.StoreInt64(&idle.(*Session).lastAccess, time.Now().Add(-2*time.Minute).UnixNano())
+	active.(*Session).touch()
+
+	var expired []string
+	m.GCNotify(60, func(sid string) { expired = append(expired, sid) })
+	if m.Exist("idle") || !m.Exist("active") {
+		t.Fatalf("exist: idle=%v active=%v", m.Exist("idle"), m.Exist("active"))
+	}
+	if len(expired) != 1 || expired[0] != "idle" {
+		t.Fatalf("expired = %v", expired)
+	}
+}
+
+func TestReSid(t *testing.T) {
+	m := NewMemory()
+	sid := session.NewSID(16)
+	sess, _ := m.Create(sid)
+	sess.Set("user", "alice")
+	newSid, err := m.ReSid(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//新的sid和原来的长度相同，也就是保持了ManagerConf.SessionIDLength
+	if len(newSid) != len(sid) || newSid == sid {
+		t.Fatalf("new sid %q, old %q", newSid, sid)
+	}
+	if m.Exist(sid) {
+		t.Fatal("old sid still exists")
+	}
+	got, err := m.Read(newSid)
+	if err != nil || got.Get("user") != "alice" || got.ID() != newSid {
+		t.Fatalf("read new sid: %v %v", got, err)
+	}
+	if _, err := m.ReSid(sid); err != ErrNotFound {
+		t.Fatalf("ReSid of missing sid: %v", err)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	m := NewMemory()
+	m.SetMaxSessions(shardCount * 4)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				sid := strconv.Itoa(g) + "-" + strconv.Itoa(i%20)
+				sess, _ := m.Create(sid)
+				sess.Set("n", i)
+				sess.Get("n")
+				if s, err := m.Read(sid); err == nil {
+					s.Del("n")
+				}
+				switch i % 50 {
+				case 10:
+					m.ReSid(sid)
+				case 20:
+					m.Delete(sid)
+				case 30:
+					m.GC(3600)
+				case 40:
+					m.Len()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := m.Len(); n > shardCount*4 {
+		t.Fatalf("Len = %d", n)
+	}
+}
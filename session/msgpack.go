@@ -0,0 +1,687 @@
+package session
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+)
+
+//不依赖第三方库的msgpack实现，只支持session用到的部分
+//结构体编码为字段名到值的map，字段名可以通过msgpack标签修改，实现了encoding.TextMarshaler的类型编码为字符串
+//每个值编码为[类型名,值]，和json一样
+type msgpackCodec struct{}
+
+func (c msgpackCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.wrap(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c msgpackCodec) wrap(buf *bytes.Buffer, v interface{}) error {
+	buf.WriteByte(0x92)
+	switch v := v.(type) {
+	case nil:
+		writeString(buf, "")
+		buf.WriteByte(0xc0)
+		return nil
+	case map[interface{}]interface{}:
+		writeString(buf, mapType)
+		writeMapLen(buf, len(v))
+		for k, val := range v {
+			if err := c.wrap(buf, k); err != nil {
+				return err
+			}
+			if err := c.wrap(buf, val); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		writeString(buf, listType)
+		writeArrayLen(buf, len(v))
+		for _, e := range v {
+			if err := c.wrap(buf, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	writeString(buf, typeName(rv.Type()))
+	return encodeMsgpack(buf, rv)
+}
+
+func (c msgpackCodec) Decode(data []byte) (interface{}, error) {
+	r := bytes.NewReader(data)
+	v, err := c.unwrap(r)
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	return v, nil
+}
+
+func (c msgpackCodec) unwrap(r *bytes.Reader) (interface{}, error) {
+	n, err := readArrayLen(r)
+	if err != nil {
+		return nil, err
+	}
+	if n != 2 {
+		return nil, errors.New("invalid value")
+	}
+	name, err := decodeMsgpack(r)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := name.(string)
+	if !ok {
+		return nil, errors.New("invalid type name")
+	}
+	switch s {
+	case "":
+		_, err := decodeMsgpack(r)
+		return nil, err
+	case mapType:
+		n, err := readMapLen(r)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := c.unwrap(r)
+			if err != nil {
+				return nil, err
+			}
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return nil, fmt.Errorf("key of type %T is not comparable", k)
+			}
+			v, err := c.unwrap(r)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case listType:
+		n, err := readArrayLen(r)
+		if err != nil {
+			return nil, err
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = c.unwrap(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	v, err := decodeMsgpack(r)
+	if err != nil {
+		return nil, err
+	}
+	//没有注册的类型返回通用的结构
+	t, ok := lookupType(s)
+	if !ok {
+		return v, nil
+	}
+	ptr := reflect.New(t)
+	if err := assign(ptr.Elem(), v); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+func encodeMsgpack(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+	if v.Type().Implements(textMarshalerType) && v.Kind() == reflect.Struct {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		writeString(buf, string(text))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		return encodeMsgpack(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeInt(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeUint(buf, v.Uint())
+	case reflect.Float32:
+		buf.WriteByte(0xca)
+		binary.Write(buf, binary.BigEndian, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v.Float()))
+	case reflect.String:
+		writeString(buf, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			bs := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(bs), v)
+			writeBytes(buf, bs)
+			return nil
+		}
+		writeArrayLen(buf, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeMsgpack(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		writeMapLen(buf, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := encodeMsgpack(buf, iter.Key()); err != nil {
+				return err
+			}
+			if err := encodeMsgpack(buf, iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		writeMapLen(buf, len(fields))
+		for _, f := range fields {
+			writeString(buf, f.name)
+			if err := encodeMsgpack(buf, v.FieldByIndex(f.index)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("session: msgpack can not encode %s", v.Type())
+	}
+	return nil
+}
+
+type structField struct {
+	name  string
+	index []int
+}
+
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("msgpack"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			name = strings.Split(tag, ",")[0]
+		}
+		fields = append(fields, structField{name: name, index: f.Index})
+	}
+	return fields
+}
+
+func writeInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0:
+		writeUint(buf, uint64(n))
+	case n >= -32:
+		buf.WriteByte(byte(n))
+	case n >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(n))
+	case n >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(n))
+	case n >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(n))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func writeUint(buf *bytes.Buffer, n uint64) {
+	switch {
+	case n < 128:
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.WriteString(s)
+}
+
+func writeBytes(buf *bytes.Buffer, bs []byte) {
+	n := len(bs)
+	switch {
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xc4)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xc5)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xc6)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.Write(bs)
+}
+
+func writeArrayLen(buf *bytes.Buffer, n int) {
+	switch {
+	case n < 16:
+		buf.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xdc)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdd)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMapLen(buf *bytes.Buffer, n int) {
+	switch {
+	case n < 16:
+		buf.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xde)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdf)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func readArrayLen(r *bytes.Reader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case b&0xf0 == 0x90:
+		return int(b & 0x0f), nil
+	case b == 0xdc:
+		return readLen(r, 2)
+	case b == 0xdd:
+		return readLen(r, 4)
+	}
+	return 0, errors.New("expected array")
+}
+
+func readMapLen(r *bytes.Reader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case b&0xf0 == 0x80:
+		return int(b & 0x0f), nil
+	case b == 0xde:
+		return readLen(r, 2)
+	case b == 0xdf:
+		return readLen(r, 4)
+	}
+	return 0, errors.New("expected map")
+}
+
+//长度不能超过剩余的数据，损坏的数据不会导致分配大量内存
+func readLen(r *bytes.Reader, size int) (int, error) {
+	bs := make([]byte, size)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, b := range bs {
+		n = n<<8 | uint64(b)
+	}
+	if n > uint64(r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func readN(r *bytes.Reader, n int) ([]byte, error) {
+	bs := make([]byte, n)
+	_, err := io.ReadFull(r, bs)
+	return bs, err
+}
+
+//解码成通用的结构：nil、bool、int64、uint64、float64、string、[]byte、[]interface{}
+//map的key都是字符串时为map[string]interface{}，否则为map[interface{}]interface{}
+func decodeMsgpack(r *bytes.Reader) (interface{}, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		bs, err := readN(r, int(b&0x1f))
+		return string(bs), err
+	case b&0xf0 == 0x90, b == 0xdc, b == 0xdd:
+		r.UnreadByte()
+		n, err := readArrayLen(r)
+		if err != nil {
+			return nil, err
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = decodeMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case b&0xf0 == 0x80, b == 0xde, b == 0xdf:
+		r.UnreadByte()
+		n, err := readMapLen(r)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMap(r, n)
+	}
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readLen(r, 1<<(b-0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return readN(r, n)
+	case 0xd9, 0xda, 0xdb:
+		n, err := readLen(r, 1<<(b-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		bs, err := readN(r, n)
+		return string(bs), err
+	case 0xca:
+		bs, err := readN(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(bs))), nil
+	case 0xcb:
+		bs, err := readN(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(bs)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		bs, err := readN(r, 1<<(b-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		var n uint64
+		for _, c := range bs {
+			n = n<<8 | uint64(c)
+		}
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		return n, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		bs, err := readN(r, size)
+		if err != nil {
+			return nil, err
+		}
+		var n uint64
+		for _, c := range bs {
+			n = n<<8 | uint64(c)
+		}
+		//符号扩展
+		shift := uint(64 - size*8)
+		return int64(n<<shift) >> shift, nil
+	}
+	return nil, fmt.Errorf("unsupported msgpack type 0x%x", b)
+}
+
+func decodeMap(r *bytes.Reader, n int) (interface{}, error) {
+	keys := make([]interface{}, n)
+	vals := make([]interface{}, n)
+	allString := true
+	for i := 0; i < n; i++ {
+		k, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := k.(string); !ok {
+			allString = false
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return nil, fmt.Errorf("key of type %T is not comparable", k)
+			}
+		}
+		if vals[i], err = decodeMsgpack(r); err != nil {
+			return nil, err
+		}
+		keys[i] = k
+	}
+	if allString {
+		m := make(map[string]interface{}, n)
+		for i, k := range keys {
+			m[k.(string)] = vals[i]
+		}
+		return m, nil
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i, k := range keys {
+		m[k] = vals[i]
+	}
+	return m, nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+//把通用的结构赋值给具体的类型
+func assign(dst reflect.Value, v interface{}) error {
+	if v == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if s, ok := v.(string); ok && dst.Kind() == reflect.Struct && reflect.PtrTo(dst.Type()).Implements(textUnmarshalerType) {
+		return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	mismatch := fmt.Errorf("can not assign %T to %s", v, dst.Type())
+	switch dst.Kind() {
+	case reflect.Interface:
+		rv := reflect.ValueOf(v)
+		if !rv.Type().AssignableTo(dst.Type()) {
+			return mismatch
+		}
+		dst.Set(rv)
+	case reflect.Ptr:
+		p := reflect.New(dst.Type().Elem())
+		if err := assign(p.Elem(), v); err != nil {
+			return err
+		}
+		dst.Set(p)
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return mismatch
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := v.(int64)
+		if !ok || dst.OverflowInt(n) {
+			return mismatch
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch v := v.(type) {
+		case int64:
+			if v < 0 {
+				return mismatch
+			}
+			n = uint64(v)
+		case uint64:
+			n = v
+		default:
+			return mismatch
+		}
+		if dst.OverflowUint(n) {
+			return mismatch
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, ok := v.(float64)
+		if !ok {
+			return mismatch
+		}
+		dst.SetFloat(f)
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return mismatch
+		}
+		dst.SetString(s)
+	case reflect.Slice:
+		if bs, ok := v.([]byte); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes(append([]byte(nil), bs...))
+			return nil
+		}
+		arr, ok := v.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		s := reflect.MakeSlice(dst.Type(), len(arr), len(arr))
+		for i, e := range arr {
+			if err := assign(s.Index(i), e); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+	case reflect.Array:
+		if bs, ok := v.([]byte); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			if len(bs) != dst.Len() {
+				return mismatch
+			}
+			reflect.Copy(dst, reflect.ValueOf(bs))
+			return nil
+		}
+		arr, ok := v.([]interface{})
+		if !ok || len(arr) != dst.Len() {
+			return mismatch
+		}
+		for i, e := range arr {
+			if err := assign(dst.Index(i), e); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m := reflect.MakeMap(dst.Type())
+		set := func(k, e interface{}) error {
+			kv := reflect.New(dst.Type().Key()).Elem()
+			if err := assign(kv, k); err != nil {
+				return err
+			}
+			ev := reflect.New(dst.Type().Elem()).Elem()
+			if err := assign(ev, e); err != nil {
+				return err
+			}
+			m.SetMapIndex(kv, ev)
+			return nil
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, e := range v {
+				if err := set(k, e); err != nil {
+					return err
+				}
+			}
+		case map[interface{}]interface{}:
+			for k, e := range v {
+				if err := set(k, e); err != nil {
+					return err
+				}
+			}
+		default:
+			return mismatch
+		}
+		dst.Set(m)
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		//新版本中删除的字段直接忽略
+		for _, f := range structFields(dst.Type()) {
+			e, ok := m[f.name]
+			if !ok {
+				continue
+			}
+			if err := assign(dst.FieldByIndex(f.index), e); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch
+	}
+	return nil
+}
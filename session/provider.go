@@ -1,5 +1,10 @@
 package session
 
+import (
+	"errors"
+	"time"
+)
+
 //实现session存储的接口
 type Provider interface {
 	Create(sid string) (Sessioner,error)
@@ -11,6 +16,16 @@ type Provider interface {
 	Exist(sid string) bool
 }
 
+//等待session的锁超时
+var ErrLockTimeout = errors.New("session: timed out waiting for the session lock")
+
+//provider可以实现它，让同一个session的请求串行执行，避免并发的请求互相覆盖
+//ManagerConf.LockTimeout大于0时Manage在读取session之前加锁，请求结束保存之后解锁
+//等待超过timeout时返回ErrLockTimeout
+type Locker interface {
+	Lock(sid string,timeout time.Duration) (unlock func(),err error)
+}
+
 var Providers = make(map[string]Provider)
 
 //驱动应当主动调用Register，把自己注入
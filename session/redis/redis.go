@@ -0,0 +1,414 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"mux/session"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//注册到session中的redis provider，连接本机的6379端口，可以通过SetClient修改
+var Default = New(NewPool("127.0.0.1:6379", "", 0, 0, 0), "")
+
+func init() {
+	session.Register("redis", Default)
+}
+
+var ErrNotFound = errors.New("session: session not found")
+
+//hash中标记session存在的字段，保存创建时间，空的session也会有这个字段
+const createdField = "_created"
+
+//session中的key编码后加上这个前缀作为hash的字段名，和createdField区分开
+const keyPrefix = "k"
+
+//使用redis保存session，每个session是一个hash，session中的每个key是hash中的一个字段
+//key设置了过期时间，每次访问都会刷新，过期的清理由redis完成
+type Redis struct {
+	mu     sync.RWMutex
+	client Client
+	prefix string
+	codec  session.Codec
+	//过期时间，单位秒
+	ttl int64
+	//锁的过期时间，单位毫秒
+	lease int64
+}
+
+//prefix为key的前缀，default:"mux:session:"
+func New(client Client, prefix string) *Redis {
+	if prefix == "" {
+		prefix = "mux:session:"
+	}
+	return &Redis{client: client, prefix: prefix, ttl: 3600, lease: 60000}
+}
+
+//替换执行命令的客户端，可以使用其他的redis库
+func (r *Redis) SetClient(client Client) {
+	r.mu.Lock()
+	r.client = client
+	r.mu.Unlock()
+}
+
+//session的过期时间，default:1h，Manager启动的GC也会把它设置为ManagerConf.GCTime
+func (r *Redis) SetTTL(d time.Duration) {
+	if s := int64(d / time.Second); s > 0 {
+		atomic.StoreInt64(&r.ttl, s)
+	}
+}
+
+//修改序列化session使用的codec，为nil时使用session.DefaultCodec
+func (r *Redis) SetCodec(c session.Codec) {
+	r.mu.Lock()
+	r.codec = c
+	r.mu.Unlock()
+}
+
+func (r *Redis) getCodec() session.Codec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.codec == nil {
+		return session.DefaultCodec
+	}
+	return r.codec
+}
+
+func (r *Redis) do(args ...string) (interface{}, error) {
+	r.mu.RLock()
+	c := r.client
+	r.mu.RUnlock()
+	return c.Do(args...)
+}
+
+//session、锁与用户索引使用不同的命名空间，sid无论是什么都不会和其他的key重叠
+func (r *Redis) key(sid string) string {
+	return r.prefix + "s:" + sid
+}
+
+func (r *Redis) expire(sid string) error {
+	_, err := r.do("EXPIRE", r.key(sid), strconv.FormatInt(atomic.LoadInt64(&r.ttl), 10))
+	return err
+}
+
+func (r *Redis) Create(sid string) (session.Sessioner, error) {
+	reply, err := r.do("HSETNX", r.key(sid), createdField, strconv.FormatInt(time.Now().Unix(), 10))
+	if err != nil {
+		return nil, err
+	}
+	//已经存在时读取原来的session
+	if n, _ := reply.(int64); n == 0 {
+		return r.Read(sid)
+	}
+	if err := r.expire(sid); err != nil {
+		return nil, err
+	}
+	return newSession(r, sid, make(map[interface{}]interface{})), nil
+}
+
+func (r *Redis) Read(sid string) (session.Sessioner, error) {
+	reply, err := r.do("HGETALL", r.key(sid))
+	if err != nil {
+		return nil, err
+	}
+	arr, _ := reply.([]interface{})
+	if len(arr) == 0 {
+		return nil, ErrNotFound
+	}
+	codec := r.getCodec()
+	values := make(map[interface{}]interface{}, len(arr)/2)
+	for i := 0; i+1 < len(arr); i += 2 {
+		field, _ := arr[i].([]byte)
+		if !bytes.HasPrefix(field, []byte(keyPrefix)) {
+			continue
+		}
+		//无法解析的字段直接跳过，不影响其他的字段
+		key, err := codec.Decode(field[len(keyPrefix):])
+		if err != nil || (key != nil && !reflect.TypeOf(key).Comparable()) {
+			continue
+		}
+		bs, _ := arr[i+1].([]byte)
+		val, err := codec.Decode(bs)
+		if err != nil {
+			continue
+		}
+		values[key] = val
+	}
+	if err := r.expire(sid); err != nil {
+		return nil, err
+	}
+	return newSession(r, sid, values), nil
+}
+
+func (r *Redis) Delete(sid string) error {
+	_, err := r.do("DEL", r.key(sid))
+	return err
+}
+
+func (r *Redis) Exist(sid string) bool {
+	reply, err := r.do("EXISTS", r.key(sid))
+	n, _ := reply.(int64)
+	return err == nil && n > 0
+}
+
+//删除所有前缀匹配的session
+func (r *Redis) Reset() {
+	cursor := "0"
+	for {
+		reply, err := r.do("SCAN", cursor, "MATCH", escapePattern(r.prefix)+"*", "COUNT", "100")
+		if err != nil {
+			return
+		}
+		arr, _ := reply.([]interface{})
+		if len(arr) != 2 {
+			return
+		}
+		next, _ := arr[0].([]byte)
+		keys, _ := arr[1].([]interface{})
+		if len(keys) > 0 {
+			args := make([]string, 0, len(keys)+1)
+			args = append(args, "DEL")
+			for _, k := range keys {
+				if bs, ok := k.([]byte); ok {
+					args = append(args, string(bs))
+				}
+			}
+			r.do(args...)
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+//通过RENAMENX原子地更换sid，新的sid已经存在时重新生成
+func (r *Redis) ReSid(sid string) (string, error) {
+	for {
+		newSid := session.NewSIDLike(sid)
+		if newSid == "" {
+			return "", errors.New("session: failed to create sid")
+		}
+		reply, err := r.do("RENAMENX", r.key(sid), r.key(newSid))
+		if e, ok := err.(Error); ok && strings.Contains(string(e), "no such key") {
+			return "", ErrNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+		if n, _ := reply.(int64); n == 0 {
+			continue
+		}
+		return newSid, r.expire(newSid)
+	}
+}
+
+//过期的session由redis清理，这里只记录过期时间
+func (r *Redis) GC(maxLifeTime int64) {
+	if maxLifeTime > 0 {
+		atomic.StoreInt64(&r.ttl, maxLifeTime)
+	}
+}
+
+//锁被占用时重试的间隔
+const lockRetry = 20 * time.Millisecond
+
+//只删除自己持有的锁，锁过期后被其他请求获得时不会误删
+const unlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+
+//锁最长持有的时间，超过后其他请求可以获得锁，应当大于请求的处理时间，default:1m
+func (r *Redis) SetLockLease(d time.Duration) {
+	if ms := int64(d / time.Millisecond); ms > 0 {
+		atomic.StoreInt64(&r.lease, ms)
+	}
+}
+
+//通过SET NX给session加锁，锁在lease之后自动过期
+func (r *Redis) Lock(sid string, timeout time.Duration) (func(), error) {
+	key := r.prefix + "lock:" + sid
+	token := session.NewSID(16)
+	lease := strconv.FormatInt(atomic.LoadInt64(&r.lease), 10)
+	deadline := time.Now().Add(timeout)
+	for {
+		reply, err := r.do("SET", key, token, "NX", "PX", lease)
+		if err != nil {
+			return nil, err
+		}
+		if reply != nil {
+			return func() {
+				r.do("EVAL", unlockScript, "1", key, token)
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, session.ErrLockTimeout
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+func (r *Redis) userKey(uid string) string {
+	return r.prefix + "user:" + uid
+}
+
+//用户的所有sid保存在一个set中，过期时间和session相同，每次添加时刷新
+func (r *Redis) AddUserSession(uid, sid string) error {
+	key := r.userKey(uid)
+	if _, err := r.do("SADD", key, sid); err != nil {
+		return err
+	}
+	_, err := r.do("EXPIRE", key, strconv.FormatInt(atomic.LoadInt64(&r.ttl), 10))
+	return err
+}
+
+func (r *Redis) RemoveUserSession(uid, sid string) error {
+	_, err := r.do("SREM", r.userKey(uid), sid)
+	return err
+}
+
+//set中可能有已经过期的sid，删除它们不会有任何影响
+func (r *Redis) UserSessions(uid string) ([]string, error) {
+	reply, err := r.do("SMEMBERS", r.userKey(uid))
+	if err != nil {
+		return nil, err
+	}
+	arr, _ := reply.([]interface{})
+	sids := make([]string, 0, len(arr))
+	for _, v := range arr {
+		if bs, ok := v.([]byte); ok {
+			sids = append(sids, string(bs))
+		}
+	}
+	return sids, nil
+}
+
+//redis的glob中有特殊含义的字符需要转义
+func escapePattern(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+//redis中的session，记录修改过的key，Save时只写入这些字段
+type Session struct {
+	provider *Redis
+	mu       sync.RWMutex
+	sid      string
+	values   map[interface{}]interface{}
+	changed  map[interface{}]bool
+	reset    bool
+}
+
+func newSession(r *Redis, sid string, values map[interface{}]interface{}) *Session {
+	return &Session{provider: r, sid: sid, values: values, changed: make(map[interface{}]bool)}
+}
+
+func (s *Session) Get(key interface{}) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+func (s *Session) Set(key, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = val
+	s.changed[key] = true
+}
+
+func (s *Session) Del(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.changed[key] = true
+}
+
+func (s *Session) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sid
+}
+
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[interface{}]interface{})
+	s.changed = make(map[interface{}]bool)
+	s.reset = true
+}
+
+//在一个脚本中检查session是否存在并写入，删除或者更换sid与保存之间不会有其他命令插入
+//ARGV：过期时间、Reset时新的创建时间（没有Reset时为空）、写入的字段与值的个数、写入的字段与值、删除的字段
+const saveScript = `if redis.call("EXISTS", KEYS[1]) == 0 then return 0 end
+if ARGV[2] ~= "" then
+	redis.call("DEL", KEYS[1])
+	redis.call("HSET", KEYS[1], "` + createdField + `", ARGV[2])
+end
+local n = tonumber(ARGV[3])
+for i = 4, 3 + n, 2 do redis.call("HSET", KEYS[1], ARGV[i], ARGV[i + 1]) end
+for i = 4 + n, #ARGV do redis.call("HDEL", KEYS[1], ARGV[i]) end
+redis.call("EXPIRE", KEYS[1], ARGV[1])
+return 1`
+
+//把修改过的字段写入redis，没有修改时什么都不做
+//其他请求修改的字段不会被覆盖
+func (s *Session) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.reset && len(s.changed) == 0 {
+		return nil
+	}
+	r := s.provider
+	created := ""
+	if s.reset {
+		created = strconv.FormatInt(time.Now().Unix(), 10)
+		for k := range s.values {
+			s.changed[k] = true
+		}
+	}
+
+	codec := r.getCodec()
+	var set, del []string
+	for k := range s.changed {
+		bs, err := codec.Encode(k)
+		if err != nil {
+			return err
+		}
+		field := keyPrefix + string(bs)
+		v, ok := s.values[k]
+		if !ok {
+			del = append(del, field)
+			continue
+		}
+		val, err := codec.Encode(v)
+		if err != nil {
+			return err
+		}
+		set = append(set, field, string(val))
+	}
+	args := make([]string, 0, 7+len(set)+len(del))
+	args = append(args, "EVAL", saveScript, "1", r.key(s.sid),
+		strconv.FormatInt(atomic.LoadInt64(&r.ttl), 10), created, strconv.Itoa(len(set)))
+	args = append(args, set...)
+	args = append(args, del...)
+	reply, err := r.do(args...)
+	if err != nil {
+		return err
+	}
+	//session已经被删除或者更换了sid时不会重新创建
+	if n, _ := reply.(int64); n == 0 {
+		return ErrNotFound
+	}
+	s.changed = make(map[interface{}]bool)
+	s.reset = false
+	return nil
+}
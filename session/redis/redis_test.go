@@ -0,0 +1,429 @@
+package redis
+
+import (
+	"bufio"
+	"mux/session"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//只实现provider用到的命令的内存redis
+type fakeServer struct {
+	ln     net.Listener
+	mu     sync.Mutex
+	hashes map[string]map[string]string
+	sets   map[string]map[string]bool
+	strs   map[string]string
+	ttls   map[string]int64
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, hashes: make(map[string]map[string]string), sets: make(map[string]map[string]bool), strs: make(map[string]string), ttls: make(map[string]int64)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	bw := bufio.NewWriter(c)
+	for {
+		reply, err := readReply(br)
+		if err != nil {
+			return
+		}
+		arr := reply.([]interface{})
+		args := make([]string, len(arr))
+		for i, a := range arr {
+			args[i] = string(a.([]byte))
+		}
+		s.mu.Lock()
+		writeValue(bw, s.exec(args))
+		s.mu.Unlock()
+		bw.Flush()
+	}
+}
+
+func (s *fakeServer) exec(args []string) interface{} {
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "HSETNX":
+		h := s.hashes[args[1]]
+		if h == nil {
+			h = make(map[string]string)
+			s.hashes[args[1]] = h
+		}
+		if _, ok := h[args[2]]; ok {
+			return int64(0)
+		}
+		h[args[2]] = args[3]
+		return int64(1)
+	case "HSET":
+		h := s.hashes[args[1]]
+		if h == nil {
+			h = make(map[string]string)
+			s.hashes[args[1]] = h
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			h[args[i]] = args[i+1]
+		}
+		return int64((len(args) - 2) / 2)
+	case "HDEL":
+		h := s.hashes[args[1]]
+		for _, f := range args[2:] {
+			delete(h, f)
+		}
+		if len(h) == 0 {
+			delete(s.hashes, args[1])
+		}
+		return int64(len(args) - 2)
+	case "HGETALL":
+		var arr []interface{}
+		for k, v := range s.hashes[args[1]] {
+			arr = append(arr, []byte(k), []byte(v))
+		}
+		return arr
+	case "EXPIRE":
+		if _, ok := s.hashes[args[1]]; !ok {
+			return int64(0)
+		}
+		n, _ := strconv.ParseInt(args[2], 10, 64)
+		s.ttls[args[1]] = n
+		return int64(1)
+	case "EXISTS":
+		if _, ok := s.hashes[args[1]]; ok {
+			return int64(1)
+		}
+		return int64(0)
+	case "DEL":
+		n := int64(0)
+		for _, k := range args[1:] {
+			if _, ok := s.hashes[k]; ok {
+				delete(s.hashes, k)
+				delete(s.ttls, k)
+				n++
+			}
+		}
+		return n
+	case "RENAMENX":
+		h, ok := s.hashes[args[1]]
+		if !ok {
+			return Error("ERR no such key")
+		}
+		if _, ok := s.hashes[args[2]]; ok {
+			return int64(0)
+		}
+		delete(s.hashes, args[1])
+		s.hashes[args[2]] = h
+		s.ttls[args[2]] = s.ttls[args[1]]
+		delete(s.ttls, args[1])
+		return int64(1)
+	case "SADD":
+		set := s.sets[args[1]]
+		if set == nil {
+			set = make(map[string]bool)
+			s.sets[args[1]] = set
+		}
+		for _, m := range args[2:] {
+			set[m] = true
+		}
+		return int64(len(args) - 2)
+	case "SREM":
+		for _, m := range args[2:] {
+			delete(s.sets[args[1]], m)
+		}
+		return int64(len(args) - 2)
+	case "SMEMBERS":
+		var arr []interface{}
+		for m := range s.sets[args[1]] {
+			arr = append(arr, []byte(m))
+		}
+		return arr
+	case "SET":
+		//只支持SET key value NX PX ms
+		if _, ok := s.strs[args[1]]; ok {
+			return nil
+		}
+		s.strs[args[1]] = args[2]
+		return "OK"
+	case "EVAL":
+		if args[1] == saveScript {
+			return s.save(args[3], args[4:])
+		}
+		//只支持unlockScript
+		if s.strs[args[3]] != args[4] {
+			return int64(0)
+		}
+		delete(s.strs, args[3])
+		return int64(1)
+	case "SCAN":
+		var keys []interface{}
+		pattern := strings.Replace(args[3], `\`, "", -1)
+		for k := range s.hashes {
+			if ok, _ := path.Match(pattern, k); ok {
+				keys = append(keys, []byte(k))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}
+	}
+	return Error("ERR unknown command '" + cmd + "'")
+}
+
+//按照saveScript的逻辑执行，持有s.mu，和真正的脚本一样是原子的
+func (s *fakeServer) save(key string, argv []string) interface{} {
+	if _, ok := s.hashes[key]; !ok {
+		return int64(0)
+	}
+	if argv[1] != "" {
+		s.exec([]string{"DEL", key})
+		s.exec([]string{"HSET", key, createdField, argv[1]})
+	}
+	n, _ := strconv.Atoi(argv[2])
+	for i := 3; i < 3+n; i += 2 {
+		s.exec([]string{"HSET", key, argv[i], argv[i+1]})
+	}
+	for _, f := range argv[3+n:] {
+		s.exec([]string{"HDEL", key, f})
+	}
+	s.exec([]string{"EXPIRE", key, argv[0]})
+	return int64(1)
+}
+
+func writeValue(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("+" + v + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case []byte:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n")
+		w.Write(v)
+		w.WriteString("\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			writeValue(w, e)
+		}
+	}
+}
+
+func newTestRedis(t *testing.T) (*Redis, *fakeServer) {
+	srv := newFakeServer(t)
+	pool := NewPool(srv.ln.Addr().String(), "", 0, 2, time.Second)
+	t.Cleanup(func() { pool.Close() })
+	return New(pool, "test:"), srv
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	r, srv := newTestRedis(t)
+	r.SetTTL(10 * time.Minute)
+
+	sess, err := r.Create("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Set("name", "mux")
+	sess.Set(1, []string{"x", "y"})
+	if err := sess.(*Session).Save(); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := srv.ttls["test:s:a"]; ttl != 600 {
+		t.Fatalf("ttl = %d, want 600", ttl)
+	}
+
+	got, err := r.Read("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Get("name"); v != "mux" {
+		t.Fatalf("name = %v", v)
+	}
+	if v, _ := got.Get(1).([]string); len(v) != 2 || v[1] != "y" {
+		t.Fatalf("1 = %v", got.Get(1))
+	}
+
+	got.Del("name")
+	if err := got.(*Session).Save(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = r.Read("a")
+	if got.Get("name") != nil {
+		t.Fatal("name was not deleted")
+	}
+
+	if err := r.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if r.Exist("a") {
+		t.Fatal("session still exists after Delete")
+	}
+	if _, err := r.Read("a"); err != ErrNotFound {
+		t.Fatalf("Read after Delete: %v", err)
+	}
+}
+
+func TestSaveKeepsOtherFields(t *testing.T) {
+	r, _ := newTestRedis(t)
+	r.Create("a")
+	s1, _ := r.Read("a")
+	s2, _ := r.Read("a")
+	s1.Set("x", 1)
+	s2.Set("y", 2)
+	if err := s1.(*Session).Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.(*Session).Save(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := r.Read("a")
+	if got.Get("x") != 1 || got.Get("y") != 2 {
+		t.Fatalf("x = %v, y = %v", got.Get("x"), got.Get("y"))
+	}
+}
+
+func TestReSid(t *testing.T) {
+	r, srv := newTestRedis(t)
+	sess, _ := r.Create("a")
+	sess.Set("k", "v")
+	sess.(*Session).Save()
+
+	sid, err := r.ReSid("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Exist("a") || !r.Exist(sid) {
+		t.Fatal("session was not renamed")
+	}
+	if srv.ttls["test:s:"+sid] == 0 {
+		t.Fatal("ttl was not kept")
+	}
+	got, _ := r.Read(sid)
+	if got.Get("k") != "v" {
+		t.Fatalf("k = %v", got.Get("k"))
+	}
+	//旧的session对象不能重新创建已经改名的key
+	sess.Set("k", "old")
+	if err := sess.(*Session).Save(); err != ErrNotFound {
+		t.Fatalf("Save after ReSid: %v", err)
+	}
+	if _, err := r.ReSid("missing"); err != ErrNotFound {
+		t.Fatalf("ReSid missing: %v", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	r, srv := newTestRedis(t)
+	r.Create("a")
+	r.Create("b")
+	srv.hashes["other"] = map[string]string{"f": "v"}
+	r.Reset()
+	if r.Exist("a") || r.Exist("b") {
+		t.Fatal("sessions still exist after Reset")
+	}
+	if _, ok := srv.hashes["other"]; !ok {
+		t.Fatal("Reset deleted a key without the prefix")
+	}
+}
+
+func TestUserIndex(t *testing.T) {
+	r, _ := newTestRedis(t)
+	r.AddUserSession("u1", "a")
+	r.AddUserSession("u1", "b")
+	r.AddUserSession("u2", "c")
+	r.RemoveUserSession("u1", "a")
+	sids, err := r.UserSessions("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sids) != 1 || sids[0] != "b" {
+		t.Fatalf("sids = %v, want [b]", sids)
+	}
+}
+
+func TestLock(t *testing.T) {
+	r, _ := newTestRedis(t)
+	unlock, err := r.Lock("a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Lock("a", 50*time.Millisecond); err != session.ErrLockTimeout {
+		t.Fatalf("second Lock = %v, want ErrLockTimeout", err)
+	}
+	unlock()
+	unlock2, err := r.Lock("a", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock2()
+}
+
+//sid不能访问锁和用户索引的key
+func TestNamespaces(t *testing.T) {
+	r, srv := newTestRedis(t)
+	r.AddUserSession("u1", "a")
+	unlock, _ := r.Lock("a", time.Second)
+	defer unlock()
+	if r.Exist("user:u1") || r.Exist("lock:a") {
+		t.Fatal("index or lock key is visible as a session")
+	}
+	r.Delete("user:u1")
+	if sids, _ := r.UserSessions("u1"); len(sids) != 1 {
+		t.Fatalf("user index was deleted through a session sid: %v", sids)
+	}
+	r.Create("user:u1")
+	if _, ok := srv.hashes["test:s:user:u1"]; !ok {
+		t.Fatal("session was not created in its own namespace")
+	}
+}
+
+//Save在脚本中检查并写入，session被删除后不会重新创建
+func TestSaveAfterDelete(t *testing.T) {
+	r, srv := newTestRedis(t)
+	sess, _ := r.Create("a")
+	r.Delete("a")
+	sess.Set("k", "v")
+	if err := sess.(*Session).Save(); err != ErrNotFound {
+		t.Fatalf("Save after Delete: %v", err)
+	}
+	if _, ok := srv.hashes["test:s:a"]; ok {
+		t.Fatal("deleted session was recreated")
+	}
+
+	sess, _ = r.Create("b")
+	sess.Set("x", 1)
+	sess.(*Session).Save()
+	sess.Reset()
+	sess.Set("y", 2)
+	if err := sess.(*Session).Save(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := r.Read("b")
+	if got.Get("x") != nil || got.Get("y") != 2 {
+		t.Fatalf("x = %v, y = %v after Reset", got.Get("x"), got.Get("y"))
+	}
+	if _, ok := srv.hashes["test:s:b"][createdField]; !ok {
+		t.Fatal("created field was not written after Reset")
+	}
+}
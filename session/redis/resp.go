@@ -0,0 +1,219 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//执行redis命令的客户端，可以替换为其他的redis库
+//返回值与RESP的类型对应：简单字符串为string，整数为int64，批量字符串为[]byte，不存在为nil，数组为[]interface{}
+//服务端返回的错误应当作为error返回
+type Client interface {
+	Do(args ...string) (interface{}, error)
+}
+
+//服务端返回的错误，例如"ERR no such key"
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+//直接使用RESP协议的连接池
+type Pool struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	idle   []*conn
+	max    int
+	closed bool
+}
+
+type conn struct {
+	c  net.Conn
+	br *bufio.Reader
+	bw *bufio.Writer
+}
+
+//创建连接池，连接在第一次执行命令时才会建立
+//maxIdle为保留的空闲连接数量，default:8
+func NewPool(addr, password string, db, maxIdle int, timeout time.Duration) *Pool {
+	if maxIdle <= 0 {
+		maxIdle = 8
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Pool{addr: addr, password: password, db: db, max: maxIdle, timeout: timeout}
+}
+
+func (p *Pool) Do(args ...string) (interface{}, error) {
+	c, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(p.timeout, args)
+	//服务端返回的错误不影响连接的状态
+	if _, ok := err.(Error); err != nil && !ok {
+		c.c.Close()
+		return nil, err
+	}
+	p.put(c)
+	return reply, err
+}
+
+//关闭所有的空闲连接
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, c := range p.idle {
+		c.c.Close()
+	}
+	p.idle = nil
+	return nil
+}
+
+func (p *Pool) get() (*conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("redis: pool is closed")
+	}
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	nc, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{c: nc, br: bufio.NewReader(nc), bw: bufio.NewWriter(nc)}
+	if p.password != "" {
+		if _, err := c.do(p.timeout, []string{"AUTH", p.password}); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if p.db != 0 {
+		if _, err := c.do(p.timeout, []string{"SELECT", strconv.Itoa(p.db)}); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *Pool) put(c *conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.max {
+		c.c.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+func (c *conn) do(timeout time.Duration, args []string) (interface{}, error) {
+	c.c.SetDeadline(time.Now().Add(timeout))
+	if err := writeCommand(c.bw, args); err != nil {
+		return nil, err
+	}
+	if err := c.bw.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.br)
+}
+
+func writeCommand(w *bufio.Writer, args []string) error {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(len(args)))
+	w.WriteString("\r\n")
+	for _, arg := range args {
+		w.WriteByte('$')
+		w.WriteString(strconv.Itoa(len(arg)))
+		w.WriteString("\r\n")
+		w.WriteString(arg)
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: invalid reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		bs := make([]byte, n+2)
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return nil, err
+		}
+		return bs[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			//数组中的错误不影响其他元素
+			v, err := readReply(r)
+			if e, ok := err.(Error); ok {
+				v, err = e, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = v
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", line)
+}
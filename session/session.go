@@ -9,3 +9,20 @@ type Sessioner interface {
 	Reset() //删除session中所有元素
 }
 
+
+//需要在请求结束时写回存储的session实现这个接口，例如file、redis等provider
+//session中间件会在调用链结束后调用Save
+type Saver interface {
+	Save() error
+}
+
+//数据保存在客户端的session没有办法通过manager更换sid，由session自己完成
+//Context.RegenerateSession会优先使用它
+type Regenerator interface {
+	Regenerate() (string, error)
+}
+
+//持有锁之类资源的session，请求结束保存之后Context会调用Release
+type Releaser interface {
+	Release()
+}
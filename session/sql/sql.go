@@ -0,0 +1,459 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"mux/session"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("session: session not found")
+
+//保存时数据库中的session已经被其他请求修改过
+var ErrConflict = errors.New("session: session was modified concurrently")
+
+type Config struct {
+	//表名，default:mux_session
+	Table string
+	//参数的占位符，"?"用于sqlite、mysql，"$"用于postgres的$1、$2，default:"?"
+	Placeholder string
+	//CreateTable中data列的类型，default:BLOB
+	DataType string
+	//序列化session使用的codec，default:session.DefaultCodec
+	Codec session.Codec
+	//Lock加的锁最长持有的时间，超过后其他请求可以获得锁，应当大于请求的处理时间，default:1m
+	LockLease time.Duration
+}
+
+//使用database/sql保存session，可以配合任意的驱动
+//表结构如下，postgres中data的类型应当为BYTEA，mysql中可以使用LONGBLOB
+//	CREATE TABLE mux_session (
+//		sid      VARCHAR(128) NOT NULL PRIMARY KEY,
+//		data     BLOB         NOT NULL,
+//		version  BIGINT       NOT NULL,
+//		accessed BIGINT       NOT NULL,
+//		lock_token VARCHAR(64) NOT NULL DEFAULT '',
+//		lock_until BIGINT      NOT NULL DEFAULT 0,
+//		uid      VARCHAR(128) NOT NULL DEFAULT ''
+//	);
+//	CREATE INDEX mux_session_accessed ON mux_session (accessed);
+//	CREATE INDEX mux_session_uid ON mux_session (uid);
+//Create时插入行，Save只按照version更新已经存在的行，不是upsert，原因见save的注释
+//version用于乐观锁，每次保存加一，accessed是最后访问时间的unix秒数，GC按照它删除过期的session
+//lock_token与lock_until是Lock使用的行锁，lock_until是锁过期的unix毫秒数，持有锁的进程崩溃后锁会自动过期
+//uid是BindUser关联的用户，DestroyUserSessions按照它查找session，之前创建的表需要先加上这一列
+//	ALTER TABLE mux_session ADD COLUMN uid VARCHAR(128) NOT NULL DEFAULT '';
+//provider需要数据库连接，所以不会自动注册，使用前调用session.Register
+//	p, err := sql.New(db, sql.Config{Placeholder: "$"})
+//	session.Register("sql", p)
+type SQL struct {
+	db       *sql.DB
+	table    string
+	dataType string
+	codec    session.Codec
+	lease    time.Duration
+	queries  queries
+}
+
+type queries struct {
+	selectData string
+	touch      string
+	exist      string
+	insert     string
+	update     string
+	delete     string
+	reset      string
+	resid      string
+	gc         string
+	expired    string
+	expire     string
+	count      string
+	lock       string
+	unlock     string
+	bindUser   string
+	unbindUser string
+	users      string
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+func New(db *sql.DB, conf Config) (*SQL, error) {
+	if db == nil {
+		return nil, errors.New("session: sql provider requires a db")
+	}
+	if conf.Table == "" {
+		conf.Table = "mux_session"
+	}
+	if !tableName.MatchString(conf.Table) {
+		return nil, fmt.Errorf("session: invalid table name %q", conf.Table)
+	}
+	if conf.DataType == "" {
+		conf.DataType = "BLOB"
+	}
+	var bind func(string) string
+	switch conf.Placeholder {
+	case "", "?":
+		bind = func(q string) string { return q }
+	case "$":
+		bind = dollar
+	default:
+		return nil, fmt.Errorf("session: unknown placeholder %q", conf.Placeholder)
+	}
+	t := conf.Table
+	if conf.LockLease <= 0 {
+		conf.LockLease = time.Minute
+	}
+	s := &SQL{db: db, table: t, dataType: conf.DataType, codec: conf.Codec, lease: conf.LockLease}
+	s.queries = queries{
+		selectData: bind("SELECT data, version FROM " + t + " WHERE sid = ?"),
+		touch:      bind("UPDATE " + t + " SET accessed = ? WHERE sid = ?"),
+		exist:      bind("SELECT 1 FROM " + t + " WHERE sid = ?"),
+		insert:     bind("INSERT INTO " + t + " (sid, data, version, accessed) VALUES (?, ?, ?, ?)"),
+		update:     bind("UPDATE " + t + " SET data = ?, version = version + 1, accessed = ? WHERE sid = ? AND version = ?"),
+		delete:     bind("DELETE FROM " + t + " WHERE sid = ?"),
+		reset:      "DELETE FROM " + t,
+		resid:      bind("UPDATE " + t + " SET sid = ?, accessed = ? WHERE sid = ?"),
+		gc:         bind("DELETE FROM " + t + " WHERE accessed < ?"),
+		expired:    bind("SELECT sid FROM " + t + " WHERE accessed < ?"),
+		expire:     bind("DELETE FROM " + t + " WHERE sid = ? AND accessed < ?"),
+		count:      "SELECT COUNT(*) FROM " + t,
+		lock:       bind("UPDATE " + t + " SET lock_token = ?, lock_until = ? WHERE sid = ? AND lock_until < ?"),
+		unlock:     bind("UPDATE " + t + " SET lock_until = 0 WHERE sid = ? AND lock_token = ?"),
+		bindUser:   bind("UPDATE " + t + " SET uid = ? WHERE sid = ?"),
+		unbindUser: bind("UPDATE " + t + " SET uid = '' WHERE sid = ? AND uid = ?"),
+		users:      bind("SELECT sid FROM " + t + " WHERE uid = ?"),
+	}
+	return s, nil
+}
+
+//把?换成$1、$2……
+func dollar(q string) string {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(q); i++ {
+		if q[i] == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(q[i])
+	}
+	return b.String()
+}
+
+//按照文档中的结构创建表与索引，表已经存在时什么都不做
+func (s *SQL) CreateTable() error {
+	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS " + s.table + " (" +
+		"sid VARCHAR(128) NOT NULL PRIMARY KEY, " +
+		"data " + s.dataType + " NOT NULL, " +
+		"version BIGINT NOT NULL, " +
+		"accessed BIGINT NOT NULL, " +
+		"lock_token VARCHAR(64) NOT NULL DEFAULT '', " +
+		"lock_until BIGINT NOT NULL DEFAULT 0, " +
+		"uid VARCHAR(128) NOT NULL DEFAULT '')")
+	if err != nil {
+		return err
+	}
+	prefix := strings.Replace(s.table, ".", "_", -1)
+	for _, column := range []string{"accessed", "uid"} {
+		if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS " + prefix + "_" + column + " ON " + s.table + " (" + column + ")"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQL) getCodec() session.Codec {
+	if s.codec == nil {
+		return session.DefaultCodec
+	}
+	return s.codec
+}
+
+func (s *SQL) Create(sid string) (session.Sessioner, error) {
+	sess, err := s.read(sid)
+	if err != ErrNotFound {
+		return sess, err
+	}
+	values := make(map[interface{}]interface{})
+	bs, err := s.getCodec().Encode(values)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec(s.queries.insert, sid, bs, 1, time.Now().Unix()); err != nil {
+		//并发创建同一个sid时主键冲突，读取已经创建的那个
+		if sess, rerr := s.read(sid); rerr == nil {
+			return sess, nil
+		}
+		return nil, err
+	}
+	return newSession(s, sid, values, 1), nil
+}
+
+func (s *SQL) Read(sid string) (session.Sessioner, error) {
+	return s.read(sid)
+}
+
+func (s *SQL) read(sid string) (*Session, error) {
+	var bs []byte
+	var version int64
+	err := s.db.QueryRow(s.queries.selectData, sid).Scan(&bs, &version)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	//无法解析的旧数据当作空的session，version不变，下次保存时覆盖
+	values, err := session.DecodeValues(s.getCodec(), bs)
+	if err != nil {
+		values = make(map[interface{}]interface{})
+	}
+	//只更新访问时间，不修改version，不会和正在保存的请求冲突
+	if _, err := s.db.Exec(s.queries.touch, time.Now().Unix(), sid); err != nil {
+		return nil, err
+	}
+	return newSession(s, sid, values, version), nil
+}
+
+func (s *SQL) Delete(sid string) error {
+	_, err := s.db.Exec(s.queries.delete, sid)
+	return err
+}
+
+func (s *SQL) Exist(sid string) bool {
+	var one int
+	return s.db.QueryRow(s.queries.exist, sid).Scan(&one) == nil
+}
+
+//删除表中所有的session
+func (s *SQL) Reset() {
+	s.db.Exec(s.queries.reset)
+}
+
+//给session更换一个新的sid，session中的数据与version保持不变
+func (s *SQL) ReSid(sid string) (string, error) {
+	newSid := session.NewSIDLike(sid)
+	if newSid == "" {
+		return "", errors.New("session: failed to create sid")
+	}
+	res, err := s.db.Exec(s.queries.resid, newSid, time.Now().Unix(), sid)
+	if err != nil {
+		return "", err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return "", ErrNotFound
+	}
+	return newSid, nil
+}
+
+//删除超过maxLifeTime秒没有访问的session
+func (s *SQL) GC(maxLifeTime int64) {
+	s.db.Exec(s.queries.gc, time.Now().Unix()-maxLifeTime)
+}
+
+//逐行删除过期的session并对每个sid调用expired，删除之前被访问过的session会保留
+func (s *SQL) GCNotify(maxLifeTime int64, expired func(sid string)) {
+	deadline := time.Now().Unix() - maxLifeTime
+	rows, err := s.db.Query(s.queries.expired, deadline)
+	if err != nil {
+		return
+	}
+	var sids []string
+	for rows.Next() {
+		var sid string
+		if rows.Scan(&sid) == nil {
+			sids = append(sids, sid)
+		}
+	}
+	rows.Close()
+	for _, sid := range sids {
+		res, err := s.db.Exec(s.queries.expire, sid, deadline)
+		if err != nil {
+			continue
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			expired(sid)
+		}
+	}
+}
+
+//表中session的数量，查询失败时返回0
+func (s *SQL) Len() int {
+	var n int
+	s.db.QueryRow(s.queries.count).Scan(&n)
+	return n
+}
+
+//用户索引保存在uid列中，更换sid时跟着行一起移动，删除session时一起删除
+func (s *SQL) AddUserSession(uid, sid string) error {
+	res, err := s.db.Exec(s.queries.bindUser, uid, sid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQL) RemoveUserSession(uid, sid string) error {
+	_, err := s.db.Exec(s.queries.unbindUser, sid, uid)
+	return err
+}
+
+func (s *SQL) UserSessions(uid string) ([]string, error) {
+	rows, err := s.db.Query(s.queries.users, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sids []string
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return nil, err
+		}
+		sids = append(sids, sid)
+	}
+	return sids, rows.Err()
+}
+
+//锁被占用时重试的间隔
+const lockRetry = 20 * time.Millisecond
+
+//通过session所在的行加锁，锁在LockLease之后自动过期
+//session不存在时不需要加锁，直接返回
+func (s *SQL) Lock(sid string, timeout time.Duration) (func(), error) {
+	token := session.NewSID(16)
+	deadline := time.Now().Add(timeout)
+	for {
+		now := time.Now()
+		res, err := s.db.Exec(s.queries.lock, token, now.Add(s.lease).UnixNano()/int64(time.Millisecond), sid, now.UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return func() {
+				s.db.Exec(s.queries.unlock, sid, token)
+			}, nil
+		}
+		if !s.Exist(sid) {
+			return func() {}, nil
+		}
+		if now.After(deadline) {
+			return nil, session.ErrLockTimeout
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+//保存session，只会更新已经存在的行，不会重新插入
+//读取之后有其他请求先保存了同一个session时返回ErrConflict，这次的修改不会写入
+//session已经被删除或者更换了sid时返回ErrNotFound，退出登录与撤销不会被正在处理的请求恢复
+//
+//最初的需求是保存时upsert，这里有意缩小为：插入在Create时完成，保存只按照version更新
+//保存时没有办法区分"还没有插入"与"已经被删除"，upsert会让退出登录、撤销与GC删除的session被正在处理的请求重新创建
+func (s *SQL) save(sid string, values map[interface{}]interface{}, version int64) (int64, error) {
+	bs, err := s.getCodec().Encode(values)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.db.Exec(s.queries.update, bs, time.Now().Unix(), sid, version)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		return version + 1, nil
+	}
+	if s.Exist(sid) {
+		return 0, ErrConflict
+	}
+	return 0, ErrNotFound
+}
+
+//数据库中的session，修改先保存在内存中，调用Save后才写入数据库
+type Session struct {
+	provider *SQL
+	mu       sync.RWMutex
+	sid      string
+	values   map[interface{}]interface{}
+	version  int64
+	dirty    bool
+}
+
+func newSession(p *SQL, sid string, values map[interface{}]interface{}, version int64) *Session {
+	return &Session{provider: p, sid: sid, values: values, version: version}
+}
+
+func (s *Session) Get(key interface{}) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+func (s *Session) Set(key, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = val
+	s.dirty = true
+}
+
+func (s *Session) Del(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.dirty = true
+	}
+}
+
+func (s *Session) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sid
+}
+
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[interface{}]interface{})
+	s.dirty = true
+}
+
+//读取时的version
+func (s *Session) Version() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+//把修改写入数据库，没有修改时什么都不做
+func (s *Session) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	version, err := s.provider.save(s.sid, s.values, s.version)
+	if err != nil {
+		return err
+	}
+	s.version = version
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,310 @@
+package sql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+//只认识provider使用的语句的内存数据库，用来在没有真实数据库的环境中测试
+type fakeRow struct {
+	data      []byte
+	version   int64
+	accessed  int64
+	lockToken string
+	lockUntil int64
+	uid       string
+}
+
+type fakeDB struct {
+	mu   sync.Mutex
+	rows map[string]*fakeRow
+}
+
+var (
+	fakeMu  sync.Mutex
+	fakeDBs = make(map[string]*fakeDB)
+	fakeN   int
+)
+
+func init() {
+	sql.Register("fakesql", fakeDriver{})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	return &fakeConn{db: fakeDBs[name]}, nil
+}
+
+//每个测试使用独立的数据库
+func newFakeProvider(t *testing.T) (*SQL, *fakeDB) {
+	fakeMu.Lock()
+	fakeN++
+	name := "db" + strconv.Itoa(fakeN)
+	db := &fakeDB{rows: make(map[string]*fakeRow)}
+	fakeDBs[name] = db
+	fakeMu.Unlock()
+	conn, err := sql.Open("fakesql", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(conn, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, db
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("fakesql: no transactions") }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var n int64
+	switch s.query {
+	case "INSERT INTO mux_session (sid, data, version, accessed) VALUES (?, ?, ?, ?)":
+		sid := args[0].(string)
+		if _, ok := db.rows[sid]; ok {
+			return nil, errors.New("fakesql: duplicate primary key")
+		}
+		db.rows[sid] = &fakeRow{data: args[1].([]byte), version: args[2].(int64), accessed: args[3].(int64)}
+		n = 1
+	case "UPDATE mux_session SET data = ?, version = version + 1, accessed = ? WHERE sid = ? AND version = ?":
+		if r, ok := db.rows[args[2].(string)]; ok && r.version == args[3].(int64) {
+			r.data, r.accessed = args[0].([]byte), args[1].(int64)
+			r.version++
+			n = 1
+		}
+	case "UPDATE mux_session SET accessed = ? WHERE sid = ?":
+		if r, ok := db.rows[args[1].(string)]; ok {
+			r.accessed = args[0].(int64)
+			n = 1
+		}
+	case "UPDATE mux_session SET sid = ?, accessed = ? WHERE sid = ?":
+		if r, ok := db.rows[args[2].(string)]; ok {
+			delete(db.rows, args[2].(string))
+			r.accessed = args[1].(int64)
+			db.rows[args[0].(string)] = r
+			n = 1
+		}
+	case "DELETE FROM mux_session WHERE sid = ?":
+		if _, ok := db.rows[args[0].(string)]; ok {
+			delete(db.rows, args[0].(string))
+			n = 1
+		}
+	case "DELETE FROM mux_session":
+		n = int64(len(db.rows))
+		db.rows = make(map[string]*fakeRow)
+	case "DELETE FROM mux_session WHERE accessed < ?":
+		for sid, r := range db.rows {
+			if r.accessed < args[0].(int64) {
+				delete(db.rows, sid)
+				n++
+			}
+		}
+	case "DELETE FROM mux_session WHERE sid = ? AND accessed < ?":
+		if r, ok := db.rows[args[0].(string)]; ok && r.accessed < args[1].(int64) {
+			delete(db.rows, args[0].(string))
+			n = 1
+		}
+	case "UPDATE mux_session SET lock_token = ?, lock_until = ? WHERE sid = ? AND lock_until < ?":
+		if r, ok := db.rows[args[2].(string)]; ok && r.lockUntil < args[3].(int64) {
+			r.lockToken, r.lockUntil = args[0].(string), args[1].(int64)
+			n = 1
+		}
+	case "UPDATE mux_session SET lock_until = 0 WHERE sid = ? AND lock_token = ?":
+		if r, ok := db.rows[args[0].(string)]; ok && r.lockToken == args[1].(string) {
+			r.lockUntil = 0
+			n = 1
+		}
+	case "UPDATE mux_session SET uid = ? WHERE sid = ?":
+		if r, ok := db.rows[args[1].(string)]; ok {
+			r.uid = args[0].(string)
+			n = 1
+		}
+	case "UPDATE mux_session SET uid = '' WHERE sid = ? AND uid = ?":
+		if r, ok := db.rows[args[0].(string)]; ok && r.uid == args[1].(string) {
+			r.uid = ""
+			n = 1
+		}
+	default:
+		return nil, errors.New("fakesql: unsupported statement: " + s.query)
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	rows := &fakeRows{}
+	switch s.query {
+	case "SELECT data, version FROM mux_session WHERE sid = ?":
+		rows.columns = []string{"data", "version"}
+		if r, ok := db.rows[args[0].(string)]; ok {
+			rows.values = append(rows.values, []driver.Value{append([]byte(nil), r.data...), r.version})
+		}
+	case "SELECT 1 FROM mux_session WHERE sid = ?":
+		rows.columns = []string{"1"}
+		if _, ok := db.rows[args[0].(string)]; ok {
+			rows.values = append(rows.values, []driver.Value{int64(1)})
+		}
+	case "SELECT sid FROM mux_session WHERE accessed < ?":
+		rows.columns = []string{"sid"}
+		for sid, r := range db.rows {
+			if r.accessed < args[0].(int64) {
+				rows.values = append(rows.values, []driver.Value{sid})
+			}
+		}
+	case "SELECT COUNT(*) FROM mux_session":
+		rows.columns = []string{"count"}
+		rows.values = append(rows.values, []driver.Value{int64(len(db.rows))})
+	case "SELECT sid FROM mux_session WHERE uid = ?":
+		rows.columns = []string{"sid"}
+		for sid, r := range db.rows {
+			if r.uid == args[0].(string) {
+				rows.values = append(rows.values, []driver.Value{sid})
+			}
+		}
+	default:
+		return nil, errors.New("fakesql: unsupported query: " + s.query)
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestConflict(t *testing.T) {
+	p, _ := newFakeProvider(t)
+	if _, err := p.Create("sid1"); err != nil {
+		t.Fatal(err)
+	}
+	//两个请求读取了同一个版本，先保存的成功，后保存的得到ErrConflict
+	a, _ := p.Read("sid1")
+	b, _ := p.Read("sid1")
+	a.Set("who", "a")
+	b.Set("who", "b")
+	if err := a.(*Session).Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.(*Session).Save(); err != ErrConflict {
+		t.Fatalf("second save: %v, want ErrConflict", err)
+	}
+	got, _ := p.Read("sid1")
+	if got.Get("who") != "a" || got.(*Session).Version() != 2 {
+		t.Fatalf("stored %v at version %d", got.Get("who"), got.(*Session).Version())
+	}
+	//重新读取之后可以保存
+	got.Set("who", "b")
+	if err := got.(*Session).Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveAfterDestroy(t *testing.T) {
+	p, db := newFakeProvider(t)
+	p.Create("gone")
+	p.Create("renamed")
+
+	//正在处理的请求在退出登录之后保存，session不能被恢复
+	inflight, _ := p.Read("gone")
+	p.Delete("gone")
+	inflight.Set("user", "alice")
+	if err := inflight.(*Session).Save(); err != ErrNotFound {
+		t.Fatalf("save after delete: %v, want ErrNotFound", err)
+	}
+	if p.Exist("gone") {
+		t.Fatal("deleted session was recreated")
+	}
+
+	old, _ := p.Read("renamed")
+	newSid, err := p.ReSid("renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Set("user", "alice")
+	if err := old.(*Session).Save(); err != ErrNotFound {
+		t.Fatalf("save after ReSid: %v, want ErrNotFound", err)
+	}
+	if p.Exist("renamed") || !p.Exist(newSid) || len(db.rows) != 1 {
+		t.Fatalf("rows after ReSid: %d", len(db.rows))
+	}
+
+	//GC清理之后同样不会恢复
+	expired, _ := p.Read(newSid)
+	db.rows[newSid].accessed = 0
+	p.GC(60)
+	expired.Set("user", "alice")
+	if err := expired.(*Session).Save(); err != ErrNotFound || p.Len() != 0 {
+		t.Fatalf("save after GC: %v, %d rows", err, p.Len())
+	}
+}
+
+func TestUserIndex(t *testing.T) {
+	p, _ := newFakeProvider(t)
+	p.Create("a")
+	p.Create("b")
+	p.Create("other")
+	for _, sid := range []string{"a", "b"} {
+		if err := p.AddUserSession("alice", sid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.AddUserSession("bob", "other")
+	if err := p.AddUserSession("alice", "missing"); err != ErrNotFound {
+		t.Fatalf("index missing session: %v", err)
+	}
+
+	//索引跟着sid一起移动，删除session之后不再出现
+	newSid, _ := p.ReSid("a")
+	p.Delete("b")
+	sids, err := p.UserSessions("alice")
+	if err != nil || len(sids) != 1 || sids[0] != newSid {
+		t.Fatalf("UserSessions = %v, %v, want [%s]", sids, err, newSid)
+	}
+	//只删除属于这个用户的记录
+	p.RemoveUserSession("alice", "other")
+	p.RemoveUserSession("alice", newSid)
+	if sids, _ := p.UserSessions("alice"); len(sids) != 0 {
+		t.Fatalf("after remove: %v", sids)
+	}
+	if sids, _ := p.UserSessions("bob"); len(sids) != 1 {
+		t.Fatalf("bob: %v", sids)
+	}
+}
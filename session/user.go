@@ -0,0 +1,147 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+//Manage保存在session中的数据
+const (
+	//创建时间的unix秒数
+	CreatedKey = "_created"
+	//最后访问时间的unix秒数
+	AccessedKey = "_accessed"
+	//BindUser关联的用户
+	UserKey = "_uid"
+)
+
+//用户到sid的索引，provider实现它之后才能按照用户删除session
+//索引必须和session保存在同一个地方，重启之后与多个实例之间都有效
+type UserIndexer interface {
+	AddUserSession(uid,sid string) error
+	RemoveUserSession(uid,sid string) error
+	UserSessions(uid string) ([]string,error)
+}
+
+//provider没有实现UserIndexer，DestroyUserSessions无法找到用户的session
+var ErrNoUserIndex = errors.New("session: provider does not support user index")
+
+func (m *Manage) userIndex() (UserIndexer,bool) {
+	idx, ok := m.provider.(UserIndexer)
+	return idx,ok
+}
+
+//把session和用户关联起来，登录成功后调用
+//provider没有实现UserIndexer时只在session中记录用户
+func (m *Manage) BindUser(sess Sessioner,uid string) error {
+	if err := m.init(); err != nil {
+		return err
+	}
+	if uid == "" {
+		return errors.New("session: empty uid")
+	}
+	idx, ok := m.userIndex()
+	if old, _ := sess.Get(UserKey).(string); ok && old != "" && old != uid {
+		idx.RemoveUserSession(old,sess.ID())
+	}
+	sess.Set(UserKey,uid)
+	if !ok {
+		return nil
+	}
+	return idx.AddUserSession(uid,sess.ID())
+}
+
+//删除用户所有的session，provider没有实现UserIndexer时返回ErrNoUserIndex
+func (m *Manage) DestroyUserSessions(uid string) error {
+	if err := m.init(); err != nil {
+		return err
+	}
+	idx, ok := m.userIndex()
+	if !ok {
+		return ErrNoUserIndex
+	}
+	sids, err := idx.UserSessions(uid)
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, sid := range sids {
+		if err := m.provider.Delete(sid); err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		idx.RemoveUserSession(uid,sid)
+		m.destroyed(sid)
+	}
+	return first
+}
+
+//更换sid之后更新索引
+func (m *Manage) rebindUser(oldSid,newSid string) {
+	sess, err := m.provider.Read(newSid)
+	if err != nil {
+		return
+	}
+	idx, ok := m.userIndex()
+	if uid, _ := sess.Get(UserKey).(string); ok && uid != "" {
+		idx.RemoveUserSession(uid,oldSid)
+		idx.AddUserSession(uid,newSid)
+	}
+}
+
+//新创建的session记录创建时间
+func (m *Manage) start(sess Sessioner) {
+	if m.conf.IdleTimeout <= 0 && m.conf.AbsoluteTimeout <= 0 {
+		return
+	}
+	now := time.Now().Unix()
+	sess.Set(CreatedKey,now)
+	sess.Set(AccessedKey,now)
+}
+
+func (m *Manage) expired(sess Sessioner) bool {
+	now := time.Now().Unix()
+	if t := m.conf.AbsoluteTimeout; t > 0 {
+		//没有创建时间的session是打开超时之前创建的，从现在开始计算
+		if created, ok := sess.Get(CreatedKey).(int64); ok && now-created >= t {
+			return true
+		}
+	}
+	if t := m.conf.IdleTimeout; t > 0 {
+		if accessed, ok := sess.Get(AccessedKey).(int64); ok && now-accessed >= t {
+			return true
+		}
+	}
+	return false
+}
+
+//更新访问时间，每次都写入会让持久化的provider每个请求都保存一次，所以只在过了超时时间的十分之一后更新
+func (m *Manage) touch(sess Sessioner) {
+	if m.conf.IdleTimeout <= 0 && m.conf.AbsoluteTimeout <= 0 {
+		return
+	}
+	now := time.Now().Unix()
+	if _, ok := sess.Get(CreatedKey).(int64); !ok {
+		sess.Set(CreatedKey,now)
+	}
+	step := m.conf.IdleTimeout / 10
+	if step < 1 {
+		step = 1
+	}
+	if accessed, ok := sess.Get(AccessedKey).(int64); !ok || now-accessed >= step {
+		sess.Set(AccessedKey,now)
+	}
+}
+
+//删除过期的session
+func (m *Manage) destroy(sess Sessioner) {
+	m.provider.Delete(sess.ID())
+	if idx, ok := m.userIndex(); ok {
+		if uid, _ := sess.Get(UserKey).(string); uid != "" {
+			idx.RemoveUserSession(uid,sess.ID())
+		}
+	}
+	m.expiredSid(sess.ID())
+}
@@ -0,0 +1,113 @@
+package session_test
+
+import (
+	"mux/session"
+	"mux/session/memory"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestManage(t *testing.T, idle, absolute int64) *session.Manage {
+	m, err := session.NewManage(&session.ManagerConf{
+		ProviderName:    "memory",
+		CookieName:      "sid",
+		EnableSetCookie: true,
+		IdleTimeout:     idle,
+		AbsoluteTimeout: absolute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func withCookie(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestTimeouts(t *testing.T) {
+	for _, c := range []struct {
+		name           string
+		idle, absolute int64
+		key            string
+	}{
+		{"idle", 60, 0, session.AccessedKey},
+		{"absolute", 0, 60, session.CreatedKey},
+	} {
+		m := newTestManage(t, c.idle, c.absolute)
+		w := httptest.NewRecorder()
+		sess, err := m.Session(w, httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sid := sess.ID()
+
+		again, _ := m.Session(httptest.NewRecorder(), withCookie(w))
+		if again.ID() != sid {
+			t.Fatalf("%s: session was replaced before the timeout", c.name)
+		}
+
+		sess.Set(c.key, time.Now().Add(-time.Hour).Unix())
+		expired, _ := m.Session(httptest.NewRecorder(), withCookie(w))
+		if expired.ID() == sid {
+			t.Fatalf("%s: expired session was returned", c.name)
+		}
+		if _, err := m.SessionByID(sid); err == nil {
+			t.Fatalf("%s: expired session still exists", c.name)
+		}
+	}
+}
+
+func TestDestroyUserSessions(t *testing.T) {
+	m := newTestManage(t, 0, 0)
+	var sids []string
+	for i := 0; i < 3; i++ {
+		sess, _ := m.Session(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		uid := "alice"
+		if i == 2 {
+			uid = "bob"
+		}
+		if err := m.BindUser(sess, uid); err != nil {
+			t.Fatal(err)
+		}
+		sids = append(sids, sess.ID())
+	}
+	if err := m.DestroyUserSessions("alice"); err != nil {
+		t.Fatal(err)
+	}
+	for i, sid := range sids {
+		_, err := m.SessionByID(sid)
+		if alive := err == nil; alive != (i == 2) {
+			t.Fatalf("session %d alive = %v", i, alive)
+		}
+	}
+}
+
+//只实现了Provider的存储，没有用户索引
+type noIndexProvider struct{ session.Provider }
+
+func TestNoUserIndex(t *testing.T) {
+	name := uniqueName("noindex")
+	session.Register(name, noIndexProvider{memory.NewMemory()})
+	m, err := session.NewManage(&session.ManagerConf{ProviderName: name, CookieName: "sid", EnableSetCookie: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, _ := m.Session(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	//登录不受影响，只是无法按照用户删除
+	if err := m.BindUser(sess, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if sess.Get(session.UserKey) != "alice" {
+		t.Fatalf("UserKey = %v", sess.Get(session.UserKey))
+	}
+	if err := m.DestroyUserSessions("alice"); err != session.ErrNoUserIndex {
+		t.Fatalf("DestroyUserSessions = %v, want ErrNoUserIndex", err)
+	}
+}